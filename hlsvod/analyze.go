@@ -0,0 +1,123 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+)
+
+// Compatibility tells whether source can be segmented for HLS by stream copy, i.e. without
+// encoding, and what would need to be encoded otherwise.
+type Compatibility struct {
+	CopyVideo bool // H.264 8-bit 4:2:0, limited range, square pixels and no rotation.
+	CopyAudio bool // AAC, or no audio stream at all.
+	HasAudio  bool
+
+	// Every gap between consecutive keyframes, and the tail after the last one, is not longer
+	// than target segment duration, so that copied segments are never longer than it.
+	KeyframesAligned    bool
+	MaxKeyframeInterval float64 // in seconds
+
+	// Why streams cannot be copied, e.g. "video codec hevc is not h264", empty if HLS-ready.
+	Reasons []string
+}
+
+// HLSReady returns whether both streams can be copied into segments of target duration.
+func (c *Compatibility) HLSReady() bool {
+	return c.CopyVideo && c.CopyAudio && c.KeyframesAligned
+}
+
+// Analyze probes source, so that caller can choose between stream copy and encode. It consolidates
+// video and audio format probing with keyframe times, that are read from all packets, so that
+// it takes a while for long sources.
+func Analyze(ctx context.Context, ffprobeBinary string, inputPath string, targetSegmentDur float64) (*Compatibility, error) {
+	if targetSegmentDur <= 0 {
+		return nil, fmt.Errorf("%w: target segment duration must be positive", ErrInvalidConfig)
+	}
+
+	videoInfo, err := detectVideoFormat(ctx, ffprobeBinary, inputPath, InputOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	audioStreams, err := detectAudioStreams(ctx, ffprobeBinary, inputPath, InputOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	keyframeTimes, err := KeyframeTimes(ctx, ffprobeBinary, inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	media, err := ProbeMedia(ctx, ffprobeBinary, inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Compatibility{}
+
+	c.Reasons = videoCopyIssues(videoInfo)
+	c.CopyVideo = len(c.Reasons) == 0
+
+	c.CopyAudio = true
+	if len(audioStreams) > 0 {
+		c.HasAudio = true
+
+		if codec := audioStreams[0].CodecName; codec != "aac" {
+			c.CopyAudio = false
+			c.Reasons = append(c.Reasons, fmt.Sprintf("audio codec %s is not aac", codec))
+		}
+	}
+
+	c.MaxKeyframeInterval = maxKeyframeInterval(keyframeTimes, media.Duration.Seconds())
+	c.KeyframesAligned = len(keyframeTimes) > 0 && c.MaxKeyframeInterval <= targetSegmentDur+segmentTimeDelta
+	if !c.KeyframesAligned {
+		c.Reasons = append(c.Reasons, fmt.Sprintf("keyframe interval %.3fs exceeds target segment duration", c.MaxKeyframeInterval))
+	}
+
+	return c, nil
+}
+
+// returns reasons, why source video cannot be copied into MPEG-TS segments
+func videoCopyIssues(info *VideoInfo) []string {
+	issues := []string{}
+
+	if info.CodecName != "h264" {
+		issues = append(issues, fmt.Sprintf("video codec %s is not h264", info.CodecName))
+	}
+
+	if info.PixelFormat != "yuv420p" {
+		issues = append(issues, fmt.Sprintf("pixel format %s is not yuv420p", info.PixelFormat))
+	}
+
+	if isFullRange(info) {
+		issues = append(issues, "video is full range")
+	}
+
+	if info.isAnamorphic() {
+		issues = append(issues, fmt.Sprintf("sample aspect ratio %s is not square", info.SampleAspectRatio))
+	}
+
+	// MPEG-TS cannot carry display matrix
+	if rotation := info.rotation(); rotation != 0 {
+		issues = append(issues, fmt.Sprintf("video is rotated by %d degrees", rotation))
+	}
+
+	return issues
+}
+
+// returns the longest gap between keyframes, including the tail after the last one
+func maxKeyframeInterval(keyframeTimes []float64, duration float64) float64 {
+	if len(keyframeTimes) == 0 {
+		return duration
+	}
+
+	maxInterval := duration - keyframeTimes[len(keyframeTimes)-1]
+	for i := 1; i < len(keyframeTimes); i++ {
+		if interval := keyframeTimes[i] - keyframeTimes[i-1]; interval > maxInterval {
+			maxInterval = interval
+		}
+	}
+
+	return maxInterval
+}
@@ -0,0 +1,78 @@
+package hlsvod
+
+import "fmt"
+
+// AppendPoint is the end of output produced by previous encodes of a growing source,
+// e.g. recording that is still being written. Appended segments continue numbering
+// and, thanks to -copyts, timestamps of the existing segments.
+type AppendPoint struct {
+	NextSegment int     // Number of the first appended segment.
+	Time        float64 // Source timestamp, where the last existing segment ends.
+}
+
+func (point *AppendPoint) validate(config *TranscodeConfig) error {
+	if point.NextSegment < 0 || point.Time < 0 {
+		return fmt.Errorf("%w: append point must not be negative", ErrInvalidConfig)
+	}
+
+	// resume fills missing segments, append extends the end, they would fight over offset
+	if config.Resume {
+		return fmt.Errorf("%w: resume cannot be used with append", ErrInvalidConfig)
+	}
+
+	if config.TrimStart > 0 || config.TrimEnd > 0 {
+		return fmt.Errorf("%w: trimming cannot be used with append", ErrInvalidConfig)
+	}
+
+	if config.InputReader != nil {
+		return fmt.Errorf("%w: streamed input cannot be appended", ErrInvalidConfig)
+	}
+
+	// appended segments must continue timestamps of the existing ones
+	if config.ResetTimestamps {
+		return fmt.Errorf("%w: reset timestamps cannot be used with append", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// returns segment times of the new tail, that starts exactly where the existing output
+// ends, since times computed from the grown source may no longer contain that point
+func (point *AppendPoint) segmentTimes(segmentTimes []float64) []float64 {
+	tail := []float64{point.Time}
+	for _, segmentTime := range segmentTimes {
+		// too short segment would be produced
+		if segmentTime <= point.Time+segmentTimeDelta {
+			continue
+		}
+		tail = append(tail, segmentTime)
+	}
+
+	return tail
+}
+
+// AppendPoint returns where the next append continues after this encode.
+func (manifest *Manifest) AppendPoint() AppendPoint {
+	return AppendPoint{
+		NextSegment: manifest.SegmentOffset + len(manifest.Segments),
+		Time:        manifest.Start + manifest.Duration,
+	}
+}
+
+// PlaylistEntries returns #EXTINF entries of the segments, so that segments of appended
+// encode can be added to the existing playlist, before its #EXT-X-ENDLIST.
+func (manifest *Manifest) PlaylistEntries() []string {
+	entries := []string{}
+	for _, segment := range manifest.Segments {
+		if segment.Discontinuity {
+			entries = append(entries, "#EXT-X-DISCONTINUITY")
+		}
+
+		entries = append(entries,
+			fmt.Sprintf("#EXTINF:%.3f, no desc", segment.Duration),
+			segment.Name,
+		)
+	}
+
+	return entries
+}
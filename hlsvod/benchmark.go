@@ -0,0 +1,96 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// BenchResult is throughput of encoding synthetic test pattern.
+type BenchResult struct {
+	Segments int   // Number of encoded segments.
+	Size     int64 // Total size of segments in bytes.
+
+	// Speed and FPS are the capacity figures, e.g. speed 4x means that
+	// four encodes of this profile can run in real time.
+	Metrics EncodeMetrics
+}
+
+// segment duration of benchmark encodes, in seconds
+const benchSegmentDuration = 4
+
+// Benchmark encodes test pattern with sine tone generated by ffmpeg, so that performance of
+// a deployment can be measured without any asset. Pattern has the profile size at 30 fps and
+// goes through the same argument building, probing and segmenting as real encodes. Segments
+// are written to a temporary directory, that is removed afterwards.
+func Benchmark(ctx context.Context, ffmpegBinary string, profile *VideoProfile, durationSec int) (*BenchResult, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("%w: benchmark requires video profile", ErrInvalidConfig)
+	}
+
+	if durationSec <= 0 {
+		return nil, fmt.Errorf("%w: benchmark duration must be positive", ErrInvalidConfig)
+	}
+
+	if err := profile.validate(); err != nil {
+		return nil, err
+	}
+
+	outputDir, err := os.MkdirTemp("", "hlsvod-benchmark-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create benchmark directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// pattern has the output size, so that it is not scaled
+	width, height := profile.Width, profile.Height
+	if profile.Resolution != 0 {
+		width, height = profile.Resolution.Dimensions()
+	}
+
+	segmentTimes := []float64{}
+	for t := 0; t < durationSec; t += benchSegmentDuration {
+		segmentTimes = append(segmentTimes, float64(t))
+	}
+	segmentTimes = append(segmentTimes, float64(durationSec))
+
+	var metrics EncodeMetrics
+	job, err := NewEncoder(ffmpegBinary, "").Start(ctx, TranscodeConfig{
+		// lavfi outputs labelled outN are exposed as streams of the input
+		InputFilePath: fmt.Sprintf("testsrc=duration=%d:size=%dx%d:rate=30[out0];sine=duration=%d:sample_rate=48000[out1]", durationSec, width, height, durationSec),
+		InputOptions:  InputOptions{Format: "lavfi"},
+		OutputDirPath: outputDir,
+		SegmentPrefix: "benchmark",
+		SegmentTimes:  segmentTimes,
+		VideoProfile:  profile,
+		AudioProfile:  &AudioProfile{Bitrate: 128},
+		JobID:         "benchmark",
+		MetricsHook: func(m EncodeMetrics) {
+			metrics = m
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// segments are only counted, channel must be drained
+	segments := 0
+	for range job.Segments() {
+		segments++
+	}
+
+	if err := job.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := &BenchResult{
+		Segments: segments,
+		Metrics:  metrics,
+	}
+
+	for _, segment := range job.Manifest().Segments {
+		result.Size += segment.Size
+	}
+
+	return result, nil
+}
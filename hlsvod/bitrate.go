@@ -0,0 +1,128 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+)
+
+// BitrateVerification compares average bitrate of produced segments with the profile bitrates,
+// so that BANDWIDTH announced by playlists is not exceeded. Only overshoot is verified, since
+// lower bitrate, e.g. of simple content encoded using CRF, does not break adaptive streaming.
+type BitrateVerification struct {
+	Tolerance float64 // Allowed relative overshoot, e.g. 0.3 for 30% above target.
+	// Number of re-encodes with bitrate capped proportionally to the overshoot, the encode is
+	// only verified when zero. Every retry encodes the whole range again, so that keep it low.
+	Retries int
+}
+
+func (verification *BitrateVerification) validate(config *TranscodeConfig) error {
+	if verification.Tolerance <= 0 {
+		return fmt.Errorf("%w: bitrate tolerance must be positive", ErrInvalidConfig)
+	}
+
+	if verification.Retries < 0 {
+		return fmt.Errorf("%w: bitrate retries must not be negative", ErrInvalidConfig)
+	}
+
+	if config.VideoProfile == nil || config.VideoProfile.Bitrate <= 0 {
+		return fmt.Errorf("%w: bitrate verification requires video profile bitrate", ErrInvalidConfig)
+	}
+
+	// sizes of sunk segments are unknown
+	if config.SegmentSink != nil {
+		return fmt.Errorf("%w: bitrate verification cannot be used with segment sink", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// returns average bitrate of manifest segments in kbit/s, 0 if unknown
+func averageBitrate(manifest *Manifest) float64 {
+	var size int64
+	var duration float64
+	for _, segment := range manifest.Segments {
+		size += segment.Size
+		duration += segment.Duration
+	}
+
+	if size == 0 || duration <= 0 {
+		return 0
+	}
+	return float64(size) * 8 / 1000 / duration
+}
+
+// returns profile with bitrate capped proportionally to the overshoot
+func capBitrate(profile *VideoProfile, ratio float64) *VideoProfile {
+	capped := *profile
+	if capped.MaxRate == 0 {
+		// peaks are capped at target first
+		capped.MaxRate = profile.Bitrate
+	} else {
+		capped.MaxRate = int(float64(profile.MaxRate) * ratio)
+	}
+
+	// max rate must not be lower, bitrate is only an estimate when CRF is used
+	if capped.Bitrate > capped.MaxRate {
+		capped.Bitrate = capped.MaxRate
+	}
+
+	// buffer follows max rate
+	capped.BufSize = 0
+	return &capped
+}
+
+// TranscodeVerified encodes the whole range and waits for it, then verifies its average bitrate
+// and re-encodes it with capped bitrate, while it exceeds the tolerance and retries are left.
+// Segments are overwritten by every retry, hooks are called for every encode. Returns manifest
+// of the last encode, ErrBitrateExceeded if it still exceeds the tolerance.
+func (e *Encoder) TranscodeVerified(ctx context.Context, config TranscodeConfig, verification BitrateVerification) (*Manifest, error) {
+	e.applyDefaults(&config)
+
+	if err := verification.validate(&config); err != nil {
+		return nil, err
+	}
+
+	logger := config.jobLogger(ctx, e.logger)
+
+	target := float64(config.VideoProfile.Bitrate)
+	if config.AudioProfile != nil {
+		target += float64(config.AudioProfile.Bitrate)
+	}
+
+	for attempt := 0; ; attempt++ {
+		job, err := e.Start(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+
+		// segments are verified only once all of them exist
+		for range job.Segments() {
+		}
+
+		if err := job.Wait(); err != nil {
+			return nil, err
+		}
+
+		manifest := job.Manifest()
+		actual := averageBitrate(manifest)
+		if actual <= target*(1+verification.Tolerance) {
+			return manifest, nil
+		}
+
+		if attempt >= verification.Retries {
+			return manifest, fmt.Errorf("%w: %.0f kbit/s, target %.0f kbit/s", ErrBitrateExceeded, actual, target)
+		}
+
+		logger.Warn().Float64("bitrate", actual).Float64("target", target).Msg("output bitrate exceeds target, encoding again with capped bitrate")
+		config.warn(Warning{
+			Kind:    WarningBitrateExceeded,
+			Message: fmt.Sprintf("output bitrate %.0f kbit/s exceeds target %.0f kbit/s", actual, target),
+		})
+
+		// whole range is encoded again, replacing segments of the previous attempt, that
+		// would be skipped or rejected by the overwrite policy of the caller
+		config.VideoProfile = capBitrate(config.VideoProfile, target/actual)
+		config.Resume = false
+		config.Overwrite = OverwriteAlways
+	}
+}
@@ -0,0 +1,57 @@
+package hlsvod
+
+import "fmt"
+
+// portion of the output taken by MPEG-TS packet and PES headers
+const containerOverhead = 0.05
+
+// BitrateForTargetSize returns video bitrate in kbit/s, so that output of given
+// duration with given audio bitrate fits into the target size, including container
+// overhead. Returns 0 if the target is too small to fit even the audio.
+func BitrateForTargetSize(durationSec float64, targetBytes int64, audioKbps int) int {
+	if durationSec <= 0 || targetBytes <= 0 {
+		return 0
+	}
+
+	totalKbps := float64(targetBytes) * 8 / 1000 / durationSec * (1 - containerOverhead)
+
+	videoKbps := int(totalKbps) - audioKbps
+	if videoKbps < 0 {
+		return 0
+	}
+	return videoKbps
+}
+
+// FitTargetSize sets video bitrate, so that the whole encode given by segment times fits
+// into the target size. Video profile is copied, since it can be shared by other configs,
+// and its max rate is dropped, since peaks must be averaged out to meet the budget.
+func (config *TranscodeConfig) FitTargetSize(targetBytes int64) error {
+	if config.VideoProfile == nil {
+		return fmt.Errorf("%w: target size requires video profile", ErrInvalidConfig)
+	}
+
+	if len(config.SegmentTimes) < 2 {
+		return fmt.Errorf("%w: got %d", ErrTooFewSegmentTimes, len(config.SegmentTimes))
+	}
+
+	duration := config.SegmentTimes[len(config.SegmentTimes)-1] - config.SegmentTimes[0]
+
+	audioKbps := 0
+	if config.AudioProfile != nil {
+		audioKbps = config.AudioProfile.Bitrate
+	}
+
+	bitrate := BitrateForTargetSize(duration, targetBytes, audioKbps)
+	if bitrate == 0 {
+		return fmt.Errorf("%w: target size %d bytes is too small for %.3fs", ErrInvalidConfig, targetBytes, duration)
+	}
+
+	profile := *config.VideoProfile
+	profile.Bitrate = bitrate
+	profile.CRF = 0
+	profile.MaxRate = 0
+	profile.BufSize = 0
+	config.VideoProfile = &profile
+
+	return nil
+}
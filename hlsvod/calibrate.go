@@ -0,0 +1,190 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// x264 presets from the fastest to the slowest
+var x264Presets = []string{
+	"ultrafast", "superfast", "veryfast", "faster", "fast",
+	"medium", "slow", "slower", "veryslow", "placebo",
+}
+
+// default preset of encodes, it trades some quality for speed
+const defaultX264Preset = "faster"
+
+func isX264Preset(preset string) bool {
+	for _, p := range x264Presets {
+		if p == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// Approximate speed of x264 presets relative to medium, typical for HD content. Real ratios
+// depend on content and resolution, so that extrapolated speed is only an estimate.
+var x264PresetSpeed = map[string]float64{
+	"ultrafast": 8,
+	"superfast": 5.5,
+	"veryfast":  3.5,
+	"faster":    2.2,
+	"fast":      1.4,
+	"medium":    1,
+	"slow":      0.6,
+	"slower":    0.3,
+	"veryslow":  0.15,
+	"placebo":   0.05,
+}
+
+// preset encoded by calibration, speed of others is extrapolated from it
+const calibrationPreset = "medium"
+
+// PresetCalibration describes deadline, that the encode must meet.
+type PresetCalibration struct {
+	Deadline       time.Duration // Whole encode must finish within it.
+	SampleDuration float64       // Seconds encoded by calibration, 5 when zero.
+	Margin         float64       // Required speed is multiplied by it, 1.2 when zero.
+}
+
+const (
+	defaultCalibrationSample = 5
+	defaultCalibrationMargin = 1.2
+)
+
+func (calibration *PresetCalibration) validate(config *TranscodeConfig) error {
+	if calibration.Deadline <= 0 {
+		return fmt.Errorf("%w: calibration deadline must be positive", ErrInvalidConfig)
+	}
+
+	if calibration.SampleDuration < 0 || calibration.Margin < 0 {
+		return fmt.Errorf("%w: calibration sample duration and margin must not be negative", ErrInvalidConfig)
+	}
+
+	if config.VideoProfile == nil {
+		return fmt.Errorf("%w: calibration requires video profile", ErrInvalidConfig)
+	}
+
+	if len(config.SegmentTimes) < 2 {
+		return ErrTooFewSegmentTimes
+	}
+
+	// stream can be read only once
+	if config.InputReader != nil {
+		return fmt.Errorf("%w: calibration cannot be used with streamed input", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// CalibratePreset encodes a short sample from the start of the encode using the medium preset,
+// measures its speed and extrapolates speed of other presets. It returns the slowest preset,
+// i.e. of the best quality, whose estimated speed meets the deadline, or ultrafast if none does.
+// The preset is meant to be set as VideoProfile.Preset of the full encode. Sample is encoded
+// with the same config, but without hooks and additional outputs, into a temporary directory.
+func (e *Encoder) CalibratePreset(ctx context.Context, config TranscodeConfig, calibration PresetCalibration) (string, error) {
+	e.applyDefaults(&config)
+
+	if err := calibration.validate(&config); err != nil {
+		return "", err
+	}
+
+	sampleDuration := calibration.SampleDuration
+	if sampleDuration == 0 {
+		sampleDuration = defaultCalibrationSample
+	}
+
+	margin := calibration.Margin
+	if margin == 0 {
+		margin = defaultCalibrationMargin
+	}
+
+	start := config.SegmentTimes[0]
+	end := config.SegmentTimes[len(config.SegmentTimes)-1]
+	requiredSpeed := (end - start) / calibration.Deadline.Seconds() * margin
+
+	outputDir, err := os.MkdirTemp(config.TempDir, "hlsvod-calibration-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create calibration directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	profile := *config.VideoProfile
+	profile.Preset = calibrationPreset
+	profile.CopyCompatible = false
+
+	sampleEnd := start + sampleDuration
+	if sampleEnd > end {
+		sampleEnd = end
+	}
+
+	metrics, _, err := e.encodeSample(ctx, &config, &profile, start, sampleEnd, outputDir)
+	if err != nil {
+		return "", fmt.Errorf("calibration encode failed: %w", err)
+	}
+
+	if metrics.Speed <= 0 {
+		return "", fmt.Errorf("calibration encode did not report speed")
+	}
+
+	// slowest preset first, placebo is never chosen
+	for i := len(x264Presets) - 2; i >= 0; i-- {
+		preset := x264Presets[i]
+		if metrics.Speed*x264PresetSpeed[preset] >= requiredSpeed {
+			return preset, nil
+		}
+	}
+
+	return x264Presets[0], nil
+}
+
+// encodes the range of segment times using the profile into a single segment in the output
+// directory, with the same input and resources as the config, but without hooks and additional
+// outputs, returns metrics and path of the segment
+func (e *Encoder) encodeSample(ctx context.Context, config *TranscodeConfig, profile *VideoProfile, start, end float64, outputDir string) (EncodeMetrics, string, error) {
+	var metrics EncodeMetrics
+	job, err := e.Start(ctx, TranscodeConfig{
+		InputFilePath:  config.InputFilePath,
+		InputOptions:   config.InputOptions,
+		OutputDirPath:  outputDir,
+		SegmentPrefix:  "sample",
+		TempDir:        config.TempDir,
+		SeekMode:       config.SeekMode,
+		TrimStart:      config.TrimStart,
+		SegmentTimes:   []float64{start, end},
+		VideoProfile:   profile,
+		AudioProfile:   config.AudioProfile,
+		MissingAudio:   config.MissingAudio,
+		ImageInput:     config.ImageInput,
+		ExtraInputArgs: config.ExtraInputArgs,
+		Threads:        config.Threads,
+		DecodeThreads:  config.DecodeThreads,
+		Nice:           config.Nice,
+		CPUAffinity:    config.CPUAffinity,
+		Env:            config.Env,
+		JobID:          config.JobID,
+		TraceID:        config.TraceID,
+		MetricsHook: func(m EncodeMetrics) {
+			metrics = m
+		},
+	})
+	if err != nil {
+		return metrics, "", err
+	}
+
+	// names are delivered on the channel, that must be drained
+	segmentPath := ""
+	for segmentName := range job.Segments() {
+		segmentPath = path.Join(outputDir, segmentName)
+	}
+
+	if err := job.Wait(); err != nil {
+		return metrics, "", err
+	}
+
+	return metrics, segmentPath, nil
+}
@@ -0,0 +1,176 @@
+package hlsvod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Capabilities of ffmpeg build, e.g. encoders and filters it was compiled with.
+type Capabilities struct {
+	Encoders map[string]bool
+	Filters  map[string]bool
+	Demuxers map[string]bool
+}
+
+func (c *Capabilities) HasEncoder(name string) bool {
+	return c.Encoders[name]
+}
+
+func (c *Capabilities) HasFilter(name string) bool {
+	return c.Filters[name]
+}
+
+func (c *Capabilities) HasDemuxer(name string) bool {
+	return c.Demuxers[name]
+}
+
+// CheckCapabilities verifies that ffmpeg can be executed and lists its encoders, filters and demuxers.
+func CheckCapabilities(ctx context.Context, ffmpegBinary string) (*Capabilities, error) {
+	encoders, err := ffmpegList(ctx, ffmpegBinary, "-encoders")
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := ffmpegList(ctx, ffmpegBinary, "-filters")
+	if err != nil {
+		return nil, err
+	}
+
+	demuxers, err := ffmpegList(ctx, ffmpegBinary, "-demuxers")
+	if err != nil {
+		return nil, err
+	}
+
+	// demuxers with aliases are listed together, e.g. mov,mp4,m4a,3gp,3g2,mj2
+	for names := range demuxers {
+		for _, name := range strings.Split(names, ",") {
+			demuxers[name] = true
+		}
+	}
+
+	return &Capabilities{
+		Encoders: encoders,
+		Filters:  filters,
+		Demuxers: demuxers,
+	}, nil
+}
+
+// runs ffmpeg listing command and returns set of listed names
+func ffmpegList(ctx context.Context, ffmpegBinary string, listArg string) (map[string]bool, error) {
+	var stdout bytes.Buffer
+	process := &runner{
+		binary:       ffmpegBinary,
+		args:         []string{"-hide_banner", listArg},
+		stdoutMode:   stdoutData,
+		stdoutWriter: &stdout,
+	}
+
+	if stderr, err := process.run(ctx); err != nil {
+		return nil, fmt.Errorf("unable to run ffmpeg %s: %w: %s", listArg, err, strings.TrimSpace(stderr))
+	}
+
+	return parseFFmpegList(stdout.String()), nil
+}
+
+// Lists start with legend, that is optionally separated by a line of dashes, e.g.
+//
+//	V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC (codec h264)
+//	... scale             V->V       Scale the input video size and/or convert the image format.
+func parseFFmpegList(output string) map[string]bool {
+	lines := strings.Split(output, "\n")
+
+	// skip legend if separator is present
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = lines[i+1:]
+			break
+		}
+	}
+
+	names := map[string]bool{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// legend entries are in form of "V..... = Video"
+		if fields[1] == "=" {
+			continue
+		}
+
+		names[fields[1]] = true
+	}
+
+	return names
+}
+
+var capabilitiesCache = struct {
+	sync.Mutex
+	m map[string]*Capabilities
+}{m: map[string]*Capabilities{}}
+
+// returns capabilities of ffmpeg binary, they are cached since they are invariant
+func cachedCapabilities(ctx context.Context, ffmpegBinary string) (*Capabilities, error) {
+	capabilitiesCache.Lock()
+	defer capabilitiesCache.Unlock()
+
+	if c, ok := capabilitiesCache.m[ffmpegBinary]; ok {
+		return c, nil
+	}
+
+	c, err := CheckCapabilities(ctx, ffmpegBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilitiesCache.m[ffmpegBinary] = c
+	return c, nil
+}
+
+// returns encoders and filters required by transcode config
+func (config *TranscodeConfig) requirements() (encoders []string, filters []string) {
+	if config.VideoProfile != nil {
+		encoders = append(encoders, "libx264")
+		filters = append(filters, "scale")
+
+		if config.VideoProfile.Overlay != nil {
+			filters = append(filters, config.VideoProfile.Overlay.requirements()...)
+		}
+	}
+
+	if config.AudioProfile != nil {
+		encoders = append(encoders, config.AudioProfile.encoder())
+
+		if config.MissingAudio == MissingAudioSilence {
+			filters = append(filters, "anullsrc")
+		}
+	}
+
+	return
+}
+
+func (c *Capabilities) checkRequirements(config *TranscodeConfig) error {
+	encoders, filters := config.requirements()
+
+	for _, encoder := range encoders {
+		if !c.HasEncoder(encoder) {
+			return fmt.Errorf("%w: ffmpeg is not compiled with %s", ErrEncoderNotFound, encoder)
+		}
+	}
+
+	for _, filter := range filters {
+		if !c.HasFilter(filter) {
+			return fmt.Errorf("%w: ffmpeg is not compiled with %s filter", ErrFilterNotFound, filter)
+		}
+	}
+
+	if format := config.InputOptions.Format; format != "" && !c.HasDemuxer(format) {
+		return fmt.Errorf("%w: ffmpeg is not compiled with %s demuxer", ErrDemuxerNotFound, format)
+	}
+
+	return nil
+}
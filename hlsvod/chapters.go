@@ -0,0 +1,87 @@
+package hlsvod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProbeChapters returns start times of chapters in source media time, sorted.
+func ProbeChapters(ctx context.Context, ffprobeBinary string, inputFilePath string, inputOptions InputOptions) ([]float64, error) {
+	args := append(inputOptions.args(), []string{
+		"-v", "error",
+		"-show_chapters",
+		"-of", "json",
+		inputFilePath,
+	}...)
+
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
+	}
+
+	out := struct {
+		Chapters []struct {
+			StartTime string `json:"start_time"`
+		} `json:"chapters"`
+	}{}
+
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	times := []float64{}
+	for _, chapter := range out.Chapters {
+		startTime, err := strconv.ParseFloat(chapter.StartTime, 64)
+		if err != nil {
+			continue
+		}
+		times = append(times, startTime)
+	}
+
+	sort.Float64s(times)
+	return times, nil
+}
+
+// Returns inner segment times merged with additional keyframe times within the encode, sorted
+// and without duplicates, so that segment times are always kept. Additional times closer than
+// segment time delta to a segment time are dropped, since they would make the muxer cut early.
+func mergeKeyframeTimes(segmentTimes []float64, keyframeTimes []float64) []float64 {
+	innerTimes := segmentTimes[1 : len(segmentTimes)-1]
+	startAt, endAt := segmentTimes[0], segmentTimes[len(segmentTimes)-1]
+
+	merged := append([]float64{}, innerTimes...)
+	for _, keyframeTime := range keyframeTimes {
+		if keyframeTime <= startAt+segmentTimeDelta || keyframeTime >= endAt-segmentTimeDelta {
+			continue
+		}
+
+		duplicate := false
+		for _, segmentTime := range innerTimes {
+			if math.Abs(keyframeTime-segmentTime) < segmentTimeDelta {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			merged = append(merged, keyframeTime)
+		}
+	}
+
+	sort.Float64s(merged)
+
+	// additional times can duplicate each other
+	unique := []float64{}
+	for _, t := range merged {
+		if len(unique) == 0 || t-unique[len(unique)-1] > segmentTimesTolerance {
+			unique = append(unique, t)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,87 @@
+package hlsvod
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+)
+
+// ChecksumAlgorithm used to hash finished segments, no checksums are computed when empty.
+type ChecksumAlgorithm string
+
+const (
+	ChecksumNone   ChecksumAlgorithm = ""
+	ChecksumCRC32  ChecksumAlgorithm = "crc32" // IEEE polynomial.
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+)
+
+func (algorithm ChecksumAlgorithm) validate() error {
+	switch algorithm {
+	case ChecksumNone, ChecksumCRC32, ChecksumSHA256:
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown checksum algorithm %q", ErrInvalidConfig, algorithm)
+	}
+}
+
+func (algorithm ChecksumAlgorithm) newHash() hash.Hash {
+	if algorithm == ChecksumCRC32 {
+		return crc32.NewIEEE()
+	}
+	return sha256.New()
+}
+
+// returns hex encoded checksum of the file
+func fileChecksum(filePath string, algorithm ChecksumAlgorithm) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := algorithm.newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SegmentEvent describes segment, that is about to be delivered on the channel.
+type SegmentEvent struct {
+	Name     string
+	Sequence int
+	Checksum string // Hex encoded checksum of the final segment file, empty if not computed.
+	Size     int64  // Size of the final segment file in bytes.
+}
+
+// returns event of a segment, that has been published to the output path
+func (config *TranscodeConfig) segmentEvent(segmentName string, sequence int) (SegmentEvent, error) {
+	event := SegmentEvent{
+		Name:     segmentName,
+		Sequence: sequence,
+	}
+
+	// recorded, since the segment can be removed once it is delivered, e.g. by upload
+	segmentPath := path.Join(config.OutputDirPath, segmentName)
+	stat, err := os.Stat(segmentPath)
+	if err != nil {
+		return event, err
+	}
+	event.Size = stat.Size()
+
+	if config.Checksum != ChecksumNone {
+		checksum, err := fileChecksum(segmentPath, config.Checksum)
+		if err != nil {
+			return event, fmt.Errorf("unable to compute checksum: %w", err)
+		}
+		event.Checksum = checksum
+	}
+
+	return event, nil
+}
@@ -0,0 +1,162 @@
+package hlsvod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColorTags describe how decoded pixels map to colors. Empty values
+// are taken from the source, or left unset when source is unknown.
+type ColorTags struct {
+	Range     string // tv (limited) or pc (full), e.g. -color_range
+	Space     string // Matrix coefficients, e.g. bt709, bt470bg, -colorspace
+	Transfer  string // Transfer characteristics, e.g. bt709, -color_trc
+	Primaries string // e.g. bt709, bt470bg, -color_primaries
+}
+
+func (tags *ColorTags) validate() error {
+	switch tags.Range {
+	case "", "tv", "pc":
+	default:
+		return fmt.Errorf("%w: unsupported color range %q", ErrInvalidVideoProfile, tags.Range)
+	}
+
+	return nil
+}
+
+// returns output color tags, source tags are overridden by non-empty profile tags
+func outputColorTags(profile *VideoProfile, videoInfo *VideoInfo) ColorTags {
+	var tags ColorTags
+
+	if videoInfo != nil {
+		tags = ColorTags{
+			Range:     knownColorTag(videoInfo.ColorRange),
+			Space:     knownColorTag(videoInfo.ColorSpace),
+			Transfer:  knownColorTag(videoInfo.ColorTransfer),
+			Primaries: knownColorTag(videoInfo.ColorPrimaries),
+		}
+
+		// full range is converted to limited range, that players expect by default
+		if isFullRange(videoInfo) {
+			tags.Range = "tv"
+		}
+	}
+
+	if profile.Color != nil {
+		if profile.Color.Range != "" {
+			tags.Range = profile.Color.Range
+		}
+		if profile.Color.Space != "" {
+			tags.Space = profile.Color.Space
+		}
+		if profile.Color.Transfer != "" {
+			tags.Transfer = profile.Color.Transfer
+		}
+		if profile.Color.Primaries != "" {
+			tags.Primaries = profile.Color.Primaries
+		}
+	}
+
+	return tags
+}
+
+// ffprobe reports missing metadata as unknown
+func knownColorTag(value string) string {
+	if value == "unknown" {
+		return ""
+	}
+	return value
+}
+
+// source is full range when tagged so, or when using deprecated JPEG-range pixel format
+func isFullRange(videoInfo *VideoInfo) bool {
+	return videoInfo.ColorRange == "pc" || strings.HasPrefix(videoInfo.PixelFormat, "yuvj")
+}
+
+// returns pixel format without JPEG-range, e.g. yuvj420p becomes yuv420p
+func limitedRangePixelFormat(pixelFormat string) string {
+	if strings.HasPrefix(pixelFormat, "yuvj") {
+		return "yuv" + strings.TrimPrefix(pixelFormat, "yuvj")
+	}
+	return pixelFormat
+}
+
+func (tags ColorTags) args() []string {
+	args := []string{}
+
+	if tags.Range != "" {
+		args = append(args, "-color_range", tags.Range)
+	}
+	if tags.Space != "" {
+		args = append(args, "-colorspace", tags.Space)
+	}
+	if tags.Transfer != "" {
+		args = append(args, "-color_trc", tags.Transfer)
+	}
+	if tags.Primaries != "" {
+		args = append(args, "-color_primaries", tags.Primaries)
+	}
+
+	return args
+}
+
+// matrix coefficients known by scale filter, keyed by ffprobe color space tags
+var colorMatrices = map[string]string{
+	"bt709":     "bt709",
+	"bt470bg":   "bt601",
+	"smpte170m": "bt601",
+}
+
+// output color space tags of scale filter matrices
+var colorMatrixTags = map[string]string{
+	"bt709": "bt709",
+	"bt601": "smpte170m",
+}
+
+// SD sources and outputs are assumed to use bt601, HD ones bt709
+const hdMinShortSide = 720
+
+func sizeColorMatrix(width, height int) string {
+	shortSide := width
+	if height < shortSide {
+		shortSide = height
+	}
+
+	if shortSide >= hdMinShortSide {
+		return "bt709"
+	}
+	return "bt601"
+}
+
+// Returns matrices the scale filter converts between, they are equal if no conversion is needed.
+// Source matrix is taken from its tags, or guessed from its size as ffmpeg does, output matrix
+// from profile color space or output size. Other matrices, e.g. bt2020, are never converted.
+func colorMatrixConversion(profile *VideoProfile, videoInfo *VideoInfo) (in, out string) {
+	if profile.KeepColorMatrix || videoInfo == nil || videoInfo.Width <= 0 || videoInfo.Height <= 0 {
+		return "", ""
+	}
+
+	sourceWidth, sourceHeight := videoInfo.displaySize()
+
+	in = sizeColorMatrix(sourceWidth, sourceHeight)
+	if space := knownColorTag(videoInfo.ColorSpace); space != "" {
+		if in = colorMatrices[space]; in == "" {
+			return "", ""
+		}
+	}
+
+	// source is never upscaled, unless allowed
+	width, height := frameSize(profile, videoInfo)
+	if !profile.AllowUpscale && sourceWidth*sourceHeight < width*height {
+		width, height = sourceWidth, sourceHeight
+	}
+
+	out = sizeColorMatrix(width, height)
+	if profile.Color != nil && profile.Color.Space != "" {
+		if out = colorMatrices[profile.Color.Space]; out == "" {
+			return "", ""
+		}
+	}
+
+	return in, out
+}
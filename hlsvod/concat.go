@@ -0,0 +1,143 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConcatSegments stitches segments into a single file without re-encoding, e.g. into
+// a downloadable MP4. Segments must be unencrypted and share the same codec settings.
+func ConcatSegments(ctx context.Context, ffmpegBinary string, segmentPaths []string, outputPath string) error {
+	if len(segmentPaths) == 0 {
+		return fmt.Errorf("at least one segment is needed")
+	}
+
+	list, err := os.CreateTemp("", "concat-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+
+	for _, segmentPath := range segmentPaths {
+		// concat demuxer resolves relative paths to the list file location
+		absPath, err := filepath.Abs(segmentPath)
+		if err != nil {
+			list.Close()
+			return err
+		}
+
+		if _, err := fmt.Fprintf(list, "file '%s'\n", strings.ReplaceAll(absPath, "'", `'\''`)); err != nil {
+			list.Close()
+			return err
+		}
+	}
+
+	if err := list.Close(); err != nil {
+		return err
+	}
+
+	err = runFFmpeg(ctx, ffmpegBinary, []string{
+		"-loglevel", "error",
+		"-f", "concat",
+		"-safe", "0", // Allow absolute paths.
+		"-i", list.Name(),
+		"-c", "copy",
+		// Segments keep timestamps of the source, demuxer makes them contiguous
+		// and output is shifted to start at zero.
+		"-avoid_negative_ts", "make_zero",
+		"-bsf:a", "aac_adtstoasc", // ADTS headers are not allowed in MP4.
+		"-movflags", "+faststart",
+		"-y", outputPath,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to concat segments: %w", err)
+	}
+
+	return nil
+}
+
+// SegmentRange locates original segment within combined file.
+type SegmentRange struct {
+	Start    int64   // Offset in bytes.
+	Length   int64   // Length in bytes.
+	Duration float64 // In seconds.
+}
+
+// MPEG-TS is a sequence of fixed size packets
+const tsPacketSize = 188
+
+// CombineSegments joins MPEG-TS segments byte by byte into a single file, so that it can be
+// served using byte ranges, and returns range of every segment. Segment at index i spans from
+// breakpoints[i] to breakpoints[i+1]. Segments are valid streams on their own, so that every
+// range can be played without the rest of the file. Segments must be unencrypted.
+func CombineSegments(segmentPaths []string, breakpoints []float64, outputPath string) ([]SegmentRange, error) {
+	if len(segmentPaths) == 0 {
+		return nil, fmt.Errorf("at least one segment is needed")
+	}
+
+	if len(breakpoints) != len(segmentPaths)+1 {
+		return nil, fmt.Errorf("%d breakpoints are needed for %d segments", len(segmentPaths)+1, len(segmentPaths))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), filepath.Base(outputPath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := appendSegments(tmp, segmentPaths, breakpoints)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	// combined file is replaced atomically, as it can be served while updated
+	if err := os.Rename(tmp.Name(), outputPath); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return ranges, nil
+}
+
+// copies segments to the writer and returns their ranges
+func appendSegments(w io.Writer, segmentPaths []string, breakpoints []float64) ([]SegmentRange, error) {
+	ranges := []SegmentRange{}
+
+	var offset int64
+	for i, segmentPath := range segmentPaths {
+		segment, err := os.Open(segmentPath)
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := io.Copy(w, segment)
+		segment.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// range must not cut packets, e.g. of encrypted or truncated segment
+		if length == 0 || length%tsPacketSize != 0 {
+			return nil, fmt.Errorf("segment %s is not a sequence of MPEG-TS packets", segmentPath)
+		}
+
+		ranges = append(ranges, SegmentRange{
+			Start:    offset,
+			Length:   length,
+			Duration: breakpoints[i+1] - breakpoints[i],
+		})
+		offset += length
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,130 @@
+package hlsvod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type SegmentFormat int
+
+const (
+	// MPEG-TS segments for HLS (default).
+	SegmentFormatMPEGTS SegmentFormat = iota
+	// Fragmented MP4 media segments with sidx boxes and single init segment, for DASH
+	// segment template. Segments are muxed using movflags +dash+frag_keyframe, that
+	// writes sidx box for every fragment, together with +empty_moov+default_base_moof,
+	// so that moov contains no samples and fragments are self-contained. Codec
+	// configuration is then moved from the first segment to the init segment.
+	SegmentFormatDASH
+)
+
+// suffix of the init segment name, that is delivered before the first media segment
+const InitSegmentSuffix = "-init.mp4"
+
+// IsInitSegment returns whether segment delivered on the channel is an init segment.
+func IsInitSegment(segmentName string) bool {
+	return strings.HasSuffix(segmentName, InitSegmentSuffix)
+}
+
+func (format SegmentFormat) extension() string {
+	if format == SegmentFormatDASH {
+		return "m4s"
+	}
+	return "ts"
+}
+
+func (config *TranscodeConfig) initSegmentName() string {
+	return config.SegmentPrefix + InitSegmentSuffix
+}
+
+type mp4Box struct {
+	boxType string
+	data    []byte // whole box including header
+}
+
+// splits data into top-level ISO BMFF boxes
+func readMP4Boxes(data []byte) ([]mp4Box, error) {
+	boxes := []mp4Box{}
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box header")
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+
+		switch size {
+		case 0: // box extends to the end of file
+			size = uint64(len(data))
+		case 1: // 64-bit size follows the type
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated %s box header", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+		}
+
+		if size < 8 || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid %s box size %d", boxType, size)
+		}
+
+		boxes = append(boxes, mp4Box{boxType, data[:size]})
+		data = data[size:]
+	}
+
+	return boxes, nil
+}
+
+// Splits fragmented MP4 into init segment (ftyp and moov) and media segment (remaining
+// boxes), media segment starts with styp box, that has the same brands as ftyp.
+func splitInitSegment(data []byte) (initSegment []byte, mediaSegment []byte, err error) {
+	boxes, err := readMP4Boxes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, box := range boxes {
+		switch box.boxType {
+		case "ftyp":
+			initSegment = append(initSegment, box.data...)
+
+			styp := append([]byte{}, box.data...)
+			copy(styp[4:8], "styp")
+			mediaSegment = append(mediaSegment, styp...)
+		case "moov":
+			initSegment = append(initSegment, box.data...)
+		default:
+			mediaSegment = append(mediaSegment, box.data...)
+		}
+	}
+
+	if initSegment == nil {
+		return nil, nil, fmt.Errorf("segment does not contain moov box")
+	}
+
+	return initSegment, mediaSegment, nil
+}
+
+// Moves codec configuration out of the segment, all segments share the same configuration,
+// so that init segment is written only from the first one, if initPath is not empty.
+func prepareDASHSegment(segmentPath string, initPath string) error {
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return err
+	}
+
+	initSegment, mediaSegment, err := splitInitSegment(data)
+	if err != nil {
+		return err
+	}
+
+	if initPath != "" {
+		if err := writeFileAtomic(initPath, initSegment); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(segmentPath, mediaSegment)
+}
@@ -0,0 +1,62 @@
+package hlsvod
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/m1k1o/go-transcode/internal/utils/diskspace"
+)
+
+// returns expected size of the output in bytes from duration and bitrates, including
+// container overhead, 0 if bitrates are unknown, e.g. copied streams or VBR audio
+func (config *TranscodeConfig) estimatedOutputSize() int64 {
+	kbps := 0
+	if profile := config.VideoProfile; profile != nil {
+		// peaks are capped by max rate, bitrate is only an estimate when CRF is used
+		kbps = profile.Bitrate
+		if profile.MaxRate > 0 {
+			kbps = profile.MaxRate
+		}
+	}
+	if profile := config.AudioProfile; profile != nil && profile.VBR == 0 {
+		kbps += profile.Bitrate
+	}
+
+	duration := config.SegmentTimes[len(config.SegmentTimes)-1] - config.SegmentTimes[0]
+	return int64(float64(kbps) * 1000 / 8 * duration / (1 - containerOverhead))
+}
+
+// returns ErrDiskFull if estimated output does not fit into the output directory
+func (config *TranscodeConfig) checkFreeSpace() error {
+	required := config.estimatedOutputSize()
+	if required == 0 || config.OutputDirPath == "" {
+		return nil
+	}
+
+	available, err := diskspace.Available(config.OutputDirPath)
+	if errors.Is(err, diskspace.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to check free space: %w", err)
+	}
+
+	if uint64(required) > available {
+		return fmt.Errorf("%w: estimated output size is %d MB, only %d MB available", ErrDiskFull, required>>20, available>>20)
+	}
+
+	return nil
+}
+
+// Segment being written, when the disk filled up, is truncated and would be
+// taken for complete by players, finished segments are kept for Resume.
+func (config *TranscodeConfig) removePartialSegment(finished int) error {
+	segmentPath := path.Join(config.writeDirPath(), config.segmentName(config.SegmentOffset+finished))
+	if err := os.Remove(segmentPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
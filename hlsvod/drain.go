@@ -0,0 +1,81 @@
+package hlsvod
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDrainTimeout is how long Drain waits for the segment being encoded, when zero is given.
+const DefaultDrainTimeout = 30 * time.Second
+
+// Drain stops the job once the segment being encoded is finished, so that no partial segment
+// is left behind, e.g. when interactive tool is interrupted. Finished segments are still
+// delivered before the segments channel is closed and Wait returns ErrDrained. If the segment
+// is not finished within maxWait, ffmpeg is killed and the partial segment is removed, then
+// ErrDrainTimeout is returned. Only jobs started by Start are drained, others are cancelled
+// after maxWait. Drain blocks until the job exits.
+func (j *Job) Drain(maxWait time.Duration) error {
+	if maxWait == 0 {
+		maxWait = DefaultDrainTimeout
+	}
+
+	atomic.StoreInt32(&j.drainRequested, 1)
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-j.done:
+		return nil
+	case <-timer.C:
+		atomic.StoreInt32(&j.drainExpired, 1)
+		j.Cancel()
+		<-j.done
+		return ErrDrainTimeout
+	}
+}
+
+func (j *Job) draining() bool {
+	return atomic.LoadInt32(&j.drainRequested) == 1
+}
+
+func (j *Job) drainTimedOut() bool {
+	return atomic.LoadInt32(&j.drainExpired) == 1
+}
+
+// DrainOnSignal drains the job on the first of the signals, e.g. os.Interrupt, and kills it on
+// the second one, so that user can still abort waiting for the segment. Signals are no longer
+// handled once the job exits or returned stop function is called.
+func DrainOnSignal(job *Job, maxWait time.Duration, signals ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer signal.Stop(ch)
+
+		select {
+		case <-ch:
+			go job.Drain(maxWait)
+		case <-job.Done():
+			return
+		case <-stopped:
+			return
+		}
+
+		select {
+		case <-ch:
+			job.Cancel()
+		case <-job.Done():
+		case <-stopped:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopped) })
+	}
+}
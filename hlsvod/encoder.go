@@ -0,0 +1,224 @@
+package hlsvod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Encoder holds everything that is shared between transcode jobs,
+// so that it can be created once and reused for many encodes.
+type Encoder struct {
+	ffmpegBinary  string
+	ffprobeBinary string
+	logger        zerolog.Logger
+
+	videoProfile *VideoProfile
+	audioProfile *AudioProfile
+
+	probeTimeout time.Duration
+	tempDir      string
+
+	activeMu sync.Mutex
+	active   map[*Job]JobStatus // running ffmpeg processes
+
+	slots  chan struct{} // limits running ffmpeg processes, unlimited when nil
+	queued int32         // encodes waiting for a slot, accessed atomically
+
+	recorder MetricsRecorder
+}
+
+// probes are expected to be quick, hung input should not block for long
+const defaultProbeTimeout = 5 * time.Second
+
+type Option func(e *Encoder)
+
+// WithLogger replaces default logger used by the encoder.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(e *Encoder) {
+		e.logger = logger
+	}
+}
+
+// WithVideoProfile sets video profile used when transcode config does not specify one.
+func WithVideoProfile(profile *VideoProfile) Option {
+	return func(e *Encoder) {
+		e.videoProfile = profile
+	}
+}
+
+// WithAudioProfile sets audio profile used when transcode config does not specify one.
+func WithAudioProfile(profile *AudioProfile) Option {
+	return func(e *Encoder) {
+		e.audioProfile = profile
+	}
+}
+
+// WithProbeTimeout limits how long can probing of the input take before the encode.
+func WithProbeTimeout(timeout time.Duration) Option {
+	return func(e *Encoder) {
+		e.probeTimeout = timeout
+	}
+}
+
+// WithTempDir sets directory for scratch files of all jobs, system temp directory by default.
+func WithTempDir(dir string) Option {
+	return func(e *Encoder) {
+		e.tempDir = dir
+	}
+}
+
+// WithMaxConcurrentEncodes limits how many ffmpeg processes of the encoder can run at once,
+// starts beyond the limit wait until a running encode exits or their context is cancelled.
+// Probing is not limited. Unlimited when zero.
+func WithMaxConcurrentEncodes(limit int) Option {
+	return func(e *Encoder) {
+		if limit > 0 {
+			e.slots = make(chan struct{}, limit)
+		}
+	}
+}
+
+// NewEncoder creates reusable encoder. If ffprobe binary is empty,
+// it is derived from ffmpeg binary path.
+func NewEncoder(ffmpegBinary, ffprobeBinary string, opts ...Option) *Encoder {
+	if ffmpegBinary == "" {
+		ffmpegBinary = "ffmpeg"
+	}
+
+	if ffprobeBinary == "" {
+		ffprobeBinary = ffprobeFromFFmpeg(ffmpegBinary)
+	}
+
+	e := &Encoder{
+		ffmpegBinary:  ffmpegBinary,
+		ffprobeBinary: ffprobeBinary,
+		logger:        log.With().Str("module", "hlsvod").Str("submodule", "encoder").Logger(),
+		probeTimeout:  defaultProbeTimeout,
+		recorder:      noopMetricsRecorder{},
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// derive ffprobe path from ffmpeg path, only binary name is replaced
+// so that directories containing "ffmpeg" in their name are kept intact
+func ffprobeFromFFmpeg(ffmpegBinary string) string {
+	dir, file := filepath.Split(ffmpegBinary)
+	return dir + strings.Replace(file, "ffmpeg", "ffprobe", 1)
+}
+
+func (e *Encoder) applyDefaults(config *TranscodeConfig) {
+	if config.VideoProfile == nil {
+		config.VideoProfile = e.videoProfile
+	}
+
+	if config.AudioProfile == nil {
+		config.AudioProfile = e.audioProfile
+	}
+
+	if config.TempDir == "" {
+		config.TempDir = e.tempDir
+	}
+}
+
+// runs probe with its own timeout derived from the parent context,
+// so that probe timeout can be distinguished from parent cancellation
+func (e *Encoder) withProbeTimeout(ctx context.Context, probe func(ctx context.Context) error) error {
+	probeCtx, cancel := context.WithTimeout(ctx, e.probeTimeout)
+	defer cancel()
+
+	err := probe(probeCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(probeCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", ErrProbeTimeout, e.probeTimeout)
+	}
+
+	return err
+}
+
+// waits for a free slot, unless the number of encodes is unlimited
+func (e *Encoder) acquireSlot(ctx context.Context) error {
+	if e.slots == nil {
+		return nil
+	}
+
+	atomic.AddInt32(&e.queued, 1)
+	defer atomic.AddInt32(&e.queued, -1)
+
+	select {
+	case e.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Encoder) releaseSlot() {
+	if e.slots != nil {
+		<-e.slots
+	}
+}
+
+// QueueDepth returns number of encodes waiting for a slot, see WithMaxConcurrentEncodes.
+func (e *Encoder) QueueDepth() int {
+	return int(atomic.LoadInt32(&e.queued))
+}
+
+// JobStatus describes running ffmpeg process of a job.
+type JobStatus struct {
+	JobID     string
+	TraceID   string
+	PID       int
+	Args      []string
+	StartedAt time.Time
+
+	// Cancel kills ffmpeg together with its process group, job fails afterwards.
+	Cancel func()
+}
+
+// ActiveJobs returns all jobs, whose ffmpeg is running, ordered by start time.
+func (e *Encoder) ActiveJobs() []JobStatus {
+	e.activeMu.Lock()
+	defer e.activeMu.Unlock()
+
+	jobs := make([]JobStatus, 0, len(e.active))
+	for _, status := range e.active {
+		jobs = append(jobs, status)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+
+	return jobs
+}
+
+func (e *Encoder) addActive(job *Job, status JobStatus) {
+	e.activeMu.Lock()
+	defer e.activeMu.Unlock()
+
+	if e.active == nil {
+		e.active = map[*Job]JobStatus{}
+	}
+	e.active[job] = status
+}
+
+func (e *Encoder) removeActive(job *Job) {
+	e.activeMu.Lock()
+	defer e.activeMu.Unlock()
+
+	delete(e.active, job)
+}
@@ -0,0 +1,75 @@
+package hlsvod
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// Encryption configures HLS AES-128 segment encryption. Segments are encrypted
+// in place as soon as ffmpeg finishes them, key is never written to the disk.
+type Encryption struct {
+	Key    []byte // 16 bytes AES-128 key.
+	IV     []byte // 16 bytes, if empty, segment sequence number is used as defined by HLS.
+	KeyURI string // URI of the key referenced in the playlist.
+}
+
+func (enc *Encryption) validate() error {
+	if len(enc.Key) != aes.BlockSize {
+		return fmt.Errorf("%w: encryption key must be %d bytes long", ErrInvalidEncryption, aes.BlockSize)
+	}
+
+	if len(enc.IV) != 0 && len(enc.IV) != aes.BlockSize {
+		return fmt.Errorf("%w: encryption IV must be %d bytes long", ErrInvalidEncryption, aes.BlockSize)
+	}
+
+	if enc.KeyURI == "" {
+		return fmt.Errorf("%w: encryption key URI must be set", ErrInvalidEncryption)
+	}
+
+	return nil
+}
+
+func (enc *Encryption) segmentIV(sequence int) []byte {
+	if len(enc.IV) != 0 {
+		return enc.IV
+	}
+
+	// big-endian 128-bit integer of the media sequence number
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+// returns #EXT-X-KEY playlist tag
+func (enc *Encryption) playlistTag() string {
+	tag := fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=%q", enc.KeyURI)
+	if len(enc.IV) != 0 {
+		tag += ",IV=0x" + hex.EncodeToString(enc.IV)
+	}
+	return tag
+}
+
+// encrypt whole segment using AES-128-CBC with PKCS7 padding
+func (enc *Encryption) encryptSegment(segmentPath string, sequence int) error {
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(enc.Key)
+	if err != nil {
+		return err
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	cipher.NewCBCEncrypter(block, enc.segmentIV(sequence)).CryptBlocks(data, data)
+
+	return writeFileAtomic(segmentPath, data)
+}
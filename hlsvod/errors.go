@@ -0,0 +1,83 @@
+package hlsvod
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInputNotFound    = errors.New("input not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrDecodeFailed     = errors.New("decode failed")
+	ErrNoVideoStream    = errors.New("no video streams found")
+	ErrProbeTimeout     = errors.New("probe timed out")
+	ErrEncoderNotFound  = errors.New("encoder not found")
+	ErrFilterNotFound   = errors.New("filter not found")
+	ErrDemuxerNotFound  = errors.New("demuxer not found")
+	ErrOutputFailed     = errors.New("unable to write output")
+	ErrOutputDirMissing = errors.New("output directory does not exist")
+	ErrDiskFull         = errors.New("no space left on device")
+	ErrNothingToAppend  = errors.New("nothing to append")
+	ErrPromotedWarning  = errors.New("warning promoted to error")
+	ErrBitrateExceeded  = errors.New("output bitrate exceeds target")
+	ErrSegmentTooLarge  = errors.New("segment exceeds size limit")
+	ErrOutputExists     = errors.New("output segment already exists")
+	ErrDrained          = errors.New("encode was drained")
+	ErrDrainTimeout     = errors.New("segment was not finished before drain timeout")
+)
+
+// validation errors, returned before ffmpeg is started
+var (
+	ErrTooFewSegmentTimes      = errors.New("minimum 2 segment times needed")
+	ErrInvalidVideoProfile     = errors.New("invalid video profile")
+	ErrInvalidAudioProfile     = errors.New("invalid audio profile")
+	ErrInvalidSegmentStrategy  = errors.New("invalid segment strategy")
+	ErrInvalidEncryption       = errors.New("invalid encryption")
+	ErrInvalidResolution       = errors.New("invalid resolution")
+	ErrInvalidThumbnailOptions = errors.New("invalid thumbnail options")
+	ErrInvalidConfig           = errors.New("invalid transcode config")
+	ErrInvalidPlaylist         = errors.New("invalid playlist")
+)
+
+// known ffmpeg stderr patterns, first match wins
+var stderrPatterns = []struct {
+	pattern string
+	err     error
+}{
+	// output errors must be matched before generic file errors,
+	// disk full is reported within both open and write errors
+	{"No space left on device", ErrDiskFull},
+	{"Failed to open segment", ErrOutputFailed},
+	{"Could not write header", ErrOutputFailed},
+	{"No such file or directory", ErrInputNotFound},
+	{"Permission denied", ErrPermissionDenied},
+	{"Unknown encoder", ErrEncoderNotFound},
+	{"Encoder not found", ErrEncoderNotFound},
+	{"Invalid data found when processing input", ErrDecodeFailed},
+	{"Error while decoding", ErrDecodeFailed},
+	{"error while decoding", ErrDecodeFailed},
+	{"decode_slice_header error", ErrDecodeFailed},
+}
+
+// returns typed error if stderr line matches known error pattern, nil otherwise
+func classifyStderr(line string) error {
+	for _, p := range stderrPatterns {
+		if strings.Contains(line, p.pattern) {
+			return fmt.Errorf("%w: %s", p.err, line)
+		}
+	}
+
+	return nil
+}
+
+// returns typed error if stderr line matches any of the patterns promoted to errors
+func promoteWarning(line string, patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern != "" && strings.Contains(line, pattern) {
+			return fmt.Errorf("%w: %s", ErrPromotedWarning, line)
+		}
+	}
+
+	return nil
+}
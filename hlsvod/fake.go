@@ -0,0 +1,69 @@
+package hlsvod
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	_ Transcoder = (*Encoder)(nil)
+	_ Transcoder = (*FakeTranscoder)(nil)
+)
+
+// FakeTranscoder is a test double, that delivers scripted segments without running ffmpeg
+// and without writing any files, so that consumers can test their endpoints.
+type FakeTranscoder struct {
+	// Delivered segment names, if nil, names are generated from the transcode
+	// config, one for every segment time span, starting at SegmentOffset.
+	Segments []string
+	// Delay before every segment is delivered.
+	Delay time.Duration
+	// Error returned by Start, no job is started.
+	StartErr error
+	// Terminal error of the job, reported after all segments were delivered.
+	Err error
+}
+
+func (f *FakeTranscoder) Start(ctx context.Context, config TranscodeConfig) (*Job, error) {
+	if f.StartErr != nil {
+		return nil, f.StartErr
+	}
+
+	segments := f.Segments
+	if segments == nil {
+		for i := 0; i < len(config.SegmentTimes)-1; i++ {
+			segments = append(segments, config.segmentName(config.SegmentOffset+i))
+		}
+	}
+
+	job := newJob(len(segments))
+	produced := make(chan string)
+	go forwardSegments(produced, job.segments)
+
+	go func() {
+		err := f.Err
+
+	loop:
+		for _, segmentName := range segments {
+			select {
+			case <-time.After(f.Delay):
+			case <-ctx.Done():
+				err = ctx.Err()
+				break loop
+			}
+
+			produced <- segmentName
+			job.segmentEncoded()
+		}
+
+		close(produced)
+
+		if config.ExitHook != nil {
+			config.ExitHook(err)
+		}
+
+		job.finish(err)
+	}()
+
+	return job, nil
+}
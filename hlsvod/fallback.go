@@ -0,0 +1,228 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SpeedFallback steps the encode down to lower profile, once it is persistently slower than
+// required, so that deadline is met at the cost of quality. Segments finished before the
+// fallback are kept, only the remaining ones are encoded using the lower profile.
+type SpeedFallback struct {
+	Profiles []*VideoProfile // Lower profiles, tried in order.
+	MinSpeed float64         // Speed multiplier required, e.g. 1 for real time.
+	Grace    time.Duration   // How long can speed stay below MinSpeed, 10s when zero.
+}
+
+const defaultFallbackGrace = 10 * time.Second
+
+func (fallback *SpeedFallback) validate(config *TranscodeConfig) error {
+	if len(fallback.Profiles) == 0 {
+		return fmt.Errorf("%w: fallback profiles must be set", ErrInvalidConfig)
+	}
+
+	if fallback.MinSpeed <= 0 {
+		return fmt.Errorf("%w: fallback minimum speed must be positive", ErrInvalidConfig)
+	}
+
+	if fallback.Grace < 0 {
+		return fmt.Errorf("%w: fallback grace must not be negative", ErrInvalidConfig)
+	}
+
+	// resolution can change between MPEG-TS segments, but not within fMP4 init segment
+	if config.SegmentFormat != SegmentFormatMPEGTS {
+		return fmt.Errorf("%w: fallback is supported only with MPEG-TS segments", ErrInvalidConfig)
+	}
+
+	if config.PartDuration > 0 || config.InputReader != nil {
+		return fmt.Errorf("%w: fallback is supported neither with partial segments nor with streamed input", ErrInvalidConfig)
+	}
+
+	// the next attempt would overwrite the playlist
+	if config.Muxer != MuxerSegment {
+		return fmt.Errorf("%w: fallback is supported only with segment muxer", ErrInvalidConfig)
+	}
+
+	// segments of the next attempt would start at zero again
+	if config.ResetTimestamps {
+		return fmt.Errorf("%w: fallback cannot be used with reset timestamps", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// returns progress hook, that closes slow channel once the speed stays below
+// minimum for the grace period, speed is not reported at the very beginning
+func (fallback *SpeedFallback) monitor(slow chan<- struct{}) func(stats encodeStats) {
+	grace := fallback.Grace
+	if grace == 0 {
+		grace = defaultFallbackGrace
+	}
+
+	var once sync.Once
+	var slowSince time.Time
+	return func(stats encodeStats) {
+		if stats.Speed == 0 || stats.Speed >= fallback.MinSpeed {
+			slowSince = time.Time{}
+			return
+		}
+
+		if slowSince.IsZero() {
+			slowSince = time.Now()
+			return
+		}
+
+		if time.Since(slowSince) >= grace {
+			once.Do(func() { close(slow) })
+		}
+	}
+}
+
+// StartWithFallback starts supervised transcode, that is cancelled and restarted from the
+// first unfinished segment using next fallback profile, whenever it is too slow. The last
+// profile is never stepped down. ExitHook is called once the whole encode finishes and the
+// manifest is available only if no fallback happened, since segments then differ in profile.
+func (e *Encoder) StartWithFallback(ctx context.Context, config TranscodeConfig, fallback SpeedFallback) (*Job, error) {
+	e.applyDefaults(&config)
+
+	logger := config.jobLogger(ctx, e.logger)
+
+	if err := fallback.validate(&config); err != nil {
+		return nil, err
+	}
+
+	if len(config.SegmentTimes) < 2 {
+		return nil, ErrTooFewSegmentTimes
+	}
+
+	// attempts continue within the preview, that is resolved only once
+	if err := e.resolvePreview(ctx, &config); err != nil {
+		return nil, err
+	}
+
+	// attempts continue from unfinished segment, so that append point is resolved only once
+	if config.Append != nil {
+		if err := config.Append.validate(&config); err != nil {
+			return nil, err
+		}
+
+		config.SegmentTimes = config.Append.segmentTimes(config.SegmentTimes)
+		config.SegmentOffset = config.Append.NextSegment
+		config.Append = nil
+		if len(config.SegmentTimes) < 2 {
+			return nil, ErrNothingToAppend
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	exitHook := config.ExitHook
+	config.ExitHook = nil
+
+	// returns attempt and channel, that is closed once it is too slow
+	startAttempt := func(attemptConfig TranscodeConfig, stepDown bool) (*Job, <-chan struct{}, error) {
+		slow := make(chan struct{})
+		if stepDown {
+			attemptConfig.progressHook = fallback.monitor(slow)
+		}
+
+		attempt, err := e.Start(ctx, attemptConfig)
+		return attempt, slow, err
+	}
+
+	attempt, slow, err := startAttempt(config, true)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	totalSegments := len(config.SegmentTimes) - 1
+	job := newJob(totalSegments)
+	job.cancel = cancel
+
+	produced := make(chan string)
+	go forwardSegments(produced, job.segments)
+
+	go func() {
+		defer cancel()
+		defer close(produced)
+
+		segmentTimes := config.SegmentTimes
+		segmentOffset := config.SegmentOffset
+		profiles := fallback.Profiles
+		steppedDown := false
+
+		var err error
+		for {
+			finished := 0
+			segments := attempt.Segments()
+			for segments != nil {
+				select {
+				case segmentName, ok := <-segments:
+					if !ok {
+						segments = nil
+						continue
+					}
+
+					produced <- segmentName
+					job.segmentEncoded()
+					finished++
+				case <-slow:
+					// remaining profiles are supervised, the last one is kept until it finishes
+					logger.Warn().Float64("min_speed", fallback.MinSpeed).Msg("encode is too slow, stepping down to fallback profile")
+					attempt.Cancel()
+					slow = nil
+				}
+			}
+
+			err = attempt.Wait()
+			if slow != nil || len(profiles) == 0 {
+				break
+			}
+
+			// cancelled, continue from the first unfinished segment
+			segmentTimes = segmentTimes[finished:]
+			segmentOffset += finished
+			if len(segmentTimes) < 2 {
+				err = nil
+				break
+			}
+
+			config.warn(Warning{
+				Kind:    WarningSpeedFallback,
+				Message: fmt.Sprintf("encode was slower than %.2fx, %d segments are left", fallback.MinSpeed, len(segmentTimes)-1),
+			})
+
+			attemptConfig := config
+			attemptConfig.VideoProfile = profiles[0]
+			attemptConfig.SegmentTimes = segmentTimes
+			attemptConfig.SegmentOffset = segmentOffset
+			attemptConfig.ManifestPath = ""
+			attemptConfig.Discontinuity = true
+			// partial segment of the cancelled attempt is encoded again
+			attemptConfig.Overwrite = OverwriteAlways
+			attemptConfig.LogFilePath = config.derivedLogFilePath(fmt.Sprintf("fallback%d", len(fallback.Profiles)-len(profiles)+1))
+			profiles = profiles[1:]
+			steppedDown = true
+
+			attempt, slow, err = startAttempt(attemptConfig, len(profiles) > 0)
+			if err != nil {
+				break
+			}
+		}
+
+		if err == nil && !steppedDown {
+			job.manifest = attempt.Manifest()
+		}
+
+		if exitHook != nil {
+			exitHook(err)
+		}
+
+		job.finish(err)
+	}()
+
+	return job, nil
+}
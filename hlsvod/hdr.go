@@ -0,0 +1,151 @@
+package hlsvod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HDR10 is signalled by bt2020 primaries and PQ transfer, that are kept from the source by
+// default. Static metadata is carried by SEI messages, that libx264 cannot write, so that it is
+// only probed and reported, re-injecting it on output requires HEVC encoder, that is not supported.
+
+// MasteringDisplay describes display the content was graded on.
+type MasteringDisplay struct {
+	// CIE 1931 chromaticity coordinates of primaries and white point.
+	RedX, RedY     float64
+	GreenX, GreenY float64
+	BlueX, BlueY   float64
+	WhiteX, WhiteY float64
+
+	// Luminance in cd/m².
+	MinLuminance float64
+	MaxLuminance float64
+}
+
+// HDRMetadata is HDR10 static metadata of the source, nil values are missing.
+type HDRMetadata struct {
+	MasteringDisplay *MasteringDisplay
+	MaxCLL           int // Maximum content light level in cd/m², 0 if missing.
+	MaxFALL          int // Maximum frame-average light level in cd/m², 0 if missing.
+}
+
+// IsHDR10 returns whether source is signalled as HDR10, i.e. bt2020 with PQ transfer.
+func (info *VideoInfo) IsHDR10() bool {
+	return info.ColorPrimaries == "bt2020" && info.ColorTransfer == "smpte2084"
+}
+
+// returns whether transfer characteristics are HDR, i.e. PQ or HLG
+func isHDRTransfer(transfer string) bool {
+	return transfer == "smpte2084" || transfer == "arib-std-b67"
+}
+
+// side data of stream or frame, rationals are reported as strings, e.g. 34000/50000
+type frameSideData struct {
+	SideDataType string `json:"side_data_type"`
+
+	RedX   string `json:"red_x"`
+	RedY   string `json:"red_y"`
+	GreenX string `json:"green_x"`
+	GreenY string `json:"green_y"`
+	BlueX  string `json:"blue_x"`
+	BlueY  string `json:"blue_y"`
+	WhiteX string `json:"white_point_x"`
+	WhiteY string `json:"white_point_y"`
+
+	MinLuminance string `json:"min_luminance"`
+	MaxLuminance string `json:"max_luminance"`
+
+	MaxContent int `json:"max_content"`
+	MaxAverage int `json:"max_average"`
+}
+
+// ProbeHDRMetadata returns HDR10 static metadata from side data of the first video frame,
+// nil if the source has none.
+func ProbeHDRMetadata(ctx context.Context, ffprobeBinary string, inputFilePath string) (*HDRMetadata, error) {
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil,
+		"-v", "error",
+		"-select_streams", "V:0",
+		"-read_intervals", "%+#1", // Only the first frame.
+		"-show_entries", "frame=side_data_list",
+		"-of", "json",
+		inputFilePath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
+	}
+
+	out := struct {
+		Frames []struct {
+			SideDataList []frameSideData `json:"side_data_list"`
+		} `json:"frames"`
+	}{}
+
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	if len(out.Frames) == 0 {
+		return nil, nil
+	}
+
+	return hdrMetadataFromSideData(out.Frames[0].SideDataList), nil
+}
+
+// returns HDR10 static metadata found in side data, nil if there is none
+func hdrMetadataFromSideData(sideDataList []frameSideData) *HDRMetadata {
+	var metadata *HDRMetadata
+	for _, sideData := range sideDataList {
+		switch sideData.SideDataType {
+		case "Mastering display metadata":
+			if metadata == nil {
+				metadata = &HDRMetadata{}
+			}
+
+			metadata.MasteringDisplay = &MasteringDisplay{
+				RedX:         parseRational(sideData.RedX),
+				RedY:         parseRational(sideData.RedY),
+				GreenX:       parseRational(sideData.GreenX),
+				GreenY:       parseRational(sideData.GreenY),
+				BlueX:        parseRational(sideData.BlueX),
+				BlueY:        parseRational(sideData.BlueY),
+				WhiteX:       parseRational(sideData.WhiteX),
+				WhiteY:       parseRational(sideData.WhiteY),
+				MinLuminance: parseRational(sideData.MinLuminance),
+				MaxLuminance: parseRational(sideData.MaxLuminance),
+			}
+		case "Content light level metadata":
+			if metadata == nil {
+				metadata = &HDRMetadata{}
+			}
+
+			metadata.MaxCLL = sideData.MaxContent
+			metadata.MaxFALL = sideData.MaxAverage
+		}
+	}
+
+	return metadata
+}
+
+// parses num/den or plain number, returns 0 if invalid
+func parseRational(value string) float64 {
+	parts := strings.SplitN(value, "/", 2)
+
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+
+	if len(parts) == 1 {
+		return num
+	}
+
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+
+	return num / den
+}
@@ -0,0 +1,160 @@
+package hlsvod
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MuxerBackend is ffmpeg muxer, that cuts segments.
+type MuxerBackend int
+
+const (
+	// Generic segment muxer, segments are cut exactly at SegmentTimes, that can be
+	// irregular, and announced on stdout as soon as they are finished.
+	MuxerSegment MuxerBackend = iota
+	// HLS muxer, that writes VOD media playlist itself, see PlaylistPath. Segments are cut
+	// every segment duration, so that SegmentTimes must be evenly spaced and the last segment
+	// must not be longer. The muxer does not list segments, they are announced by its info
+	// messages instead, so that log level is raised to info. Supported only for MPEG-TS.
+	MuxerHLS
+)
+
+func (config *TranscodeConfig) validateMuxer() error {
+	switch config.Muxer {
+	case MuxerSegment:
+		if config.PlaylistPath != "" {
+			return fmt.Errorf("%w: playlist path requires HLS muxer", ErrInvalidConfig)
+		}
+		return nil
+	case MuxerHLS:
+	default:
+		return fmt.Errorf("%w: unknown muxer backend %d", ErrInvalidConfig, config.Muxer)
+	}
+
+	if config.SegmentFormat != SegmentFormatMPEGTS || config.SegmentStrategy != SegmentByTime {
+		return fmt.Errorf("%w: HLS muxer supports only MPEG-TS segments cut by time", ErrInvalidConfig)
+	}
+
+	// playlist written by the muxer would not match post-processed segments
+	if config.PartDuration > 0 || len(config.TeeOutputs) > 0 || config.Encryption != nil {
+		return fmt.Errorf("%w: HLS muxer is supported neither with partial segments, tee outputs nor encryption", ErrInvalidConfig)
+	}
+
+	if config.SegmentMuxer != nil || config.BreakNonKeyframes {
+		return fmt.Errorf("%w: segment muxer options cannot be used with HLS muxer", ErrInvalidConfig)
+	}
+
+	if _, ok := hlsSegmentDuration(config.SegmentTimes); !ok {
+		return fmt.Errorf("%w: HLS muxer requires evenly spaced segment times", ErrInvalidSegmentStrategy)
+	}
+
+	return nil
+}
+
+// Returns duration of segments, that HLS muxer cuts every keyframe after, if segment times
+// are evenly spaced from the start and the last segment is not longer, so that no other
+// keyframe, e.g. placed by scene detection, can cut it.
+func hlsSegmentDuration(segmentTimes []float64) (float64, bool) {
+	if len(segmentTimes) == 2 {
+		return neverSplitSegmentTime, true
+	}
+
+	start := segmentTimes[0]
+	step := segmentTimes[1] - start
+	for i := 2; i < len(segmentTimes)-1; i++ {
+		if math.Abs(segmentTimes[i]-(start+float64(i)*step)) > segmentTimesTolerance {
+			return 0, false
+		}
+	}
+
+	last := segmentTimes[len(segmentTimes)-1] - segmentTimes[len(segmentTimes)-2]
+	if step <= 0 || last > step+segmentTimesTolerance {
+		return 0, false
+	}
+
+	return step, true
+}
+
+// returns media playlist written by HLS muxer
+func (config *TranscodeConfig) playlistPath() string {
+	if config.PlaylistPath != "" {
+		return config.PlaylistPath
+	}
+	return path.Join(config.OutputDirPath, config.SegmentPrefix+".m3u8")
+}
+
+// returns HLS muxer arguments including the output
+func hlsMuxerArgs(config TranscodeConfig, segmentPath string) []string {
+	step, _ := hlsSegmentDuration(config.SegmentTimes)
+
+	// playlist is replaced atomically, so that it is never read half written
+	flags := "+temp_file"
+	if config.Discontinuity {
+		flags += "+discont_start"
+	}
+
+	return []string{
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%.6f", step),
+		"-hls_playlist_type", "vod",
+		"-hls_list_size", "0",
+		"-hls_segment_type", "mpegts",
+		"-hls_flags", flags,
+		"-start_number", fmt.Sprintf("%d", config.SegmentOffset),
+		"-hls_segment_filename", segmentPath,
+		config.playlistPath(),
+	}
+}
+
+// log levels hiding info messages, that announce segments of HLS muxer
+var quietLogLevels = []string{"", "quiet", "panic", "fatal", "error", "warning"}
+
+func hlsMuxerLogLevel(logLevel string) string {
+	for _, level := range quietLogLevels {
+		if logLevel == level {
+			return "info"
+		}
+	}
+	return logLevel
+}
+
+// e.g. [hls @ 0x55d0c8a4c240] Opening 'out/prefix-00001.ts' for writing
+var openingFileRegex = regexp.MustCompile(`Opening '(.+)' for writing`)
+
+// Translates info messages of HLS muxer to segment list, as written by segment muxer. Segment
+// is finished, once the next one or the final playlist is opened, that is written only at
+// the end of VOD encode.
+type hlsSegmentList struct {
+	writer  *io.PipeWriter
+	suffix  string // segment extension
+	pending string // segment being written
+}
+
+// returns false, if the line is not opening message
+func (list *hlsSegmentList) parseLine(line string) bool {
+	match := openingFileRegex.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+
+	// files are written under temporary name and renamed, once they are complete
+	name := strings.TrimSuffix(filepath.Base(match[1]), ".tmp")
+	if strings.HasSuffix(name, list.suffix) || strings.Contains(name, ".m3u8") {
+		if list.pending != "" {
+			// write fails only when the reader stopped, ffmpeg is then stopped anyway
+			fmt.Fprintln(list.writer, list.pending)
+			list.pending = ""
+		}
+
+		if strings.HasSuffix(name, list.suffix) {
+			list.pending = name
+		}
+	}
+
+	return true
+}
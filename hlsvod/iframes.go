@@ -0,0 +1,168 @@
+package hlsvod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// IFrame is a keyframe within MPEG-TS segment, that is listed by I-frame playlist.
+type IFrame struct {
+	Segment string       // Name of the segment.
+	Time    float64      // Presentation time in seconds.
+	Range   SegmentRange // Bytes of the keyframe within the segment, shown until the next keyframe.
+}
+
+// returns keyframes of the segment, ranges span from keyframe packet to the next video
+// packet, so that interleaved audio is included, durations are not known yet
+func probeIFrames(ctx context.Context, ffprobeBinary string, segmentPath string) ([]IFrame, error) {
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil,
+		"-v", "error",
+		"-select_streams", "V:0",
+		"-show_entries", "packet=pts_time,pos,flags",
+		"-of", "json",
+		segmentPath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
+	}
+
+	out := struct {
+		Packets []struct {
+			PtsTime string `json:"pts_time"`
+			Pos     string `json:"pos"`
+			Flags   string `json:"flags"`
+		} `json:"packets"`
+	}{}
+
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	stat, err := os.Stat(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	segment := path.Base(segmentPath)
+	iframes := []IFrame{}
+	for i, packet := range out.Packets {
+		if !strings.HasPrefix(packet.Flags, "K") {
+			continue
+		}
+
+		ptsTime, err := strconv.ParseFloat(packet.PtsTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse packet time: %w", err)
+		}
+
+		start, err := strconv.ParseInt(packet.Pos, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse packet position: %w", err)
+		}
+
+		// the first keyframe includes PAT and PMT at the beginning of the segment
+		if len(iframes) == 0 {
+			start = 0
+		}
+
+		end := stat.Size()
+		if i+1 < len(out.Packets) {
+			if next, err := strconv.ParseInt(out.Packets[i+1].Pos, 10, 64); err == nil {
+				end = next
+			}
+		}
+
+		if end <= start {
+			continue
+		}
+
+		iframes = append(iframes, IFrame{
+			Segment: segment,
+			Time:    ptsTime,
+			Range:   SegmentRange{Start: start, Length: end - start},
+		})
+	}
+
+	return iframes, nil
+}
+
+// ProbeIFrames returns keyframes of all segments of finished encode in order. Every keyframe
+// is shown until the next one, the last one until the end of the encode. Segments must be
+// MPEG-TS, unencrypted and keep source timestamps, i.e. not reset.
+func (e *Encoder) ProbeIFrames(ctx context.Context, outputDirPath string, manifest *Manifest) ([]IFrame, error) {
+	iframes := []IFrame{}
+	for _, segment := range manifest.Segments {
+		if path.Ext(segment.Name) != "."+SegmentFormatMPEGTS.extension() {
+			return nil, fmt.Errorf("%w: I-frame playlist requires MPEG-TS segments", ErrInvalidConfig)
+		}
+
+		segmentIFrames, err := probeIFrames(ctx, e.ffprobeBinary, path.Join(outputDirPath, segment.Name))
+		if err != nil {
+			return nil, fmt.Errorf("unable to probe keyframes of %s: %w", segment.Name, err)
+		}
+
+		iframes = append(iframes, segmentIFrames...)
+	}
+
+	end := manifest.Start + manifest.Duration
+	for i := range iframes {
+		next := end
+		if i+1 < len(iframes) {
+			next = iframes[i+1].Time
+		}
+		iframes[i].Range.Duration = math.Max(next-iframes[i].Time, 0)
+	}
+
+	return iframes, nil
+}
+
+// IFramePlaylist creates I-frame only playlist for fast forward and rewind, that is referenced
+// from master playlist using IFrameStreamInf.
+func IFramePlaylist(iframes []IFrame) string {
+	targetDuration := 0.0
+	for _, iframe := range iframes {
+		targetDuration = math.Max(targetDuration, iframe.Range.Duration)
+	}
+
+	playlist := []string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:4",
+		"#EXT-X-PLAYLIST-TYPE:VOD",
+		"#EXT-X-MEDIA-SEQUENCE:0",
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%d", int(math.Ceil(targetDuration))),
+		"#EXT-X-I-FRAMES-ONLY",
+	}
+
+	for _, iframe := range iframes {
+		playlist = append(playlist,
+			fmt.Sprintf("#EXTINF:%.3f,", iframe.Range.Duration),
+			fmt.Sprintf("#EXT-X-BYTERANGE:%d@%d", iframe.Range.Length, iframe.Range.Start),
+			iframe.Segment,
+		)
+	}
+
+	playlist = append(playlist, "#EXT-X-ENDLIST")
+	return strings.Join(playlist, "\n") + "\n"
+}
+
+// IFrameStreamInf returns master playlist tag referencing I-frame playlist, bandwidth
+// is peak bitrate of keyframes, as they are fetched during scrubbing.
+func IFrameStreamInf(iframes []IFrame, uri string) string {
+	bandwidth := 0
+	for _, iframe := range iframes {
+		if iframe.Range.Duration > 0 {
+			bitrate := int(math.Ceil(float64(iframe.Range.Length*8) / iframe.Range.Duration))
+			if bitrate > bandwidth {
+				bandwidth = bitrate
+			}
+		}
+	}
+
+	return fmt.Sprintf("#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,URI=%q", bandwidth, uri)
+}
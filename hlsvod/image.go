@@ -0,0 +1,75 @@
+package hlsvod
+
+import "fmt"
+
+// ImageInput is a still image or an image sequence, that is encoded as video track of
+// audio read from the input, e.g. podcast with its cover, or a slideshow. Some HLS players
+// require video track, that audio-only renditions do not have.
+type ImageInput struct {
+	// Image path, e.g. cover.png, or pattern of the sequence, e.g. slide_%03d.png.
+	Path string
+	// Path is a sequence pattern, single image is looped otherwise.
+	Sequence bool
+	// Images of the sequence shown per second, e.g. 0.2 shows every image for 5 seconds,
+	// 1 when zero. Single image is repeated at the output frame rate.
+	FrameRate float64
+}
+
+// Output frame rate of image video, images are duplicated, so that keyframes can
+// be forced at segment times, regardless of how long is every image shown.
+const imageOutputFrameRate = 25
+
+func (image *ImageInput) validate(config *TranscodeConfig) error {
+	if image.Path == "" {
+		return fmt.Errorf("%w: image path must be set", ErrInvalidConfig)
+	}
+
+	if image.FrameRate < 0 {
+		return fmt.Errorf("%w: image frame rate must not be negative", ErrInvalidConfig)
+	}
+
+	if config.VideoProfile == nil {
+		return fmt.Errorf("%w: image input requires video profile", ErrInvalidConfig)
+	}
+
+	// audio is read from the second input, as is the image
+	if config.AudioDelay != 0 {
+		return fmt.Errorf("%w: audio delay cannot be used with image input", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Returns input arguments of the image, that follows the source. With -copyts, timestamps
+// of the video must match timestamps of the seeked audio, so that looped image is offset to
+// start with it and the sequence is seeked the same way.
+func (image *ImageInput) args(startAt float64, resetTimestamps bool) []string {
+	if !image.Sequence {
+		offset := startAt
+		if resetTimestamps {
+			offset = 0
+		}
+
+		return []string{
+			"-loop", "1",
+			"-framerate", fmt.Sprintf("%d", imageOutputFrameRate),
+			"-itsoffset", fmt.Sprintf("%.6f", offset),
+			"-i", image.Path,
+		}
+	}
+
+	frameRate := image.FrameRate
+	if frameRate == 0 {
+		frameRate = 1
+	}
+
+	args := []string{}
+	if startAt > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.6f", startAt))
+	}
+
+	return append(args,
+		"-framerate", fmt.Sprintf("%g", frameRate),
+		"-i", image.Path,
+	)
+}
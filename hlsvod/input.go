@@ -0,0 +1,105 @@
+package hlsvod
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// InputOptions control how is the input analyzed, they are passed to both ffprobe and
+// ffmpeg, so that probing and encoding agree. Zero values keep ffmpeg defaults.
+type InputOptions struct {
+	// How long is the input analyzed to find streams, e.g. MPEG-TS with sparse PMT needs more.
+	AnalyzeDuration time.Duration
+	// How many bytes are read to find streams.
+	ProbeSize int64
+	// Demuxer flags, e.g. +genpts to generate missing timestamps.
+	FFlags string
+	// Recover usable output from damaged or malformed input, e.g. user-generated content with
+	// invalid DTS. Broken DTS are ignored and regenerated (-fflags +igndts+genpts) and decoding
+	// errors are not fatal (-err_detect ignore_err). Output may then contain minor glitches,
+	// e.g. corrupted frames, dropped frames or audio gaps, where the input is broken.
+	Lenient bool
+	// Input format, e.g. mpegts, it is detected when empty. Required for streams,
+	// since detection may need to read more than the stream allows, and useful for files
+	// with missing or misleading extension. It must be one of ffmpeg -demuxers.
+	Format string
+	// AES-128 key of encrypted input, e.g. CENC protected MP4, as 32 hex digits. Without it,
+	// such input fails to decode. It is never logged, see redactArgs.
+	DecryptionKey string
+}
+
+// length of AES-128 key in bytes
+const decryptionKeySize = 16
+
+func (opts *InputOptions) validate() error {
+	if opts.AnalyzeDuration < 0 {
+		return fmt.Errorf("%w: analyze duration must not be negative", ErrInvalidConfig)
+	}
+
+	if opts.ProbeSize < 0 {
+		return fmt.Errorf("%w: probe size must not be negative", ErrInvalidConfig)
+	}
+
+	if opts.DecryptionKey != "" {
+		// key itself must not be part of the error
+		if key, err := hex.DecodeString(opts.DecryptionKey); err != nil || len(key) != decryptionKeySize {
+			return fmt.Errorf("%w: decryption key must be %d hex digits", ErrInvalidConfig, decryptionKeySize*2)
+		}
+	}
+
+	return nil
+}
+
+// returns input options, they must be placed before the input
+func (opts *InputOptions) args() []string {
+	args := []string{}
+
+	if opts.AnalyzeDuration > 0 {
+		args = append(args, "-analyzeduration", fmt.Sprintf("%d", opts.AnalyzeDuration.Microseconds()))
+	}
+
+	if opts.ProbeSize > 0 {
+		args = append(args, "-probesize", fmt.Sprintf("%d", opts.ProbeSize))
+	}
+
+	fflags := opts.FFlags
+	if opts.Lenient {
+		fflags += "+igndts+genpts"
+	}
+
+	if fflags != "" {
+		args = append(args, "-fflags", fflags)
+	}
+
+	if opts.Lenient {
+		args = append(args, "-err_detect", "ignore_err")
+	}
+
+	if opts.Format != "" {
+		args = append(args, "-f", opts.Format)
+	}
+
+	if opts.DecryptionKey != "" {
+		args = append(args, "-decryption_key", opts.DecryptionKey)
+	}
+
+	return args
+}
+
+// options, whose values are secret and must not be logged
+var secretArgs = map[string]bool{
+	"-decryption_key": true,
+}
+
+// returns copy of args with secret values replaced, so that they can be logged
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && secretArgs[args[i-1]] {
+			arg = "[redacted]"
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
@@ -0,0 +1,131 @@
+package hlsvod
+
+import "sync/atomic"
+
+// Job is a running transcode. Segments are delivered on the segments channel,
+// completion is signaled separately, so that it can be awaited without draining segments.
+type Job struct {
+	segments chan string
+	done     chan struct{}
+	err      error
+
+	encoded int32 // accessed atomically
+	total   int
+
+	manifest *Manifest
+	cancel   func()
+	stopped  int32 // accessed atomically
+
+	drainRequested int32 // accessed atomically, see Drain
+	drainExpired   int32 // accessed atomically, set once Drain kills the job after timeout
+}
+
+func newJob(total int) *Job {
+	return &Job{
+		segments: make(chan string),
+		done:     make(chan struct{}),
+		total:    total,
+	}
+}
+
+// Segments returns channel, that delivers name of the segments as they are encoded.
+// Names are base names relative to OutputDirPath, never containing directories.
+// It is closed after the last segment has been delivered.
+func (j *Job) Segments() <-chan string {
+	return j.segments
+}
+
+// Done returns channel, that is closed once ffmpeg exits.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err returns nil on success or ffmpeg error on failure,
+// it is always nil while the job is still running.
+func (j *Job) Err() error {
+	select {
+	case <-j.done:
+		return j.err
+	default:
+		return nil
+	}
+}
+
+// Cancel stops only this job, ffmpeg is killed together with its whole process group,
+// so that other jobs started with the same parent context keep running.
+func (j *Job) Cancel() {
+	if j.cancel != nil {
+		atomic.StoreInt32(&j.stopped, 1)
+		j.cancel()
+	}
+}
+
+func (j *Job) cancelled() bool {
+	return atomic.LoadInt32(&j.stopped) == 1
+}
+
+// Wait blocks until ffmpeg exits and returns its error.
+func (j *Job) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// Manifest returns description of the result once the job succeeded, nil otherwise.
+func (j *Job) Manifest() *Manifest {
+	select {
+	case <-j.done:
+		return j.manifest
+	default:
+		return nil
+	}
+}
+
+// Progress returns number of segments encoded so far and total number of segments
+// given by segment times, init segments are not counted.
+func (j *Job) Progress() (encoded, total int) {
+	return int(atomic.LoadInt32(&j.encoded)), j.total
+}
+
+// Fraction returns encoded portion of the segments, from 0 to 1.
+func (j *Job) Fraction() float64 {
+	encoded, total := j.Progress()
+	if total == 0 {
+		return 0
+	}
+	return float64(encoded) / float64(total)
+}
+
+func (j *Job) segmentEncoded() {
+	atomic.AddInt32(&j.encoded, 1)
+}
+
+func (j *Job) finish(err error) {
+	j.err = err
+	close(j.done)
+}
+
+// forwards segments without limiting the producer, so that ffmpeg is never
+// blocked by a slow consumer, closes out once in is closed and drained
+func forwardSegments(in <-chan string, out chan<- string) {
+	defer close(out)
+
+	queue := []string{}
+	for in != nil || len(queue) > 0 {
+		var send chan<- string
+		var next string
+		if len(queue) > 0 {
+			send, next = out, queue[0]
+		}
+
+		select {
+		case segment, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			queue = append(queue, segment)
+		case send <- next:
+			queue = queue[1:]
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+)
+
+// LadderTarget is a quality target, that bitrate ladder is derived from.
+type LadderTarget struct {
+	CRF int // Constant rate factor of all renditions, 23 when zero.
+
+	MinResolution Resolution // Lowest rendition, 240p when zero.
+	MaxRenditions int        // Highest renditions are kept, all when zero.
+}
+
+// default x264 CRF, bitrate ceilings are tuned for it
+const ladderDefaultCRF = 23
+
+// Bitrate ceilings of H.264 renditions in kbit/s at default CRF, loosely
+// following Apple HLS authoring specification for 30fps content.
+var ladderTiers = []struct {
+	resolution Resolution
+	maxRate    int
+}{
+	{Res240p, 400},
+	{Res360p, 800},
+	{Res480p, 1400},
+	{Res540p, 2000},
+	{Res720p, 3000},
+	{Res1080p, 6000},
+	{Res1440p, 10000},
+	{Res2160p, 16000},
+}
+
+// DeriveLadder returns renditions from the highest tier not exceeding source resolution
+// down to the minimum resolution. Every rendition is encoded with target CRF, its peaks are
+// capped by tier ceiling and, if known, source bitrate scaled by pixel count, so that
+// renditions are never given more bits than the source had. Returns nil without video.
+func DeriveLadder(info *ProbeMediaData, target LadderTarget) []*VideoProfile {
+	if info == nil || info.Video == nil || info.Video.Width <= 0 || info.Video.Height <= 0 {
+		return nil
+	}
+
+	crf := target.CRF
+	if crf == 0 {
+		crf = ladderDefaultCRF
+	}
+
+	minResolution := target.MinResolution
+	if minResolution == 0 {
+		minResolution = Res240p
+	}
+
+	// every 6 CRF steps double or halve the bitrate
+	qualityFactor := math.Pow(2, float64(ladderDefaultCRF-crf)/6)
+
+	sourceSize := info.Video.Height
+	if info.Video.Width < sourceSize {
+		sourceSize = info.Video.Width
+	}
+	sourcePixels := float64(info.Video.Width * info.Video.Height)
+
+	profiles := []*VideoProfile{}
+	for i := len(ladderTiers) - 1; i >= 0; i-- {
+		tier := ladderTiers[i]
+		if int(tier.resolution) > sourceSize || tier.resolution < minResolution {
+			continue
+		}
+
+		maxRate := int(float64(tier.maxRate) * qualityFactor)
+
+		if info.Video.BitRate > 0 {
+			width, height := tier.resolution.Dimensions()
+			pixelsRatio := math.Min(1, float64(width*height)/sourcePixels)
+
+			sourceRate := int(info.Video.BitRate / 1000 * pixelsRatio)
+			if sourceRate < maxRate {
+				maxRate = sourceRate
+			}
+		}
+
+		width, height := tier.resolution.Dimensions()
+		profiles = append(profiles, &VideoProfile{
+			Width:      width,
+			Height:     height,
+			Resolution: tier.resolution,
+			CRF:        crf,
+			// CRF encodes usually average well below their cap
+			Bitrate: maxRate * 3 / 4,
+			MaxRate: maxRate,
+		})
+
+		if target.MaxRenditions > 0 && len(profiles) == target.MaxRenditions {
+			break
+		}
+	}
+
+	return profiles
+}
+
+// LadderJob is a set of renditions, that are transcoded in parallel, each by its own ffmpeg.
+type LadderJob struct {
+	Renditions map[string]*Job
+}
+
+// Cancel stops single rendition, others keep running. Returns false if it does not exist.
+func (l *LadderJob) Cancel(name string) bool {
+	job, ok := l.Renditions[name]
+	if ok {
+		job.Cancel()
+	}
+	return ok
+}
+
+// Wait blocks until all renditions finish and returns the first error
+// of a rendition, that has not been cancelled on its own.
+func (l *LadderJob) Wait() error {
+	var firstErr error
+	for name, job := range l.Renditions {
+		err := job.Wait()
+		if err != nil && !job.cancelled() && firstErr == nil {
+			firstErr = fmt.Errorf("rendition %s: %w", name, err)
+		}
+	}
+	return firstErr
+}
+
+// rendition name is a subdirectory of the output path, it must not escape it
+func validateRenditionName(name string) error {
+	if name == "" || name == "." || name == ".." || path.Base(name) != name || strings.Contains(name, "\\") {
+		return fmt.Errorf("%w: rendition name %q must be a single path element", ErrInvalidConfig, name)
+	}
+	return nil
+}
+
+// TranscodeLadder starts every rendition as separate job, writing into subdirectory
+// of output path named by the rendition, as do staging directory, playlist and manifest.
+// Jobs share parent context, so that cancelling it stops the whole ladder, while
+// LadderJob.Cancel stops only one rendition. Segments of every rendition must be consumed,
+// as with a single job.
+func (e *Encoder) TranscodeLadder(ctx context.Context, config TranscodeConfig, renditions map[string]*VideoProfile) (*LadderJob, error) {
+	for name := range renditions {
+		if err := validateRenditionName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	ladder := &LadderJob{Renditions: map[string]*Job{}}
+
+	for name, profile := range renditions {
+		renditionConfig := config
+		renditionConfig.VideoProfile = profile
+		renditionConfig.OutputDirPath = path.Join(config.OutputDirPath, name)
+		if config.ManifestPath != "" {
+			renditionConfig.ManifestPath = path.Join(renditionConfig.OutputDirPath, path.Base(config.ManifestPath))
+		}
+		if config.PlaylistPath != "" {
+			renditionConfig.PlaylistPath = path.Join(renditionConfig.OutputDirPath, path.Base(config.PlaylistPath))
+		}
+		renditionConfig.LogFilePath = config.derivedLogFilePath(name)
+		// renditions share segment prefix, so that they would overwrite each other
+		if config.StagingDirPath != "" {
+			renditionConfig.StagingDirPath = path.Join(config.StagingDirPath, name)
+		}
+
+		job, err := e.Start(ctx, renditionConfig)
+		if err != nil {
+			for _, started := range ladder.Renditions {
+				started.Cancel()
+			}
+			return nil, fmt.Errorf("rendition %s: %w", name, err)
+		}
+
+		ladder.Renditions[name] = job
+	}
+
+	return ladder, nil
+}
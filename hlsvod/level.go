@@ -0,0 +1,144 @@
+package hlsvod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// level used when video profile does not specify one
+const defaultH264Level = "4.0"
+
+// LevelAuto selects the lowest level, that supports output resolution, frame rate and bitrate.
+const LevelAuto = "auto"
+
+// H.264 level limits (Table A-1), in macroblocks (16x16 pixels) per second and per frame,
+// and maximum video bitrate in kbit/s for baseline and main profiles
+var h264Levels = map[string]struct {
+	maxMBPS int
+	maxFS   int
+	maxBR   int
+}{
+	"1":   {1485, 99, 64},
+	"1b":  {1485, 99, 128},
+	"1.1": {3000, 396, 192},
+	"1.2": {6000, 396, 384},
+	"1.3": {11880, 396, 768},
+	"2":   {11880, 396, 2000},
+	"2.1": {19800, 792, 4000},
+	"2.2": {20250, 1620, 4000},
+	"3":   {40500, 1620, 10000},
+	"3.1": {108000, 3600, 14000},
+	"3.2": {216000, 5120, 20000},
+	"4":   {245760, 8192, 20000},
+	"4.1": {245760, 8192, 50000},
+	"4.2": {522240, 8704, 50000},
+	"5":   {589824, 22080, 135000},
+	"5.1": {983040, 36864, 240000},
+	"5.2": {2073600, 36864, 240000},
+	"6":   {4177920, 139264, 240000},
+	"6.1": {8355840, 139264, 480000},
+	"6.2": {16711680, 139264, 800000},
+}
+
+// levels in ascending order
+var h264LevelOrder = []string{
+	"1", "1b", "1.1", "1.2", "1.3", "2", "2.1", "2.2", "3", "3.1", "3.2",
+	"4", "4.1", "4.2", "5", "5.1", "5.2", "6", "6.1", "6.2",
+}
+
+// Maximum bitrate of profiles relative to baseline and main, in percent (Table A-2),
+// e.g. high profile allows 1.25 times higher bitrate at the same level.
+var h264ProfileBitrateFactors = map[string]int{
+	"baseline": 100,
+	"main":     100,
+	"high":     125,
+	"high10":   300,
+	"high422":  400,
+	"high444":  400,
+}
+
+// normalizes level, e.g. 4.0 to 4
+func normalizeLevel(level string) string {
+	return strings.TrimSuffix(level, ".0")
+}
+
+func validateLevel(level string) error {
+	if level == "" || level == LevelAuto {
+		return nil
+	}
+
+	if _, ok := h264Levels[normalizeLevel(level)]; !ok {
+		return fmt.Errorf("%w: unknown H.264 level %q", ErrInvalidVideoProfile, level)
+	}
+
+	return nil
+}
+
+// Verifies that level of the profile supports frame size, frame rate and bitrate in kbit/s.
+// Frame rate and bitrate are not checked when unknown (zero), unknown profile is treated as high.
+func checkLevelLimits(level string, profile string, width, height int, frameRate float64, bitrate int) error {
+	limits, ok := h264Levels[normalizeLevel(level)]
+	if !ok {
+		return fmt.Errorf("%w: unknown H.264 level %q", ErrInvalidVideoProfile, level)
+	}
+
+	frameMBs := ((width + 15) / 16) * ((height + 15) / 16)
+	if frameMBs > limits.maxFS {
+		return fmt.Errorf("%w: H.264 level %s does not support %dx%d", ErrInvalidVideoProfile, level, width, height)
+	}
+
+	if frameRate > 0 && float64(frameMBs)*frameRate > float64(limits.maxMBPS) {
+		return fmt.Errorf("%w: H.264 level %s does not support %dx%d at %.2f fps", ErrInvalidVideoProfile, level, width, height, frameRate)
+	}
+
+	factor, ok := h264ProfileBitrateFactors[profile]
+	if !ok {
+		factor = h264ProfileBitrateFactors["high"]
+	}
+
+	if maxBR := limits.maxBR * factor / 100; bitrate > maxBR {
+		return fmt.Errorf("%w: H.264 level %s of %s profile supports at most %d kbit/s, got %d kbit/s", ErrInvalidVideoProfile, level, profile, maxBR, bitrate)
+	}
+
+	return nil
+}
+
+// returns the lowest level, that is not lower than given level and supports the output
+func bumpLevel(level string, profile string, width, height int, frameRate float64, bitrate int) (string, error) {
+	start := 0
+	for i, l := range h264LevelOrder {
+		if l == normalizeLevel(level) {
+			start = i
+			break
+		}
+	}
+
+	for _, l := range h264LevelOrder[start:] {
+		if checkLevelLimits(l, profile, width, height, frameRate, bitrate) == nil {
+			return l, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: no H.264 level of %s profile supports %dx%d at %.2f fps and %d kbit/s", ErrInvalidVideoProfile, profile, width, height, frameRate, bitrate)
+}
+
+// returns the lowest level, that supports frame size, frame rate and bitrate in kbit/s with
+// baseline and main limits, so that it is sufficient for every profile, empty if there is none
+func autoLevel(width, height int, frameRate float64, bitrate int) string {
+	level, err := bumpLevel(h264LevelOrder[0], "main", width, height, frameRate, bitrate)
+	if err != nil {
+		return ""
+	}
+
+	return level
+}
+
+// returns value of -profile:v argument, empty if not set
+func profileName(profileArgs []string) string {
+	for i := 0; i+1 < len(profileArgs); i++ {
+		if profileArgs[i] == "-profile:v" {
+			return profileArgs[i+1]
+		}
+	}
+	return ""
+}
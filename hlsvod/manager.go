@@ -25,9 +25,10 @@ const readyTimeout = 80 * time.Second
 const transcodeTimeout = 10 * time.Second
 
 type ManagerCtx struct {
-	mu     sync.Mutex
-	logger zerolog.Logger
-	config Config
+	mu      sync.Mutex
+	logger  zerolog.Logger
+	config  Config
+	encoder Transcoder
 
 	segmentLength    float64
 	segmentOffset    float64
@@ -58,6 +59,8 @@ func New(config Config) *ManagerCtx {
 		logger: log.With().Str("module", "hlsvod").Str("submodule", "manager").Logger(),
 		config: config,
 
+		encoder: NewEncoder(config.FFmpegBinary, config.FFprobeBinary),
+
 		segmentLength:    4,
 		segmentOffset:    1,
 		segmentBufferMin: 3,
@@ -223,30 +226,10 @@ func (m *ManagerCtx) parseSegmentIndex(segmentName string) (int, bool) {
 }
 
 func (m *ManagerCtx) getPlaylist() string {
-	// playlist prefix
-	playlist := []string{
-		"#EXTM3U",
-		"#EXT-X-VERSION:4",
-		"#EXT-X-PLAYLIST-TYPE:VOD",
-		"#EXT-X-MEDIA-SEQUENCE:0",
-		fmt.Sprintf("#EXT-X-TARGETDURATION:%.2f", m.segmentLength+m.segmentOffset),
-	}
-
-	// playlist segments
-	for i := 1; i < len(m.breakpoints); i++ {
-		playlist = append(playlist,
-			fmt.Sprintf("#EXTINF:%.3f, no desc", m.breakpoints[i]-m.breakpoints[i-1]),
-			m.getSegmentName(i-1),
-		)
-	}
-
-	// playlist suffix
-	playlist = append(playlist,
-		"#EXT-X-ENDLIST",
-	)
-
-	// join with newlines
-	return strings.Join(playlist, "\n") + "\n"
+	return MediaPlaylist(m.breakpoints, m.getSegmentName, MediaPlaylistOptions{
+		TargetDuration: m.segmentLength + m.segmentOffset,
+		Encryption:     m.config.Encryption,
+	})
 }
 
 func (m *ManagerCtx) initialize() {
@@ -382,7 +365,7 @@ func (m *ManagerCtx) transcodeSegments(offset, limit int) error {
 	segmentTimes := m.breakpoints[offset : offset+limit+1]
 	logger.Info().Interface("segments-times", segmentTimes).Msg("transcoding segments")
 
-	segments, err := TranscodeSegments(m.ctx, m.config.FFmpegBinary, TranscodeConfig{
+	job, err := m.encoder.Start(m.ctx, TranscodeConfig{
 		InputFilePath: m.config.MediaPath,
 		OutputDirPath: m.config.TranscodeDir,
 		SegmentPrefix: m.config.SegmentPrefix, // This does not need to match.
@@ -392,6 +375,8 @@ func (m *ManagerCtx) transcodeSegments(offset, limit int) error {
 
 		SegmentOffset: offset,
 		SegmentTimes:  segmentTimes,
+
+		Encryption: m.config.Encryption,
 	})
 
 	if err != nil {
@@ -407,7 +392,7 @@ func (m *ManagerCtx) transcodeSegments(offset, limit int) error {
 		logger.Info().Msg("transcode process started")
 
 		for {
-			segmentName, ok := <-segments
+			segmentName, ok := <-job.Segments()
 			if !ok {
 				break
 			}
@@ -440,6 +425,10 @@ func (m *ManagerCtx) transcodeSegments(offset, limit int) error {
 			index++
 		}
 
+		if err := job.Wait(); err != nil {
+			logger.Err(err).Msg("transcode process failed")
+		}
+
 		// check if all segments were transcoded
 		if index < offset+limit {
 			// clear segments queue if not all segments were transcoded
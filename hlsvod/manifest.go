@@ -0,0 +1,108 @@
+package hlsvod
+
+import "encoding/json"
+
+// Manifest describes finished encode, so that it can be ingested by downstream systems.
+type Manifest struct {
+	InputFilePath string            `json:"input"`
+	TraceID       string            `json:"trace_id,omitempty"`
+	Video         *VideoInfo        `json:"video,omitempty"` // Probed source video, if known.
+	NoAudio       bool              `json:"no_audio,omitempty"`
+	VideoProfile  *VideoProfile     `json:"video_profile,omitempty"`
+	AudioProfile  *AudioProfile     `json:"audio_profile,omitempty"`
+	Segments      []ManifestSegment `json:"segments"`
+	SegmentOffset int               `json:"segment_offset"` // Number of the first segment.
+	Start         float64           `json:"start"`          // Source timestamp of the first segment.
+	Duration      float64           `json:"duration"`       // Total duration in seconds.
+	Metrics       *EncodeMetrics    `json:"metrics,omitempty"`
+	FFmpeg        *VersionInfo      `json:"ffmpeg,omitempty"` // Build, that produced the segments.
+	Warnings      []ManifestWarning `json:"warnings,omitempty"`
+}
+
+type ManifestSegment struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"` // Requested duration in seconds.
+	Size     int64   `json:"size"`     // In bytes, zero if it could not be determined.
+	Checksum string  `json:"checksum,omitempty"`
+	// Segment starts a discontinuity, see TranscodeConfig.Discontinuity.
+	Discontinuity bool `json:"discontinuity,omitempty"`
+	// Segment does not start with a keyframe at the requested time, see TranscodeConfig.VerifyKeyframes.
+	KeyframeMisaligned bool `json:"keyframe_misaligned,omitempty"`
+	// Segment is entirely black or silent, see Manifest.ApplyQC.
+	Black  bool `json:"black,omitempty"`
+	Silent bool `json:"silent,omitempty"`
+}
+
+type ManifestWarning struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Segment string `json:"segment,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// builds manifest of produced media segments, segment times are those requested before
+// skipping segments of resumed encode, sizes and checksums are taken from segment events
+func buildManifest(config *TranscodeConfig, segmentTimes []float64, input inputInfo, segments []string, events map[string]SegmentEvent, warnings []Warning) *Manifest {
+	// resumed encode has offset moved past skipped segments, other strategies are never
+	// resumed and their segment times are the cuts, that differ from config segment times
+	segmentOffset := config.SegmentOffset
+	if config.SegmentStrategy == SegmentByTime {
+		segmentOffset -= len(segmentTimes) - len(config.SegmentTimes)
+	}
+
+	manifest := &Manifest{
+		InputFilePath: config.InputFilePath,
+		TraceID:       config.TraceID,
+		Video:         input.Video,
+		NoAudio:       input.NoAudio,
+		VideoProfile:  config.VideoProfile,
+		AudioProfile:  config.AudioProfile,
+		Segments:      []ManifestSegment{},
+		SegmentOffset: segmentOffset,
+		Start:         segmentTimes[0],
+		Duration:      segmentTimes[len(segmentTimes)-1] - segmentTimes[0],
+	}
+
+	for i, segmentName := range segments {
+		segment := ManifestSegment{
+			Name:          segmentName,
+			Size:          events[segmentName].Size,
+			Checksum:      events[segmentName].Checksum,
+			Discontinuity: i == 0 && config.Discontinuity,
+		}
+		if i+1 < len(segmentTimes) {
+			segment.Duration = segmentTimes[i+1] - segmentTimes[i]
+		}
+
+		manifest.Segments = append(manifest.Segments, segment)
+	}
+
+	for _, warning := range warnings {
+		manifest.Warnings = append(manifest.Warnings, manifestWarning(warning))
+	}
+
+	return manifest
+}
+
+func manifestWarning(warning Warning) ManifestWarning {
+	w := ManifestWarning{
+		Kind:    warning.Kind.String(),
+		Message: warning.Message,
+		Segment: warning.Segment,
+	}
+	if warning.Err != nil {
+		w.Error = warning.Err.Error()
+	}
+
+	return w
+}
+
+// writes manifest as indented JSON
+func (manifest *Manifest) writeFile(filePath string) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filePath, data)
+}
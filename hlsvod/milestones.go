@@ -0,0 +1,130 @@
+package hlsvod
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Milestone is a stage of the encode, milestones are always reached in order.
+type Milestone int
+
+const (
+	// ffmpeg reported first progress, so that it is decoding and encoding frames.
+	MilestoneDecodingStarted Milestone = iota
+	// First segment has been finished, so that keyframe forced at its end has been encoded.
+	MilestoneFirstKeyframeForced
+	// At least half of the segments have been finished, including those skipped by Resume.
+	MilestoneHalfway
+	// Progress reached the end of the last segment, or the last segment has been finished,
+	// ffmpeg is flushing encoders and closing the output.
+	MilestoneFinalizing
+)
+
+func (milestone Milestone) String() string {
+	switch milestone {
+	case MilestoneDecodingStarted:
+		return "decoding started"
+	case MilestoneFirstKeyframeForced:
+		return "first keyframe forced"
+	case MilestoneHalfway:
+		return "halfway"
+	case MilestoneFinalizing:
+		return "finalizing"
+	default:
+		return fmt.Sprintf("milestone %d", int(milestone))
+	}
+}
+
+// MilestoneEvent describes reached milestone.
+type MilestoneEvent struct {
+	Milestone Milestone
+	Segments  int           // Segments finished so far.
+	Total     int           // Total number of segments.
+	MediaTime float64       // Last progress timestamp in seconds, 0 if not reported yet.
+	Elapsed   time.Duration // Time since ffmpeg has been started.
+}
+
+// derives milestones from progress timestamps and segment counts, it is used by both
+// stdout and stderr readers, so that reached milestones are guarded by a mutex
+type milestoneTracker struct {
+	mu sync.Mutex
+
+	hook      func(event MilestoneEvent)
+	startedAt time.Time
+	total     int
+	endAt     float64 // end of the last segment in output timestamps
+
+	next      Milestone
+	segments  int
+	mediaTime float64
+}
+
+// total includes segments skipped by Resume, while segment times are those of the encode
+// in output timestamps, so that the end of the last segment is where progress ends
+func newMilestoneTracker(hook func(event MilestoneEvent), startedAt time.Time, total int, segmentTimes []float64) *milestoneTracker {
+	return &milestoneTracker{
+		hook:      hook,
+		startedAt: startedAt,
+		total:     total,
+		endAt:     segmentTimes[len(segmentTimes)-1],
+	}
+}
+
+// called for every ffmpeg stats line
+func (t *milestoneTracker) progress(mediaTime float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.mediaTime = mediaTime
+	t.reach(MilestoneDecodingStarted)
+
+	// ffmpeg reports output timestamps, that are segment times due to -copyts
+	if mediaTime >= t.endAt-segmentTimeDelta {
+		t.reach(MilestoneFinalizing)
+	}
+}
+
+// called for progress of encode, that ends before the last segment, e.g. parallel chunk,
+// so that it cannot tell the whole encode is finalizing
+func (t *milestoneTracker) decoding(mediaTime float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.mediaTime = mediaTime
+	t.reach(MilestoneDecodingStarted)
+}
+
+// called once a segment is finished, segments include those skipped by Resume
+func (t *milestoneTracker) segmentFinished(segments int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.segments = segments
+	t.reach(MilestoneFirstKeyframeForced)
+
+	if segments*2 >= t.total {
+		t.reach(MilestoneHalfway)
+	}
+
+	if segments >= t.total {
+		t.reach(MilestoneFinalizing)
+	}
+}
+
+// emits all milestones up to the given one, that have not been reached yet
+func (t *milestoneTracker) reach(milestone Milestone) {
+	for ; t.next <= milestone; t.next++ {
+		if t.hook == nil {
+			continue
+		}
+
+		t.hook(MilestoneEvent{
+			Milestone: t.next,
+			Segments:  t.segments,
+			Total:     t.total,
+			MediaTime: t.mediaTime,
+			Elapsed:   time.Since(t.startedAt),
+		})
+	}
+}
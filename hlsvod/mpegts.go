@@ -0,0 +1,122 @@
+package hlsvod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MPEGTSOptions control stream layout and service information of MPEG-TS segments, for
+// interoperability with hardware decoders and broadcast monitoring. Muxer defaults are
+// used for zero values.
+type MPEGTSOptions struct {
+	TransportStreamID int // 1-65535
+	OriginalNetworkID int // 1-65535
+	ServiceID         int // Program number, 1-65535.
+	ServiceType       int // 1-255, e.g. 0x01 for digital TV.
+	PMTStartPID       int // PID of the first PMT, 0x0020-0x1ffa.
+	StartPID          int // PID of the first elementary stream, 0x0020-0x1ffa.
+
+	// Maximum time between PAT/PMT and SDT tables in seconds.
+	PATPeriod float64
+	SDTPeriod float64
+	// Maximum time between PCRs in milliseconds.
+	PCRPeriod int
+
+	// Service description carried in SDT.
+	ServiceProvider string
+	ServiceName     string
+}
+
+const (
+	mpegtsMinPID = 0x0020
+	mpegtsMaxPID = 0x1ffa
+)
+
+func (opts *MPEGTSOptions) validate() error {
+	ids := []struct {
+		name  string
+		value int
+		max   int
+	}{
+		{"transport stream id", opts.TransportStreamID, 0xffff},
+		{"original network id", opts.OriginalNetworkID, 0xffff},
+		{"service id", opts.ServiceID, 0xffff},
+		{"service type", opts.ServiceType, 0xff},
+	}
+
+	for _, id := range ids {
+		if id.value < 0 || id.value > id.max {
+			return fmt.Errorf("%w: mpegts %s must be between 1 and %d", ErrInvalidConfig, id.name, id.max)
+		}
+	}
+
+	for _, pid := range []int{opts.PMTStartPID, opts.StartPID} {
+		if pid != 0 && (pid < mpegtsMinPID || pid > mpegtsMaxPID) {
+			return fmt.Errorf("%w: mpegts pid must be between 0x%04x and 0x%04x", ErrInvalidConfig, mpegtsMinPID, mpegtsMaxPID)
+		}
+	}
+
+	// PMTs are numbered upwards from the start pid, one per program
+	if opts.PMTStartPID != 0 && opts.StartPID != 0 && opts.PMTStartPID == opts.StartPID {
+		return fmt.Errorf("%w: mpegts pmt and elementary stream pids must differ", ErrInvalidConfig)
+	}
+
+	if opts.PATPeriod < 0 || opts.SDTPeriod < 0 || opts.PCRPeriod < 0 {
+		return fmt.Errorf("%w: mpegts table and pcr periods must not be negative", ErrInvalidConfig)
+	}
+
+	// both are written to option list, that uses : and = as separators
+	for _, value := range []string{opts.ServiceProvider, opts.ServiceName} {
+		if strings.ContainsAny(value, ":=\\'") {
+			return fmt.Errorf("%w: mpegts service description must not contain : = \\ or '", ErrInvalidConfig)
+		}
+	}
+
+	return nil
+}
+
+// returns options of mpegts muxer, that is nested in segment muxer
+func (opts *MPEGTSOptions) formatOptions() []string {
+	options := []string{}
+
+	ints := []struct {
+		name  string
+		value int
+	}{
+		{"mpegts_transport_stream_id", opts.TransportStreamID},
+		{"mpegts_original_network_id", opts.OriginalNetworkID},
+		{"mpegts_service_id", opts.ServiceID},
+		{"mpegts_service_type", opts.ServiceType},
+		{"mpegts_pmt_start_pid", opts.PMTStartPID},
+		{"mpegts_start_pid", opts.StartPID},
+		{"pcr_period", opts.PCRPeriod},
+	}
+
+	for _, option := range ints {
+		if option.value > 0 {
+			options = append(options, fmt.Sprintf("%s=%d", option.name, option.value))
+		}
+	}
+
+	if opts.PATPeriod > 0 {
+		options = append(options, fmt.Sprintf("pat_period=%.3f", opts.PATPeriod))
+	}
+
+	if opts.SDTPeriod > 0 {
+		options = append(options, fmt.Sprintf("sdt_period=%.3f", opts.SDTPeriod))
+	}
+
+	return options
+}
+
+// returns service description, that is passed by segment muxer to every segment
+func (opts *MPEGTSOptions) metadataArgs() []string {
+	args := []string{}
+	if opts.ServiceProvider != "" {
+		args = append(args, "-metadata", "service_provider="+opts.ServiceProvider)
+	}
+	if opts.ServiceName != "" {
+		args = append(args, "-metadata", "service_name="+opts.ServiceName)
+	}
+	return args
+}
@@ -0,0 +1,121 @@
+package hlsvod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OverlayPosition is a corner (or center) of the video, where is the overlay placed.
+type OverlayPosition int
+
+const (
+	OverlayBottomRight OverlayPosition = iota // default
+	OverlayBottomLeft
+	OverlayTopRight
+	OverlayTopLeft
+	OverlayCenter
+)
+
+// Overlay is an image, e.g. logo, burned into the video after it has been scaled
+// (and cropped or padded), so that its size and position refer to the output.
+type Overlay struct {
+	ImagePath string // Image readable by ffmpeg, e.g. PNG with alpha channel.
+	Position  OverlayPosition
+	Margin    int // Distance from the edges in pixels, ignored when centered.
+
+	// Overlay width relative to the output width, e.g. 0.1 for 10%,
+	// aspect ratio is kept. Image is not scaled when zero.
+	Scale float64
+	// From 0 (transparent) to 1 (opaque), fully opaque when zero.
+	Opacity float64
+}
+
+func (overlay *Overlay) validate() error {
+	if overlay.ImagePath == "" {
+		return fmt.Errorf("%w: overlay image path must be set", ErrInvalidVideoProfile)
+	}
+
+	if overlay.Position < OverlayBottomRight || overlay.Position > OverlayCenter {
+		return fmt.Errorf("%w: unknown overlay position %d", ErrInvalidVideoProfile, overlay.Position)
+	}
+
+	if overlay.Margin < 0 {
+		return fmt.Errorf("%w: overlay margin must not be negative", ErrInvalidVideoProfile)
+	}
+
+	if overlay.Scale < 0 || overlay.Scale > 1 {
+		return fmt.Errorf("%w: overlay scale must be between 0 and 1", ErrInvalidVideoProfile)
+	}
+
+	if overlay.Opacity < 0 || overlay.Opacity > 1 {
+		return fmt.Errorf("%w: overlay opacity must be between 0 and 1", ErrInvalidVideoProfile)
+	}
+
+	return nil
+}
+
+// returns overlay filter coordinates
+func (overlay *Overlay) coordinates() (x, y string) {
+	margin := overlay.Margin
+
+	switch overlay.Position {
+	case OverlayBottomLeft:
+		return fmt.Sprintf("%d", margin), fmt.Sprintf("main_h-overlay_h-%d", margin)
+	case OverlayTopRight:
+		return fmt.Sprintf("main_w-overlay_w-%d", margin), fmt.Sprintf("%d", margin)
+	case OverlayTopLeft:
+		return fmt.Sprintf("%d", margin), fmt.Sprintf("%d", margin)
+	case OverlayCenter:
+		return "(main_w-overlay_w)/2", "(main_h-overlay_h)/2"
+	default:
+		return fmt.Sprintf("main_w-overlay_w-%d", margin), fmt.Sprintf("main_h-overlay_h-%d", margin)
+	}
+}
+
+// returns filters required by the overlay
+func (overlay *Overlay) requirements() []string {
+	filters := []string{"movie", "format", "overlay"}
+
+	if overlay.Opacity > 0 && overlay.Opacity < 1 {
+		filters = append(filters, "colorchannelmixer")
+	}
+
+	if overlay.Scale > 0 {
+		filters = append(filters, "scale2ref")
+	}
+
+	return filters
+}
+
+// Escapes filter option value and then the whole filter description, since both
+// filter arguments and filter graph are parsed with their own escaping rules.
+func filterEscape(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
+
+// Returns filter graph, that applies video filters and then burns the overlay. Graph stays
+// simple (single input and output), since the image is read by movie source filter.
+func (overlay *Overlay) filterGraph(videoFilters string) string {
+	image := "movie=" + filterEscape(overlay.ImagePath) + ",format=rgba"
+	if overlay.Opacity > 0 && overlay.Opacity < 1 {
+		image += fmt.Sprintf(",colorchannelmixer=aa=%.3f", overlay.Opacity)
+	}
+
+	graph := []string{
+		image + "[logo]",
+		"[in]" + videoFilters + "[main]",
+	}
+
+	// output size is not always known in advance, so that image is scaled relative to it
+	logo, main := "[logo]", "[main]"
+	if overlay.Scale > 0 {
+		graph = append(graph, fmt.Sprintf("[logo][main]scale2ref=w=main_w*%.6f:h=ow/a[logo_scaled][main_ref]", overlay.Scale))
+		logo, main = "[logo_scaled]", "[main_ref]"
+	}
+
+	x, y := overlay.coordinates()
+	graph = append(graph, fmt.Sprintf("%s%soverlay=x=%s:y=%s:format=auto[out]", main, logo, x, y))
+
+	return strings.Join(graph, ";")
+}
@@ -0,0 +1,317 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+func validateParallel(config *TranscodeConfig, chunks int) error {
+	if chunks < 1 {
+		return fmt.Errorf("%w: number of parallel encodes must be positive", ErrInvalidConfig)
+	}
+
+	// init segment would be written by every encode
+	if config.SegmentFormat != SegmentFormatMPEGTS {
+		return fmt.Errorf("%w: parallel encode is supported only with MPEG-TS segments", ErrInvalidConfig)
+	}
+
+	if config.PartDuration > 0 || config.InputReader != nil || len(config.TeeOutputs) > 0 {
+		return fmt.Errorf("%w: parallel encode is supported neither with partial segments, streamed input nor tee outputs", ErrInvalidConfig)
+	}
+
+	// every chunk would overwrite the playlist
+	if config.Muxer != MuxerSegment {
+		return fmt.Errorf("%w: parallel encode is supported only with segment muxer", ErrInvalidConfig)
+	}
+
+	// every chunk would start at zero
+	if config.ResetTimestamps {
+		return fmt.Errorf("%w: parallel encode cannot be used with reset timestamps", ErrInvalidConfig)
+	}
+
+	if config.CorruptSegmentRetries < 0 {
+		return fmt.Errorf("%w: corrupt segment retries must not be negative", ErrInvalidConfig)
+	}
+
+	// sink would be called concurrently and out of order by the chunks
+	if config.SegmentSink != nil {
+		return fmt.Errorf("%w: parallel encode cannot be used with segment sink", ErrInvalidConfig)
+	}
+
+	// segments must be decodable in the output path
+	if config.CorruptSegmentRetries > 0 && (config.Encryption != nil || config.SegmentSink != nil) {
+		return fmt.Errorf("%w: corrupt segments cannot be detected with encryption nor segment sink", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// splits segment times into contiguous windows of similar segment count,
+// neighbouring windows share the boundary time
+func splitSegmentTimes(segmentTimes []float64, chunks int) [][]float64 {
+	totalSegments := len(segmentTimes) - 1
+	if chunks > totalSegments {
+		chunks = totalSegments
+	}
+
+	windows := [][]float64{}
+	start := 0
+	for i := 0; i < chunks; i++ {
+		end := totalSegments * (i + 1) / chunks
+		windows = append(windows, segmentTimes[start:end+1])
+		start = end
+	}
+
+	return windows
+}
+
+// joins manifests of consecutive windows into manifest of the whole encode
+func mergeManifests(manifests []*Manifest) *Manifest {
+	merged := *manifests[0]
+	merged.Segments = []ManifestSegment{}
+	merged.Metrics = nil
+	merged.Warnings = nil
+	merged.Duration = 0
+
+	for _, manifest := range manifests {
+		merged.Segments = append(merged.Segments, manifest.Segments...)
+		merged.Warnings = append(merged.Warnings, manifest.Warnings...)
+		merged.Duration += manifest.Duration
+	}
+
+	return &merged
+}
+
+// decodes the segment and encodes it again, while it is corrupt, at most retries times,
+// returns whether it was encoded again
+func (e *Encoder) repairSegment(ctx context.Context, config *TranscodeConfig, segmentName string, sequence int, warn func(Warning)) (bool, error) {
+	segmentPath := path.Join(config.OutputDirPath, segmentName)
+
+	for attempt := 0; ; attempt++ {
+		err := checkDecodable(ctx, e.ffmpegBinary, segmentPath, InputOptions{}, 0, 0)
+		if err == nil {
+			return attempt > 0, nil
+		}
+
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		if attempt == config.CorruptSegmentRetries {
+			return false, fmt.Errorf("segment %s is corrupt: %w", segmentName, err)
+		}
+
+		warn(Warning{
+			Kind:    WarningSegmentCorrupt,
+			Message: fmt.Sprintf("segment is corrupt, encoding it again, attempt %d of %d", attempt+1, config.CorruptSegmentRetries),
+			Segment: segmentName,
+			Err:     err,
+		})
+
+		// hooks of the whole encode must not be called by the segment encode
+		segmentConfig := *config
+		segmentConfig.ExitHook = nil
+		segmentConfig.FirstSegmentHook = nil
+		segmentConfig.MetricsHook = nil
+		segmentConfig.MilestoneHook = nil
+
+		if err := e.ReencodeSegments(ctx, segmentConfig, []int{sequence}); err != nil {
+			return false, err
+		}
+	}
+}
+
+// StartParallel splits segment times into chunks, that are encoded concurrently by separate
+// ffmpeg processes, each producing a contiguous range of segments. Every chunk starts at
+// a segment boundary with a new keyframe and keeps source timestamps using -copyts, so that
+// segments are numbered and timed as by a single encode. Segments are delivered in order,
+// those of later chunks once all previous chunks are delivered. Failure of any chunk
+// stops the others. MetricsHook is called for every chunk, ExitHook once, as are milestones,
+// that follow delivered segments. Corrupt segments
+// are encoded again before they are delivered, see TranscodeConfig.CorruptSegmentRetries,
+// SegmentHook is then called again for them.
+func (e *Encoder) StartParallel(ctx context.Context, config TranscodeConfig, chunks int) (*Job, error) {
+	e.applyDefaults(&config)
+
+	logger := config.jobLogger(ctx, e.logger)
+
+	if err := validateParallel(&config, chunks); err != nil {
+		return nil, err
+	}
+
+	if len(config.SegmentTimes) < 2 {
+		return nil, ErrTooFewSegmentTimes
+	}
+
+	// chunks are windows of the preview, that is resolved only once
+	if err := e.resolvePreview(ctx, &config); err != nil {
+		return nil, err
+	}
+
+	// chunks are windows of the tail, so that append point is resolved only once
+	if config.Append != nil {
+		if err := config.Append.validate(&config); err != nil {
+			return nil, err
+		}
+
+		config.SegmentTimes = config.Append.segmentTimes(config.SegmentTimes)
+		config.SegmentOffset = config.Append.NextSegment
+		config.Append = nil
+		if len(config.SegmentTimes) < 2 {
+			return nil, ErrNothingToAppend
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	exitHook := config.ExitHook
+	manifestPath := config.ManifestPath
+
+	// chunks would reach milestones on their own, each against its own total
+	milestones := newMilestoneTracker(config.MilestoneHook, time.Now(), len(config.SegmentTimes)-1, config.SegmentTimes)
+
+	var failOnce sync.Once
+	var failErr error
+
+	windows := splitSegmentTimes(config.SegmentTimes, chunks)
+	jobs := []*Job{}
+	offsets := []int{}
+
+	segmentOffset := config.SegmentOffset
+	for i, window := range windows {
+		chunkConfig := config
+		chunkConfig.SegmentTimes = window
+		chunkConfig.SegmentOffset = segmentOffset
+		chunkConfig.ManifestPath = ""
+		chunkConfig.MilestoneHook = nil
+		chunkConfig.progressHook = func(stats encodeStats) {
+			milestones.decoding(stats.Time)
+		}
+		chunkConfig.ExitHook = func(err error) {
+			// first failure is the root cause, others are killed because of it
+			if err != nil {
+				failOnce.Do(func() {
+					failErr = err
+					cancel()
+				})
+			}
+		}
+		if i > 0 {
+			chunkConfig.FirstSegmentHook = nil
+			chunkConfig.Discontinuity = false
+		}
+		if chunkConfig.JobID != "" {
+			chunkConfig.JobID = fmt.Sprintf("%s-%d", config.JobID, i)
+		}
+		chunkConfig.LogFilePath = config.derivedLogFilePath(fmt.Sprintf("%d", i))
+
+		job, err := e.Start(ctx, chunkConfig)
+		if err != nil {
+			cancel()
+			for _, started := range jobs {
+				started.Wait()
+			}
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+		offsets = append(offsets, segmentOffset)
+		segmentOffset += len(window) - 1
+	}
+
+	job := newJob(len(config.SegmentTimes) - 1)
+	job.cancel = cancel
+
+	produced := make(chan string)
+	go forwardSegments(produced, job.segments)
+
+	go func() {
+		defer cancel()
+
+		var repairErr error
+		var repairWarnings []Warning
+		repaired := map[string]bool{}
+		warn := func(warning Warning) {
+			logger.Warn().Err(warning.Err).Str("segment", warning.Segment).Msg(warning.Message)
+			repairWarnings = append(repairWarnings, warning)
+			config.warn(warning)
+		}
+
+		// segments of later chunks are queued by their jobs meanwhile
+		manifests := []*Manifest{}
+		delivered := 0
+		for i, chunk := range jobs {
+			sequence := offsets[i] - 1
+			for segmentName := range chunk.Segments() {
+				sequence++
+
+				// some chunk or repair failed, so that the context is cancelled and
+				// remaining segments are drained, but not delivered
+				if repairErr != nil || ctx.Err() != nil {
+					continue
+				}
+
+				if config.CorruptSegmentRetries > 0 {
+					ok, err := e.repairSegment(ctx, &config, segmentName, sequence, warn)
+					if err != nil {
+						repairErr = err
+						cancel()
+						continue
+					}
+					repaired[segmentName] = ok
+				}
+
+				produced <- segmentName
+				job.segmentEncoded()
+				delivered++
+				milestones.segmentFinished(delivered)
+			}
+
+			chunk.Wait()
+			manifests = append(manifests, chunk.Manifest())
+		}
+		close(produced)
+
+		// exit hooks were called before jobs finished
+		err := failErr
+		if repairErr != nil {
+			err = repairErr
+		}
+
+		if err == nil {
+			job.manifest = mergeManifests(manifests)
+			for _, warning := range repairWarnings {
+				job.manifest.Warnings = append(job.manifest.Warnings, manifestWarning(warning))
+			}
+
+			// encoded again after the chunk manifest was built
+			for i, segment := range job.manifest.Segments {
+				if !repaired[segment.Name] {
+					continue
+				}
+
+				if event, err := config.segmentEvent(segment.Name, job.manifest.SegmentOffset+i); err == nil {
+					job.manifest.Segments[i].Size = event.Size
+					job.manifest.Segments[i].Checksum = event.Checksum
+				}
+			}
+
+			if manifestPath != "" {
+				if err = job.manifest.writeFile(manifestPath); err != nil {
+					err = fmt.Errorf("unable to write manifest: %w", err)
+				}
+			}
+		}
+
+		if exitHook != nil {
+			exitHook(err)
+		}
+
+		job.finish(err)
+	}()
+
+	return job, nil
+}
@@ -0,0 +1,124 @@
+package hlsvod
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"regexp"
+)
+
+var partialSegmentRegex = regexp.MustCompile(`\.part[0-9]+\.[a-z0-9]+$`)
+
+// IsPartialSegment returns whether segment delivered on the channel is a partial segment.
+func IsPartialSegment(segmentName string) bool {
+	return partialSegmentRegex.MatchString(segmentName)
+}
+
+// returns name of the part of segment with given sequence, e.g. prefix-00001.part2.ts
+func (config *TranscodeConfig) partName(sequence, part int) string {
+	return fmt.Sprintf("%s-"+config.segmentIndexFormat()+".part%d.%s", config.SegmentPrefix, sequence, part, config.SegmentFormat.extension())
+}
+
+// returns file name pattern of parts written by ffmpeg, it contains segment offset,
+// so that concurrent encodes of different windows do not overwrite each other
+func (config *TranscodeConfig) partPattern() string {
+	return fmt.Sprintf("%s-"+config.segmentIndexFormat()+"-parts-%%05d.%s", config.SegmentPrefix, config.SegmentOffset, config.SegmentFormat.extension())
+}
+
+func (config *TranscodeConfig) validateParts() error {
+	if config.PartDuration < 0 {
+		return fmt.Errorf("%w: part duration must not be negative", ErrInvalidConfig)
+	}
+
+	if config.PartDuration == 0 {
+		return nil
+	}
+
+	if config.SegmentStrategy != SegmentByTime {
+		return fmt.Errorf("%w: partial segments require segmenting by time", ErrInvalidConfig)
+	}
+
+	if config.SegmentFormat != SegmentFormatMPEGTS {
+		return fmt.Errorf("%w: partial segments are supported only for MPEG-TS segments", ErrInvalidConfig)
+	}
+
+	// parts would be served unencrypted
+	if config.Encryption != nil {
+		return fmt.Errorf("%w: partial segments cannot be encrypted", ErrInvalidConfig)
+	}
+
+	if config.Resume {
+		return fmt.Errorf("%w: resume cannot be used with partial segments", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// PartTimes splits every segment into equally long parts, that are not longer than part
+// duration. Returns boundaries of parts of every segment, including its start and end.
+func PartTimes(segmentTimes []float64, partDuration float64) [][]float64 {
+	parts := [][]float64{}
+	for i := 1; i < len(segmentTimes); i++ {
+		start, end := segmentTimes[i-1], segmentTimes[i]
+
+		// tolerate rounding errors, so that exact multiples are not split again
+		count := int(math.Ceil((end-start)/partDuration - 1e-6))
+		if count < 1 {
+			count = 1
+		}
+
+		times := []float64{start}
+		for j := 1; j < count; j++ {
+			times = append(times, start+(end-start)*float64(j)/float64(count))
+		}
+
+		parts = append(parts, append(times, end))
+	}
+
+	return parts
+}
+
+// returns boundaries of all parts, that are passed to the segment muxer
+func flattenPartTimes(parts [][]float64) []float64 {
+	times := []float64{parts[0][0]}
+	for _, segmentParts := range parts {
+		times = append(times, segmentParts[1:]...)
+	}
+	return times
+}
+
+// moves part written by ffmpeg from the scratch directory to the write path under its
+// name and publishes it to the output path, returns its name
+func (config *TranscodeConfig) publishPart(writtenName string, sequence, part int) (string, error) {
+	partName := config.partName(sequence, part)
+	partPath := path.Join(config.writeDirPath(), partName)
+
+	if err := publishSegment(path.Join(config.scratchDirPath(), writtenName), partPath); err != nil {
+		return "", err
+	}
+
+	if config.StagingDirPath != "" {
+		if err := publishSegment(partPath, path.Join(config.OutputDirPath, partName)); err != nil {
+			os.Remove(partPath)
+			return "", err
+		}
+	}
+
+	return partName, nil
+}
+
+// Concatenates published parts into full segment in the write path, MPEG-TS parts can be
+// joined byte by byte, since segment muxer starts every one of them with program tables.
+func (config *TranscodeConfig) assembleSegment(segmentName string, partNames []string) error {
+	data := []byte{}
+	for _, partName := range partNames {
+		part, err := os.ReadFile(path.Join(config.OutputDirPath, partName))
+		if err != nil {
+			return err
+		}
+		data = append(data, part...)
+	}
+
+	return writeFileAtomic(path.Join(config.writeDirPath(), segmentName), data)
+}
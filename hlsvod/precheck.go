@@ -0,0 +1,63 @@
+package hlsvod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// how much of the input is decoded by the decodability check, in seconds
+const decodeCheckDuration = 1.0
+
+// Decodes part of the input of given duration starting at given time, so that corrupt or
+// unsupported inputs are rejected before segments are promised to the caller. Whole
+// input is decoded when duration is zero, e.g. to check produced segment.
+func checkDecodable(ctx context.Context, ffmpegBinary string, inputPath string, inputOptions InputOptions, startAt, duration float64) error {
+	args := []string{"-v", "error"}
+	args = append(args, inputOptions.args()...)
+
+	if startAt > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.6f", startAt))
+	}
+
+	args = append(args, "-i", inputPath)
+
+	if duration > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.6f", duration))
+	}
+
+	args = append(args, []string{
+		"-map", "0:V:0?",
+		"-map", "0:a:0?",
+		"-f", "null", "-",
+	}...)
+
+	process := &runner{binary: ffmpegBinary, args: args}
+	stderr, runErr := process.run(ctx)
+
+	// ffmpeg could not be executed at all, it is not an input issue
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return runErr
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+
+	// decode errors do not always change exit code
+	for _, line := range lines {
+		if err := classifyStderr(line); err != nil {
+			return err
+		}
+	}
+
+	if runErr != nil {
+		if line := lines[len(lines)-1]; line != "" {
+			return fmt.Errorf("%w: %s", ErrDecodeFailed, line)
+		}
+		return fmt.Errorf("%w: %v", ErrDecodeFailed, runErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+func (config *TranscodeConfig) validatePreview() error {
+	if config.PreviewDuration < 0 || config.PreviewFrames < 0 {
+		return fmt.Errorf("%w: preview duration and frames must not be negative", ErrInvalidConfig)
+	}
+
+	if config.PreviewDuration == 0 && config.PreviewFrames == 0 {
+		return nil
+	}
+
+	// preview is throwaway output, that must not mix with segments of the full encode
+	if config.Resume || config.Append != nil {
+		return fmt.Errorf("%w: preview cannot be used with resume nor append", ErrInvalidConfig)
+	}
+
+	// frame count is converted to duration using frame rate of the source
+	if config.PreviewFrames > 0 && (config.InputReader != nil || config.ImageInput != nil) {
+		return fmt.Errorf("%w: preview frames can be used neither with streamed nor image input", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// truncates segment times to the preview, so that only its segments are produced, frame
+// count is converted to duration using probed frame rate of the source
+func (e *Encoder) applyPreview(ctx context.Context, config *TranscodeConfig) error {
+	duration := config.PreviewDuration
+
+	if config.PreviewFrames > 0 {
+		var videoInfo *VideoInfo
+		err := e.withProbeTimeout(ctx, func(ctx context.Context) (err error) {
+			videoInfo, err = detectVideoFormat(ctx, e.ffprobeBinary, config.InputFilePath, config.InputOptions)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("unable to probe frame rate for preview: %w", err)
+		}
+
+		frameRate := parseFrameRate(videoInfo.AvgFrameRate)
+		if frameRate <= 0 {
+			return fmt.Errorf("%w: preview frames require known source frame rate", ErrInvalidConfig)
+		}
+
+		framesDuration := float64(config.PreviewFrames) / frameRate
+		if duration == 0 || framesDuration < duration {
+			duration = framesDuration
+		}
+	}
+
+	config.SegmentTimes = truncateSegmentTimes(config.SegmentTimes, config.SegmentTimes[0]+duration)
+	return nil
+}
+
+// Resolves preview of supervised encode once, so that its windows or attempts are not cut
+// again each at its own start. Preview is cleared, frames are not capped by -frames:v then.
+func (e *Encoder) resolvePreview(ctx context.Context, config *TranscodeConfig) error {
+	if config.PreviewDuration == 0 && config.PreviewFrames == 0 {
+		return nil
+	}
+
+	if err := config.validatePreview(); err != nil {
+		return err
+	}
+
+	if err := e.applyPreview(ctx, config); err != nil {
+		return err
+	}
+
+	config.PreviewDuration = 0
+	config.PreviewFrames = 0
+	return nil
+}
+
+// returns segment times ending at the end, boundary too close to it is dropped,
+// so that the last segment is not too short
+func truncateSegmentTimes(segmentTimes []float64, end float64) []float64 {
+	end = math.Min(end, segmentTimes[len(segmentTimes)-1])
+
+	truncated := []float64{segmentTimes[0]}
+	for _, segmentTime := range segmentTimes[1:] {
+		if segmentTime >= end-segmentTimeDelta {
+			break
+		}
+		truncated = append(truncated, segmentTime)
+	}
+
+	return append(truncated, end)
+}
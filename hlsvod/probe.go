@@ -1,12 +1,13 @@
 package hlsvod
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"os/exec"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,24 +22,37 @@ type ProbeMediaData struct {
 }
 
 func ProbeMedia(ctx context.Context, ffprobeBinary string, inputFilePath string) (*ProbeMediaData, error) {
-	args := []string{
+	return ProbeMediaWithOptions(ctx, ffprobeBinary, inputFilePath, InputOptions{})
+}
+
+// ProbeMediaWithOptions probes media using the same input options as the transcode.
+func ProbeMediaWithOptions(ctx context.Context, ffprobeBinary string, inputFilePath string, inputOptions InputOptions) (*ProbeMediaData, error) {
+	return probeMedia(ctx, ffprobeBinary, inputFilePath, nil, inputOptions)
+}
+
+// ProbeReader probes media read from the reader, that is consumed by the probe. Input format
+// must be given, since stream cannot be seeked, and durations may be missing or estimated.
+func ProbeReader(ctx context.Context, ffprobeBinary string, reader io.Reader, inputOptions InputOptions) (*ProbeMediaData, error) {
+	if inputOptions.Format == "" {
+		return nil, fmt.Errorf("%w: input format must be set when reading from stream", ErrInvalidConfig)
+	}
+
+	return probeMedia(ctx, ffprobeBinary, "pipe:0", reader, inputOptions)
+}
+
+func probeMedia(ctx context.Context, ffprobeBinary string, inputFilePath string, stdin io.Reader, inputOptions InputOptions) (*ProbeMediaData, error) {
+	args := append(inputOptions.args(), []string{
 		"-v", "error", // Hide debug information
 		"-show_format",  // Show container information
 		"-show_streams", // Show codec information
 		"-of", "json",
 		inputFilePath,
-	}
+	}...)
 
-	cmd := exec.CommandContext(ctx, ffprobeBinary, args...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+	stdout, stderr, err := runFFprobe(ctx, ffprobeBinary, stdin, args...)
 	if err != nil {
 		// TODO: Handle stderr output.
-		log.Println(stderr.String())
+		log.Println(stderr)
 
 		return nil, err
 	}
@@ -50,10 +64,18 @@ func ProbeMedia(ctx context.Context, ffprobeBinary string, inputFilePath string)
 			Duration  string `json:"duration"`
 
 			// For video streams.
-			Width  int `json:"width"`
-			Height int `json:"height"`
-
-			// For audio streams.
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+			Width          int             `json:"width"`
+			Height         int             `json:"height"`
+			RFrameRate     string          `json:"r_frame_rate"`
+			AvgFrameRate   string          `json:"avg_frame_rate"`
+			ColorTransfer  string          `json:"color_transfer"`
+			ColorPrimaries string          `json:"color_primaries"`
+			SideDataList   []frameSideData `json:"side_data_list"`
+
+			// For audio and video streams.
 			BitRate string `json:"bit_rate"`
 		} `json:"streams"`
 		Format struct {
@@ -62,7 +84,7 @@ func ProbeMedia(ctx context.Context, ffprobeBinary string, inputFilePath string)
 		} `json:"format"`
 	}{}
 
-	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+	if err := json.Unmarshal(stdout, &out); err != nil {
 		return nil, err
 	}
 
@@ -78,14 +100,48 @@ func ProbeMedia(ctx context.Context, ffprobeBinary string, inputFilePath string)
 
 		switch stream.CodecType {
 		case "video":
+			// cover art of audio files is not a video
+			if stream.Disposition.AttachedPic != 0 {
+				continue
+			}
+
 			if data.Video != nil {
 				log.Printf("found multiple video streams for %s\n", inputFilePath)
 			}
 
+			// bitrate is often missing for video streams, e.g. in mkv
+			var bitRate float64
+			if stream.BitRate != "" {
+				bitRate, err = strconv.ParseFloat(stream.BitRate, 64)
+				if err != nil {
+					return nil, fmt.Errorf("unable to parse video stream bitrate: %v", err)
+				}
+			}
+
 			data.Video = &ProbeVideoData{
-				Width:    stream.Width,
-				Height:   stream.Height,
-				Duration: duration,
+				Width:             stream.Width,
+				Height:            stream.Height,
+				Duration:          duration,
+				BitRate:           bitRate,
+				FrameRate:         parseFrameRate(stream.AvgFrameRate),
+				VariableFrameRate: isVariableFrameRate(stream.RFrameRate, stream.AvgFrameRate),
+				ColorTransfer:     stream.ColorTransfer,
+				ColorPrimaries:    stream.ColorPrimaries,
+				HDR:               hdrMetadataFromSideData(stream.SideDataList),
+			}
+
+			// containers do not always carry metadata, that is then found in the first frame,
+			// stream cannot be read again, SDR content has none
+			if data.Video.HDR == nil && isHDRTransfer(stream.ColorTransfer) && stdin == nil {
+				hdr, err := ProbeHDRMetadata(ctx, ffprobeBinary, inputFilePath)
+				if err != nil {
+					log.Printf("unable to probe HDR metadata for %s: %v\n", inputFilePath, err)
+				}
+				data.Video.HDR = hdr
+			}
+
+			if data.Video.VariableFrameRate {
+				log.Printf("found variable frame rate video stream for %s\n", inputFilePath)
 			}
 		case "audio":
 			var bitRate float64
@@ -117,11 +173,83 @@ func ProbeMedia(ctx context.Context, ffprobeBinary string, inputFilePath string)
 	return &data, nil
 }
 
+// ProbeRaw returns unparsed ffprobe output with all format, streams and chapters
+// information, for fields that are not modeled by ProbeMedia.
+func ProbeRaw(ctx context.Context, ffprobeBinary string, inputFilePath string) (json.RawMessage, error) {
+	args := []string{
+		"-v", "error", // Hide debug information
+		"-show_format",   // Show container information
+		"-show_streams",  // Show codec information
+		"-show_chapters", // Show chapters information
+		"-of", "json",
+		inputFilePath,
+	}
+
+	stdout, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
+	if err != nil {
+		// TODO: Handle stderr output.
+		log.Println(stderr)
+
+		return nil, err
+	}
+
+	data := stdout
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("ffprobe returned invalid json")
+	}
+
+	return json.RawMessage(data), nil
+}
+
 type ProbeVideoData struct {
 	Width      int
 	Height     int
 	Duration   time.Duration
 	PktPtsTime []float64
+	BitRate    float64 // in bits per second, 0 if unknown
+
+	FrameRate         float64 // average frame rate
+	VariableFrameRate bool
+
+	ColorTransfer  string       // e.g. smpte2084 for PQ or arib-std-b67 for HLG
+	ColorPrimaries string       // e.g. bt2020
+	HDR            *HDRMetadata // Mastering display and content light level, nil if absent.
+}
+
+// parses ffprobe rational frame rate, e.g. 30000/1001, returns 0 if unknown
+func parseFrameRate(rate string) float64 {
+	parts := strings.SplitN(rate, "/", 2)
+
+	numerator, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+
+	if len(parts) == 1 {
+		return numerator
+	}
+
+	denominator, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || denominator == 0 {
+		return 0
+	}
+
+	return numerator / denominator
+}
+
+// allowed relative difference between real base and average frame rate
+const frameRateTolerance = 0.01
+
+func isVariableFrameRate(rFrameRate, avgFrameRate string) bool {
+	r := parseFrameRate(rFrameRate)
+	avg := parseFrameRate(avgFrameRate)
+
+	// unable to tell, when any of them is unknown
+	if r == 0 || avg == 0 {
+		return false
+	}
+
+	return math.Abs(r-avg)/r > frameRateTolerance
 }
 
 func ProbeVideo(ctx context.Context, ffprobeBinary string, inputFilePath string) (*ProbeVideoData, error) {
@@ -139,16 +267,10 @@ func ProbeVideo(ctx context.Context, ffprobeBinary string, inputFilePath string)
 		inputFilePath,
 	}
 
-	cmd := exec.CommandContext(ctx, ffprobeBinary, args...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+	stdout, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
 	if err != nil {
 		// TODO: Handle stderr output.
-		log.Println(stderr.String())
+		log.Println(stderr)
 
 		return nil, err
 	}
@@ -168,7 +290,7 @@ func ProbeVideo(ctx context.Context, ffprobeBinary string, inputFilePath string)
 		} `json:"format"`
 	}{}
 
-	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+	if err := json.Unmarshal(stdout, &out); err != nil {
 		return nil, err
 	}
 
@@ -214,6 +336,73 @@ func ProbeVideo(ctx context.Context, ffprobeBinary string, inputFilePath string)
 	return &data, nil
 }
 
+// KeyframeTimes returns presentation times of source video keyframes in seconds, sorted,
+// so that segment times can be aligned to them, e.g. for copied video.
+func KeyframeTimes(ctx context.Context, ffprobeBinary string, inputFilePath string) ([]float64, error) {
+	return KeyframeTimesInRange(ctx, ffprobeBinary, inputFilePath, 0, 0)
+}
+
+// KeyframeTimesInRange returns keyframe times between start and end in seconds, that can
+// be much faster for long sources. Range is open-ended when end is zero. Demuxer seeks to
+// the keyframe before start, so that it is filtered out.
+func KeyframeTimesInRange(ctx context.Context, ffprobeBinary string, inputFilePath string, start, end float64) ([]float64, error) {
+	args := []string{
+		"-v", "error",
+		// keyframe flags of packets are read by the demuxer, decoding
+		// with -skip_frame nokey would be much slower
+		"-show_entries", "packet=pts_time,flags",
+		"-select_streams", "V:0",
+	}
+
+	if start > 0 || end > 0 {
+		interval := fmt.Sprintf("%.6f%%", start)
+		if end > 0 {
+			interval += fmt.Sprintf("%.6f", end)
+		}
+		args = append(args, "-read_intervals", interval)
+	}
+
+	args = append(args, "-of", "json", inputFilePath)
+
+	stdout, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
+	}
+
+	out := struct {
+		Packets []struct {
+			PtsTime string `json:"pts_time"`
+			Flags   string `json:"flags"`
+		} `json:"packets"`
+	}{}
+
+	if err := json.Unmarshal(stdout, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	times := []float64{}
+	for _, packet := range out.Packets {
+		if packet.PtsTime == "" || packet.PtsTime == "N/A" || !strings.HasPrefix(packet.Flags, "K") {
+			continue
+		}
+
+		ptsTime, err := strconv.ParseFloat(packet.PtsTime, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if ptsTime < start || (end > 0 && ptsTime > end) {
+			continue
+		}
+
+		times = append(times, ptsTime)
+	}
+
+	// packets are in decoding order
+	sort.Float64s(times)
+	return times, nil
+}
+
 type ProbeAudioData struct {
 	Duration time.Duration
 	BitRate  float64
@@ -231,16 +420,10 @@ func ProbeAudio(ctx context.Context, ffprobeBinary string, inputFilePath string)
 		inputFilePath,
 	}
 
-	cmd := exec.CommandContext(ctx, ffprobeBinary, args...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+	stdout, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
 	if err != nil {
 		// TODO: Handle stderr output.
-		log.Println(stderr.String())
+		log.Println(stderr)
 
 		return nil, err
 	}
@@ -255,7 +438,7 @@ func ProbeAudio(ctx context.Context, ffprobeBinary string, inputFilePath string)
 		} `json:"format"`
 	}{}
 
-	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+	if err := json.Unmarshal(stdout, &out); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,240 @@
+package hlsvod
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// chroma subsampling of pixel formats
+const (
+	subsampling420 = "420"
+	subsampling422 = "422"
+	subsampling444 = "444"
+)
+
+// planar formats end with bit depth, e.g. yuv420p10le
+var pixelFormatDepthRegex = regexp.MustCompile(`p(\d+)(le|be)?$`)
+
+// semi-planar and packed formats encode subsampling and bit depth in the name, e.g. p210le or v210
+var pixelFormatPackedRegex = regexp.MustCompile(`^[pv]\d(\d\d)(le|be)?$`)
+
+// returns chroma subsampling and bit depth of pixel format, unknown formats are assumed to be 8-bit 4:2:0
+func pixelFormatInfo(pixelFormat string) (subsampling string, bitDepth int) {
+	subsampling, bitDepth = subsampling420, 8
+
+	if is422Format(pixelFormat) {
+		subsampling = subsampling422
+	} else if strings.Contains(pixelFormat, "444") || strings.HasPrefix(pixelFormat, "gbr") {
+		subsampling = subsampling444
+	}
+
+	if match := pixelFormatPackedRegex.FindStringSubmatch(pixelFormat); match != nil {
+		bitDepth, _ = strconv.Atoi(match[1])
+	} else if match := pixelFormatDepthRegex.FindStringSubmatch(pixelFormat); match != nil {
+		bitDepth, _ = strconv.Atoi(match[1])
+	}
+
+	return
+}
+
+// Returns codec specific profile args for source subsampling and bit depth. Codecs without
+// matching profile get pixel format converted to the closest one they can encode.
+func selectProfile(codec string, subsampling string, bitDepth int) (profileArgs []string) {
+	highDepth := bitDepth > 8
+
+	switch codec {
+	case "libx264":
+		switch {
+		case subsampling == subsampling444:
+			return []string{"-profile:v", "high444"}
+		case subsampling == subsampling422:
+			return []string{"-profile:v", "high422"}
+		case highDepth:
+			return []string{"-profile:v", "high10"}
+		default:
+			return []string{"-profile:v", "high"}
+		}
+	case "libx265":
+		switch {
+		case subsampling == subsampling444 && highDepth:
+			return []string{"-profile:v", "main444-10"}
+		case subsampling == subsampling444:
+			return []string{"-profile:v", "main444-8"}
+		case subsampling == subsampling422:
+			// there is no 8-bit 4:2:2 profile
+			return []string{"-profile:v", "main422-10", "-pix_fmt", "yuv422p10le"}
+		case highDepth:
+			return []string{"-profile:v", "main10"}
+		default:
+			return []string{"-profile:v", "main"}
+		}
+	case "libvpx-vp9":
+		// profiles 1 and 3 are for 4:2:2 and 4:4:4, profiles 2 and 3 for high bit depth
+		profile := 0
+		if subsampling != subsampling420 {
+			profile++
+		}
+		if highDepth {
+			profile += 2
+		}
+		return []string{"-profile:v", strconv.Itoa(profile)}
+	case "libaom-av1", "libsvtav1":
+		// 4:2:2 requires professional profile, that is not supported
+		// by players, so that chroma is downsampled instead
+		if subsampling == subsampling422 {
+			if highDepth {
+				return []string{"-pix_fmt", "yuv420p10le"}
+			}
+			return []string{"-pix_fmt", "yuv420p"}
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// pixel formats encoders accept, in order of preference when converting, JPEG-range
+// formats are omitted since range is converted by the scale filter
+var encoderPixelFormats = map[string][]string{
+	"libx264":    {"yuv420p", "yuv420p10le", "yuv422p", "yuv422p10le", "yuv444p", "yuv444p10le"},
+	"libx265":    {"yuv420p", "yuv420p10le", "yuv420p12le", "yuv422p", "yuv422p10le", "yuv422p12le", "yuv444p", "yuv444p10le", "yuv444p12le", "gbrp", "gbrp10le", "gbrp12le"},
+	"libvpx-vp9": {"yuv420p", "yuv420p10le", "yuv420p12le", "yuv422p", "yuv422p10le", "yuv422p12le", "yuv444p", "yuv444p10le", "yuv444p12le", "gbrp", "gbrp10le", "gbrp12le"},
+	"libaom-av1": {"yuv420p", "yuv420p10le", "yuv420p12le", "yuv422p", "yuv422p10le", "yuv422p12le", "yuv444p", "yuv444p10le", "yuv444p12le", "gbrp", "gbrp10le", "gbrp12le"},
+	"libsvtav1":  {"yuv420p", "yuv420p10le"},
+}
+
+// chroma subsamplings and bit depths allowed by codec profiles, as selected by selectProfile
+type pixelFormatLimits struct {
+	subsamplings []string
+	bitDepths    []int
+}
+
+var (
+	limits420    = []string{subsampling420}
+	limits422    = []string{subsampling420, subsampling422}
+	limitsAll    = []string{subsampling420, subsampling422, subsampling444}
+	limitsNon420 = []string{subsampling422, subsampling444}
+)
+
+var profilePixelFormatLimits = map[string]map[string]pixelFormatLimits{
+	"libx264": {
+		"baseline": {limits420, []int{8}},
+		"main":     {limits420, []int{8}},
+		"high":     {limits420, []int{8}},
+		"high10":   {limits420, []int{8, 10}},
+		"high422":  {limits422, []int{8, 10}},
+		"high444":  {limitsAll, []int{8, 10}},
+	},
+	"libx265": {
+		"main":       {limits420, []int{8}},
+		"main10":     {limits420, []int{8, 10}},
+		"main12":     {limits420, []int{8, 10, 12}},
+		"main422-10": {limits422, []int{8, 10}},
+		"main422-12": {limits422, []int{8, 10, 12}},
+		"main444-8":  {limitsAll, []int{8}},
+		"main444-10": {limitsAll, []int{8, 10}},
+		"main444-12": {limitsAll, []int{8, 10, 12}},
+	},
+	"libvpx-vp9": {
+		"0": {limits420, []int{8}},
+		"1": {limitsNon420, []int{8}},
+		"2": {limits420, []int{10, 12}},
+		"3": {limitsNon420, []int{10, 12}},
+	},
+	"libaom-av1": {
+		"main":         {limits420, []int{8, 10}},
+		"high":         {[]string{subsampling420, subsampling444}, []int{8, 10}},
+		"professional": {limitsAll, []int{8, 10, 12}},
+	},
+	"libsvtav1": {
+		"main": {limits420, []int{8, 10}},
+	},
+}
+
+func (limits pixelFormatLimits) allows(pixelFormat string) bool {
+	subsampling, bitDepth := pixelFormatInfo(pixelFormat)
+
+	subsamplingAllowed := false
+	for _, allowed := range limits.subsamplings {
+		subsamplingAllowed = subsamplingAllowed || allowed == subsampling
+	}
+
+	for _, allowed := range limits.bitDepths {
+		if subsamplingAllowed && allowed == bitDepth {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SupportedPixelFormats returns pixel formats, that codec can encode using given profile,
+// in order of preference. Empty profile means any profile of the codec. Returns nil for
+// unknown codec or profile.
+func SupportedPixelFormats(codec, profile string) []string {
+	formats, ok := encoderPixelFormats[codec]
+	if !ok {
+		return nil
+	}
+
+	if profile == "" {
+		return append([]string{}, formats...)
+	}
+
+	limits, ok := profilePixelFormatLimits[codec][profile]
+	if !ok {
+		return nil
+	}
+
+	supported := []string{}
+	for _, format := range formats {
+		if limits.allows(format) {
+			supported = append(supported, format)
+		}
+	}
+
+	return supported
+}
+
+// NeedsConversion returns whether pixel format must be converted before encoding using codec
+// and profile, and the closest supported pixel format. Chroma is preserved if possible, then
+// bit depth, so that the least information is lost. Suggested format is empty, if codec or
+// profile is unknown.
+func NeedsConversion(pixFmt, codec, profile string) (bool, string) {
+	supported := SupportedPixelFormats(codec, profile)
+	for _, format := range supported {
+		if format == pixFmt {
+			return false, pixFmt
+		}
+	}
+
+	subsampling, bitDepth := pixelFormatInfo(pixFmt)
+	chromaRank := map[string]int{subsampling420: 0, subsampling422: 1, subsampling444: 2}
+
+	// lost information weighs more than wasted bits
+	penalty := func(format string) int {
+		formatSubsampling, formatDepth := pixelFormatInfo(format)
+
+		chroma := chromaRank[formatSubsampling] - chromaRank[subsampling]
+		if chroma < 0 {
+			chroma = -chroma * 100
+		}
+
+		depth := formatDepth - bitDepth
+		if depth < 0 {
+			depth = -depth * 10
+		}
+
+		return chroma*10 + depth
+	}
+
+	suggested := ""
+	for _, format := range supported {
+		if suggested == "" || penalty(format) < penalty(suggested) {
+			suggested = format
+		}
+	}
+
+	return true, suggested
+}
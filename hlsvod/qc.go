@@ -0,0 +1,140 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TimeRange is a range of source media time in seconds.
+type TimeRange struct {
+	Start float64
+	End   float64
+}
+
+// QCOptions tune detection of black video and silent audio, zero values keep filter defaults.
+type QCOptions struct {
+	MinDuration      float64 // Shortest reported range in seconds, 2 when zero.
+	PixelThreshold   float64 // Luminance ratio from 0 to 1, below which pixel is black, 0.1 when zero.
+	NoiseThresholdDB float64 // Audio level in dB, below which it is silence, -60 when zero.
+}
+
+const (
+	defaultQCMinDuration    = 2
+	defaultQCPixelThreshold = 0.1
+	defaultQCNoiseThreshold = -60
+)
+
+// QCReport lists black and silent ranges of the source.
+type QCReport struct {
+	Black  []TimeRange
+	Silent []TimeRange
+}
+
+var (
+	blackdetectRegex  = regexp.MustCompile(`black_start:\s*(\S+)\s+black_end:\s*(\S+)`)
+	silenceStartRegex = regexp.MustCompile(`silence_start:\s*(\S+)`)
+	silenceEndRegex   = regexp.MustCompile(`silence_end:\s*(\S+)`)
+)
+
+// DetectBlackAndSilence decodes the whole input using blackdetect and silencedetect filters,
+// that report ranges of black video and silent audio, usually caused by source problems.
+// It is a separate pass, its report is mapped to segments using Manifest.ApplyQC.
+func DetectBlackAndSilence(ctx context.Context, ffmpegBinary string, inputPath string, options QCOptions) (*QCReport, error) {
+	minDuration := options.MinDuration
+	if minDuration == 0 {
+		minDuration = defaultQCMinDuration
+	}
+
+	pixelThreshold := options.PixelThreshold
+	if pixelThreshold == 0 {
+		pixelThreshold = defaultQCPixelThreshold
+	}
+
+	noiseThreshold := options.NoiseThresholdDB
+	if noiseThreshold == 0 {
+		noiseThreshold = defaultQCNoiseThreshold
+	}
+
+	if minDuration < 0 || pixelThreshold < 0 || pixelThreshold > 1 || noiseThreshold > 0 {
+		return nil, fmt.Errorf("%w: invalid QC options", ErrInvalidConfig)
+	}
+
+	// filters report at info level, stats would be mixed in otherwise
+	process := &runner{binary: ffmpegBinary, args: []string{
+		"-loglevel", "info",
+		"-nostats",
+		"-i", inputPath,
+		"-map", "0:V:0?",
+		"-map", "0:a:0?",
+		"-vf", fmt.Sprintf("blackdetect=d=%g:pix_th=%g", minDuration, pixelThreshold),
+		"-af", fmt.Sprintf("silencedetect=n=%gdB:d=%g", noiseThreshold, minDuration),
+		"-f", "null", "-",
+	}}
+
+	stderr, err := process.run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect black and silence: %w", err)
+	}
+
+	return parseQCOutput(stderr), nil
+}
+
+func parseQCOutput(output string) *QCReport {
+	report := &QCReport{}
+
+	silenceStart := -1.0
+	for _, line := range strings.Split(output, "\n") {
+		if match := blackdetectRegex.FindStringSubmatch(line); match != nil {
+			start, err1 := strconv.ParseFloat(match[1], 64)
+			end, err2 := strconv.ParseFloat(match[2], 64)
+			if err1 == nil && err2 == nil {
+				report.Black = append(report.Black, TimeRange{Start: start, End: end})
+			}
+			continue
+		}
+
+		if match := silenceStartRegex.FindStringSubmatch(line); match != nil {
+			if start, err := strconv.ParseFloat(match[1], 64); err == nil {
+				silenceStart = start
+			}
+			continue
+		}
+
+		if match := silenceEndRegex.FindStringSubmatch(line); match != nil && silenceStart >= 0 {
+			if end, err := strconv.ParseFloat(match[1], 64); err == nil {
+				report.Silent = append(report.Silent, TimeRange{Start: silenceStart, End: end})
+			}
+			silenceStart = -1
+		}
+	}
+
+	return report
+}
+
+// returns whether ranges cover the whole range from start to end, up to the tolerance
+func coveredBy(ranges []TimeRange, start, end float64) bool {
+	for _, r := range ranges {
+		if r.Start <= start+segmentTimeDelta && r.End >= end-segmentTimeDelta {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyQC flags segments, that are entirely black or silent according to the report
+// of the source, segments are located using Start and their durations.
+func (manifest *Manifest) ApplyQC(report *QCReport) {
+	start := manifest.Start
+	for i := range manifest.Segments {
+		segment := &manifest.Segments[i]
+		end := start + segment.Duration
+
+		segment.Black = coveredBy(report.Black, start, end)
+		segment.Silent = coveredBy(report.Silent, start, end)
+
+		start = end
+	}
+}
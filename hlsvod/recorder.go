@@ -0,0 +1,33 @@
+package hlsvod
+
+import "time"
+
+// MetricsRecorder receives events of encode lifecycle, so that they can be exported by the
+// application, e.g. as Prometheus counters and histograms, without this package depending on
+// a metrics library. Every ffmpeg process started by Encoder.Start is reported, including
+// those of supervised encodes, e.g. parallel chunks or fallback attempts. Methods are called
+// from multiple goroutines and must not block. See also Encoder.QueueDepth and ActiveJobs.
+type MetricsRecorder interface {
+	// ffmpeg process has been started.
+	EncodeStarted()
+	// Segment has been delivered, segments skipped by Resume are not reported.
+	SegmentProduced()
+	// ffmpeg process exited, err is nil on success. Speed is average multiple of real time,
+	// zero if ffmpeg did not report it.
+	EncodeFinished(elapsed time.Duration, speed float64, err error)
+}
+
+type noopMetricsRecorder struct{}
+
+func (noopMetricsRecorder) EncodeStarted()                               {}
+func (noopMetricsRecorder) SegmentProduced()                             {}
+func (noopMetricsRecorder) EncodeFinished(time.Duration, float64, error) {}
+
+// WithMetricsRecorder sets recorder of encode lifecycle events, they are discarded by default.
+func WithMetricsRecorder(recorder MetricsRecorder) Option {
+	return func(e *Encoder) {
+		if recorder != nil {
+			e.recorder = recorder
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package hlsvod
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// how much can complete segment be shorter than requested, in seconds
+const resumeDurationTolerance = 0.5
+
+// default digits of zero-padded segment number
+const defaultSegmentIndexWidth = 5
+
+// returns printf verb of zero-padded segment number, e.g. %05d
+func (config *TranscodeConfig) segmentIndexFormat() string {
+	width := config.SegmentIndexWidth
+	if width == 0 {
+		width = defaultSegmentIndexWidth
+	}
+	return fmt.Sprintf("%%0%dd", width)
+}
+
+func (config *TranscodeConfig) segmentName(sequence int) string {
+	return fmt.Sprintf("%s-"+config.segmentIndexFormat()+".%s", config.SegmentPrefix, sequence, config.SegmentFormat.extension())
+}
+
+// returns segment name including its start time in seconds, e.g. prefix-00123-45.678.ts
+func (config *TranscodeConfig) timestampedSegmentName(sequence int, startTime float64) string {
+	return fmt.Sprintf("%s-"+config.segmentIndexFormat()+"-%.3f.%s", config.SegmentPrefix, sequence, startTime, config.SegmentFormat.extension())
+}
+
+type OverwritePolicy int
+
+const (
+	// Existing segments are overwritten (default).
+	OverwriteAlways OverwritePolicy = iota
+	// Leading complete segments are kept and skipped, as with Resume, incomplete
+	// segment and those following it are overwritten.
+	OverwriteSkip
+	// Encode fails with ErrOutputExists before ffmpeg is started, if any segment exists.
+	OverwriteError
+)
+
+// returns ErrOutputExists if any segment of the encode exists in the output path
+func (config *TranscodeConfig) checkExistingSegments() error {
+	names := []string{}
+	if config.SegmentFormat == SegmentFormatDASH {
+		names = append(names, config.initSegmentName())
+	}
+
+	for i := 0; i < len(config.SegmentTimes)-1; i++ {
+		sequence := config.SegmentOffset + i
+		if config.TimestampedNames {
+			names = append(names, config.timestampedSegmentName(sequence, config.SegmentTimes[i]))
+		} else {
+			names = append(names, config.segmentName(sequence))
+		}
+	}
+
+	for _, name := range names {
+		if _, err := os.Stat(path.Join(config.OutputDirPath, name)); err == nil {
+			return fmt.Errorf("%w: %s", ErrOutputExists, name)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns number of leading segments, that already exist in the output directory and are complete
+func (e *Encoder) completeSegments(ctx context.Context, config *TranscodeConfig) int {
+	totalSegments := len(config.SegmentTimes) - 1
+
+	for i := 0; i < totalSegments; i++ {
+		segmentPath := path.Join(config.OutputDirPath, config.segmentName(config.SegmentOffset+i))
+		duration := config.SegmentTimes[i+1] - config.SegmentTimes[i]
+
+		if !e.isSegmentComplete(ctx, segmentPath, duration, config.Encryption != nil) {
+			return i
+		}
+	}
+
+	return totalSegments
+}
+
+// Segment left behind by an interrupted encode can be truncated, so that its
+// duration is verified. Encrypted segments cannot be probed, only their size is checked.
+func (e *Encoder) isSegmentComplete(ctx context.Context, segmentPath string, duration float64, encrypted bool) bool {
+	stat, err := os.Stat(segmentPath)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() == 0 {
+		return false
+	}
+
+	if encrypted {
+		return stat.Size()%aes.BlockSize == 0
+	}
+
+	segmentDuration, err := probeSegmentDuration(ctx, e.ffprobeBinary, segmentPath)
+	if err != nil {
+		return false
+	}
+
+	return segmentDuration >= duration-resumeDurationTolerance
+}
+
+func probeSegmentDuration(ctx context.Context, ffprobeBinary string, segmentPath string) (float64, error) {
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "json",
+		segmentPath,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
+	}
+
+	var probeOutput struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probeOutput); err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	return strconv.ParseFloat(probeOutput.Format.Duration, 64)
+}
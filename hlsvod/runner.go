@@ -0,0 +1,150 @@
+package hlsvod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/m1k1o/go-transcode/internal/utils/cmdgroup"
+)
+
+// stdoutMode declares, how is stdout of the subprocess interpreted.
+type stdoutMode int
+
+const (
+	// stdout is discarded
+	stdoutIgnored stdoutMode = iota
+	// lines listing finished segments, that are read by the caller from runner.stdout
+	stdoutSegmentList
+	// binary output, e.g. image2pipe, that is copied to stdoutWriter
+	stdoutData
+)
+
+// runner is the subprocess plumbing shared by encodes and one-shot ffmpeg commands. Process
+// is started in its own process group, so that it can be killed with its children.
+type runner struct {
+	binary string
+	args   []string
+	env    []string // added to environment of this process
+	stdin  io.Reader
+
+	// CPUs the process and its children are pinned to from the start, if set, failure
+	// to pin them is not fatal, the process is started unpinned and error is kept here
+	cpuAffinity []int
+	affinityErr error
+
+	stdoutMode   stdoutMode
+	stdoutWriter io.Writer // for stdoutData
+
+	cmd    *exec.Cmd
+	stdout io.Reader // for stdoutSegmentList
+	stderr io.Reader
+
+	stdoutDone chan struct{} // closed once stdout is consumed by the runner
+	stdoutErr  error
+}
+
+// starts the process, stderr must be read until EOF by the caller before wait
+func (r *runner) start(ctx context.Context) error {
+	r.cmd = exec.CommandContext(ctx, r.binary, r.args...)
+	r.cmd.Stdin = r.stdin
+	if len(r.env) > 0 {
+		r.cmd.Env = append(os.Environ(), r.env...)
+	}
+	cmdgroup.Configure(r.cmd)
+
+	var stdout io.Reader
+	if r.stdoutMode != stdoutIgnored {
+		pipe, err := r.cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		stdout = pipe
+	}
+
+	stderr, err := r.cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	r.stderr = stderr
+
+	if len(r.cpuAffinity) > 0 {
+		restore, err := cmdgroup.PinThread(r.cpuAffinity)
+		if err != nil {
+			r.affinityErr = err
+		} else {
+			defer restore()
+		}
+	}
+
+	if err := r.cmd.Start(); err != nil {
+		return err
+	}
+
+	r.stdoutDone = make(chan struct{})
+	switch r.stdoutMode {
+	case stdoutSegmentList:
+		// consumed by the caller
+		r.stdout = stdout
+		close(r.stdoutDone)
+	case stdoutData:
+		go func() {
+			defer close(r.stdoutDone)
+			_, r.stdoutErr = io.Copy(r.stdoutWriter, stdout)
+		}()
+	default:
+		close(r.stdoutDone)
+	}
+
+	return nil
+}
+
+// waits for the process to exit, pipes must not be read afterwards
+func (r *runner) wait() error {
+	<-r.stdoutDone
+
+	if err := r.cmd.Wait(); err != nil {
+		return err
+	}
+
+	if r.stdoutErr != nil {
+		return fmt.Errorf("unable to read stdout: %w", r.stdoutErr)
+	}
+
+	return nil
+}
+
+// runs the process to completion and returns its stderr
+func (r *runner) run(ctx context.Context) (string, error) {
+	if err := r.start(ctx); err != nil {
+		return "", err
+	}
+
+	var stderr bytes.Buffer
+	_, readErr := io.Copy(&stderr, r.stderr)
+
+	err := r.wait()
+	if err == nil && readErr != nil {
+		err = readErr
+	}
+
+	return stderr.String(), err
+}
+
+// runs ffprobe to completion and returns its stdout and stderr
+func runFFprobe(ctx context.Context, ffprobeBinary string, stdin io.Reader, args ...string) ([]byte, string, error) {
+	var stdout bytes.Buffer
+	process := &runner{
+		binary:       ffprobeBinary,
+		args:         args,
+		stdin:        stdin,
+		stdoutMode:   stdoutData,
+		stdoutWriter: &stdout,
+	}
+
+	stderr, err := process.run(ctx)
+	return stdout.Bytes(), stderr, err
+}
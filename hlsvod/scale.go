@@ -0,0 +1,387 @@
+package hlsvod
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Resolution tier, defined by the length of the shorter side in pixels.
+type Resolution int
+
+const (
+	Res240p  Resolution = 240
+	Res360p  Resolution = 360
+	Res480p  Resolution = 480
+	Res540p  Resolution = 540
+	Res720p  Resolution = 720
+	Res1080p Resolution = 1080
+	Res1440p Resolution = 1440
+	Res2160p Resolution = 2160
+)
+
+// ParseResolution parses resolution tier string, e.g. 720p.
+func ParseResolution(s string) (Resolution, error) {
+	value, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "p"))
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidResolution, s)
+	}
+
+	return Resolution(value), nil
+}
+
+// Dimensions returns standard 16:9 landscape dimensions of the tier.
+func (r Resolution) Dimensions() (width, height int) {
+	height = int(r)
+	width = (height*16/9 + 1) / 2 * 2
+	return
+}
+
+func (r Resolution) String() string {
+	return fmt.Sprintf("%dp", int(r))
+}
+
+// AspectMode defines how is source fitted into the profile frame.
+type AspectMode int
+
+const (
+	// Keep source aspect ratio, frame size is only an upper bound (default).
+	AspectFit AspectMode = iota
+	// Scale to cover the whole frame and trim what overflows.
+	AspectCrop
+	// Scale to fit inside the frame and fill the rest with black bars.
+	AspectPad
+	// Output has exactly the frame size, e.g. every rendition of a ladder is 1280x720. Source
+	// is scaled to fit inside, but never upscaled unless AllowUpscale, and letterboxed or
+	// pillarboxed. Resolution tier is always landscape regardless of the source orientation.
+	AspectExact
+)
+
+// scaling algorithms supported by ffmpeg scaler
+var scaleFlags = []string{
+	"fast_bilinear", "bilinear", "bicubic", "experimental", "neighbor",
+	"area", "bicublin", "gauss", "sinc", "lanczos", "spline",
+}
+
+func isScaleFlag(flag string) bool {
+	for _, f := range scaleFlags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// returns rotation of the source in degrees, normalized to 0, 90, 180 or 270
+func (info *VideoInfo) rotation() int {
+	var rotation float64
+	for _, sideData := range info.SideDataList {
+		if sideData.Rotation != 0 {
+			rotation = sideData.Rotation
+			break
+		}
+	}
+
+	if rotation == 0 && info.Tags.Rotate != "" {
+		rotation, _ = strconv.ParseFloat(info.Tags.Rotate, 64)
+	}
+
+	degrees := int(math.Round(rotation/90)) * 90 % 360
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+// returns sample aspect ratio, 1 if unknown
+func (info *VideoInfo) sampleAspectRatio() float64 {
+	parts := strings.SplitN(info.SampleAspectRatio, ":", 2)
+	if len(parts) != 2 {
+		return 1
+	}
+
+	num, err1 := strconv.ParseFloat(parts[0], 64)
+	den, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+		return 1
+	}
+
+	return num / den
+}
+
+// anamorphic sources have non-square pixels, e.g. DVD
+func (info *VideoInfo) isAnamorphic() bool {
+	return math.Abs(info.sampleAspectRatio()-1) > 0.01
+}
+
+// Returns size, that the source is displayed at, with square pixels and after rotation.
+// Anamorphic sources keep their height and have width stretched by sample aspect ratio.
+func (info *VideoInfo) displaySize() (width, height int) {
+	width = int(math.Round(float64(info.Width) * info.sampleAspectRatio()))
+	height = info.Height
+
+	if rotation := info.rotation(); rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	return
+}
+
+// returns exact output frame size, tier orientation follows the source
+func frameSize(profile *VideoProfile, videoInfo *VideoInfo) (width, height int) {
+	if profile.Resolution == 0 {
+		return profile.Width, profile.Height
+	}
+
+	width, height = profile.Resolution.Dimensions()
+	if profile.AspectMode != AspectExact && videoInfo != nil && isPortrait(videoInfo) {
+		width, height = height, width
+	}
+	return
+}
+
+// Returns size of the encoded frames, fitted output differs from the frame size, since its
+// unconstrained side follows the source aspect ratio, e.g. 2.39:1 source fitted into 1280x720
+// is 1720x720. Frame size is returned if the source is unknown.
+func outputSize(profile *VideoProfile, videoInfo *VideoInfo) (width, height int) {
+	if profile.AspectMode != AspectFit || videoInfo == nil || videoInfo.Width <= 0 || videoInfo.Height <= 0 {
+		return frameSize(profile, videoInfo)
+	}
+
+	sourceWidth, sourceHeight := videoInfo.displaySize()
+	constrainHeight, size := scaleTarget(profile, videoInfo)
+
+	// constrained side is capped by the source and rounded down by the scale filter
+	if constrainHeight {
+		if !profile.AllowUpscale && sourceHeight < size {
+			size = sourceHeight / 2 * 2
+		}
+		return int(math.Round(float64(size*sourceWidth)/float64(sourceHeight)/2)) * 2, size
+	}
+
+	if !profile.AllowUpscale && sourceWidth < size {
+		size = sourceWidth / 2 * 2
+	}
+	return size, int(math.Round(float64(size*sourceHeight)/float64(sourceWidth)/2)) * 2
+}
+
+func isPortrait(videoInfo *VideoInfo) bool {
+	width, height := videoInfo.displaySize()
+	return width < height
+}
+
+// returns source scaled to fit into (or cover) the frame, rounded to even numbers,
+// ok is false if source dimensions are unknown
+func aspectScaleSize(profile *VideoProfile, videoInfo *VideoInfo) (width, height int, ok bool) {
+	if videoInfo == nil || videoInfo.Width <= 0 || videoInfo.Height <= 0 {
+		return 0, 0, false
+	}
+
+	sourceWidth, sourceHeight := videoInfo.displaySize()
+
+	frameWidth, frameHeight := frameSize(profile, videoInfo)
+	widthRatio := float64(frameWidth) / float64(sourceWidth)
+	heightRatio := float64(frameHeight) / float64(sourceHeight)
+
+	ratio := math.Min(widthRatio, heightRatio)
+	if profile.AspectMode == AspectCrop {
+		ratio = math.Max(widthRatio, heightRatio)
+	}
+
+	// remaining space is padded instead
+	if profile.AspectMode == AspectExact && !profile.AllowUpscale {
+		ratio = math.Min(ratio, 1)
+	}
+
+	width = int(math.Round(float64(sourceWidth)*ratio/2)) * 2
+	height = int(math.Round(float64(sourceHeight)*ratio/2)) * 2
+	return width, height, true
+}
+
+// returns crop or pad filter, that follows the scale filter
+func aspectFilter(profile *VideoProfile, videoInfo *VideoInfo) string {
+	if profile.AspectMode == AspectFit {
+		// anamorphic source has been scaled to square pixels
+		if videoInfo != nil && videoInfo.isAnamorphic() {
+			return ",setsar=1"
+		}
+		return ""
+	}
+
+	frameWidth, frameHeight := frameSize(profile, videoInfo)
+
+	filter := "crop"
+	if profile.AspectMode != AspectCrop {
+		filter = "pad"
+	}
+
+	width, height, ok := aspectScaleSize(profile, videoInfo)
+	if !ok {
+		// let ffmpeg compute offsets, crop is centered by default
+		if filter == "pad" {
+			return fmt.Sprintf(",pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1", frameWidth, frameHeight)
+		}
+		return fmt.Sprintf(",crop=%d:%d,setsar=1", frameWidth, frameHeight)
+	}
+
+	// offsets are rounded down to even numbers, so that chroma is not shifted
+	x := abs(frameWidth-width) / 4 * 2
+	y := abs(frameHeight-height) / 4 * 2
+	return fmt.Sprintf(",%s=%d:%d:%d:%d,setsar=1", filter, frameWidth, frameHeight, x, y)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// returns whether height (or width otherwise) should be constrained and its target size
+func scaleTarget(profile *VideoProfile, videoInfo *VideoInfo) (constrainHeight bool, size int) {
+	if profile.Resolution == 0 {
+		if profile.Width >= profile.Height {
+			return true, profile.Height
+		}
+		return false, profile.Width
+	}
+
+	// tier defines shorter side, orientation is given by the source aspect ratio
+	// and landscape is assumed if the source dimensions are unknown
+	portrait := videoInfo != nil && isPortrait(videoInfo)
+	return !portrait, int(profile.Resolution)
+}
+
+func scaleFilter(profile *VideoProfile, videoInfo *VideoInfo) string {
+	// source is scaled to exact size, that is then cropped or padded
+	if profile.AspectMode != AspectFit {
+		if width, height, ok := aspectScaleSize(profile, videoInfo); ok {
+			return fmt.Sprintf("scale=%d:%d", width, height)
+		}
+
+		frameWidth, frameHeight := frameSize(profile, videoInfo)
+		if profile.AspectMode == AspectExact && !profile.AllowUpscale {
+			return fmt.Sprintf("scale='min(iw,%d)':'min(ih,%d)':force_original_aspect_ratio=decrease", frameWidth, frameHeight)
+		}
+		if profile.AspectMode == AspectPad || profile.AspectMode == AspectExact {
+			return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", frameWidth, frameHeight)
+		}
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase", frameWidth, frameHeight)
+	}
+
+	constrainHeight, size := scaleTarget(profile, videoInfo)
+
+	// anamorphic source is scaled to square pixels at its display aspect ratio,
+	// scale filter sees frames after rotation, so that sar and dar are rotated too
+	if videoInfo != nil && videoInfo.isAnamorphic() {
+		return anamorphicScaleFilter(profile.AllowUpscale, constrainHeight, size)
+	}
+
+	if profile.AllowUpscale {
+		if constrainHeight {
+			return fmt.Sprintf("scale=-2:%d", size)
+		}
+		return fmt.Sprintf("scale=%d:-2", size)
+	}
+
+	// constrained side is capped by the source size, so that it is never upscaled,
+	// and rounded down to even number, since odd source sizes are not encodable
+	if constrainHeight {
+		return fmt.Sprintf("scale=-2:'trunc(min(ih,%d)/2)*2'", size)
+	}
+	return fmt.Sprintf("scale='trunc(min(iw,%d)/2)*2':-2", size)
+}
+
+func anamorphicScaleFilter(allowUpscale bool, constrainHeight bool, size int) string {
+	if allowUpscale {
+		if constrainHeight {
+			return fmt.Sprintf("scale='trunc(%d*dar/2)*2':%d", size, size)
+		}
+		return fmt.Sprintf("scale=%d:'trunc(%d/dar/2)*2'", size, size)
+	}
+
+	// displayed width of the source is iw*sar
+	if constrainHeight {
+		return fmt.Sprintf("scale='trunc(min(ih,%d)*dar/2)*2':'trunc(min(ih,%d)/2)*2'", size, size)
+	}
+	return fmt.Sprintf("scale='trunc(min(iw*sar,%d)/2)*2':'trunc(min(iw*sar,%d)/dar/2)*2'", size, size)
+}
+
+// returns whether output is going to be larger than the source
+func isUpscaled(profile *VideoProfile, videoInfo *VideoInfo) bool {
+	if !profile.AllowUpscale || videoInfo == nil {
+		return false
+	}
+
+	width, height := videoInfo.displaySize()
+
+	constrainHeight, size := scaleTarget(profile, videoInfo)
+	if constrainHeight {
+		return size > height
+	}
+	return size > width
+}
+
+// whether output pixel format has subsampled chroma, that requires even dimensions,
+// output follows the source format, unknown source is encoded as 4:2:0
+func requiresEvenSize(profile *VideoProfile, videoInfo *VideoInfo) bool {
+	if profile.Baseline || videoInfo == nil {
+		return true
+	}
+	return !strings.Contains(videoInfo.PixelFormat, "444")
+}
+
+// Verifies that output frame size is encodable. Sizes given by the profile are used as is,
+// so that odd sizes fail for subsampled chroma, unless RoundOddDimensions allows rounding them
+// down to even numbers, that returns adjusted copy of the profile. Sizes derived from the
+// source are rounded by the scale filter, but extreme aspect ratios can collapse them.
+func checkFrameSize(profile *VideoProfile, videoInfo *VideoInfo) (*VideoProfile, error) {
+	if requiresEvenSize(profile, videoInfo) && profile.Resolution == 0 {
+		// cropped and padded output has exact size, fitted output only its
+		// constrained side, that is rounded by the scale filter, unless upscaled
+		constrainHeight, _ := scaleTarget(profile, videoInfo)
+		exact := profile.AspectMode != AspectFit
+		oddWidth := profile.Width%2 != 0 && (exact || profile.AllowUpscale && !constrainHeight)
+		oddHeight := profile.Height%2 != 0 && (exact || profile.AllowUpscale && constrainHeight)
+
+		if oddWidth || oddHeight {
+			if !profile.RoundOddDimensions {
+				return nil, fmt.Errorf("%w: output size %dx%d must be even for subsampled chroma", ErrInvalidVideoProfile, profile.Width, profile.Height)
+			}
+
+			rounded := *profile
+			if oddWidth {
+				rounded.Width--
+			}
+			if oddHeight {
+				rounded.Height--
+			}
+			profile = &rounded
+		}
+	}
+
+	if profile.AspectMode != AspectFit || videoInfo == nil || videoInfo.Width <= 0 || videoInfo.Height <= 0 {
+		return profile, nil
+	}
+
+	// side computed from aspect ratio must keep at least one chroma sample
+	width, height := videoInfo.displaySize()
+	constrainHeight, size := scaleTarget(profile, videoInfo)
+	if !profile.AllowUpscale {
+		if constrainHeight && height < size {
+			size = height
+		} else if !constrainHeight && width < size {
+			size = width
+		}
+	}
+
+	other := size * width / height
+	if !constrainHeight {
+		other = size * height / width
+	}
+	if other < 2 {
+		return nil, fmt.Errorf("%w: source aspect ratio %dx%d cannot be fitted into %d pixels", ErrInvalidVideoProfile, width, height, size)
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,310 @@
+package hlsvod
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type SegmentStrategy int
+
+const (
+	// Cut at SegmentTimes, keyframes are forced at every segment time.
+	SegmentByTime SegmentStrategy = iota
+	// Cut every SegmentFrames video frames, keyframes are forced at every cut.
+	SegmentByFrames
+	// Cut approximately every SegmentSize bytes. Segment duration is estimated from
+	// profile bitrates, so that real size varies with content complexity and cuts
+	// still happen only at keyframes. Treat it as a hint, not as a limit.
+	SegmentBySize
+)
+
+// SegmentMuxerOptions tune ffmpeg segment muxer, muxer defaults are used when not set.
+type SegmentMuxerOptions struct {
+	// Write container header and trailer to every segment, so that every
+	// segment is a complete file on its own.
+	IndividualHeaderTrailer bool
+	// Start timestamps of every segment at zero, instead of keeping continuous timestamps
+	// of the source. Segments can then be played individually, but players relying on
+	// continuous timestamps across segments (e.g. HLS without discontinuities) may not.
+	ResetTimestamps bool
+
+	// Maximum demux-decode delay (-muxdelay) and initial delay (-muxpreload) of MPEG-TS
+	// segments in seconds, muxer defaults of 0.7 and 0.5 when nil. Timestamps of every
+	// segment are then shifted by the delay, that strict players show as gaps or overlaps
+	// between segments. Zero is recommended for HLS, see StrictHLSSegments.
+	MuxDelay   *float64
+	MuxPreload *float64
+
+	// Stream layout and service information of MPEG-TS segments.
+	MPEGTS *MPEGTSOptions
+}
+
+// SelfContainedSegments returns segment muxer options for segments, that are independently
+// decodable. Keyframes are forced at segment times already, BreakNonKeyframes must not be set.
+func SelfContainedSegments() *SegmentMuxerOptions {
+	return &SegmentMuxerOptions{
+		IndividualHeaderTrailer: true,
+		ResetTimestamps:         true,
+	}
+}
+
+// StrictHLSSegments returns segment muxer options for players, that require segments to
+// join without gaps. Every segment starts with its own PAT and PMT, timestamps continue
+// across segments and MPEG-TS mux delay is removed.
+func StrictHLSSegments() *SegmentMuxerOptions {
+	zero := 0.0
+	return &SegmentMuxerOptions{
+		IndividualHeaderTrailer: true,
+		MuxDelay:                &zero,
+		MuxPreload:              &zero,
+	}
+}
+
+func (opts *SegmentMuxerOptions) args() []string {
+	args := []string{
+		"-individual_header_trailer", boolArg(opts.IndividualHeaderTrailer),
+		"-reset_timestamps", boolArg(opts.ResetTimestamps),
+	}
+
+	if opts.MuxDelay != nil {
+		args = append(args, "-muxdelay", fmt.Sprintf("%.3f", *opts.MuxDelay))
+	}
+
+	if opts.MuxPreload != nil {
+		args = append(args, "-muxpreload", fmt.Sprintf("%.3f", *opts.MuxPreload))
+	}
+
+	return args
+}
+
+func boolArg(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func (config *TranscodeConfig) validateSegmentStrategy() error {
+	switch config.SegmentStrategy {
+	case SegmentByTime:
+	case SegmentByFrames:
+		if config.SegmentFrames <= 0 {
+			return fmt.Errorf("%w: segment frames must be positive", ErrInvalidSegmentStrategy)
+		}
+
+		if config.VideoProfile == nil {
+			return fmt.Errorf("%w: segmenting by frames requires video profile", ErrInvalidSegmentStrategy)
+		}
+	case SegmentBySize:
+		if config.SegmentSize <= 0 {
+			return fmt.Errorf("%w: segment size must be positive", ErrInvalidSegmentStrategy)
+		}
+
+		if config.VideoProfile == nil || config.VideoProfile.Bitrate <= 0 {
+			return fmt.Errorf("%w: segmenting by size requires video profile bitrate", ErrInvalidSegmentStrategy)
+		}
+	default:
+		return fmt.Errorf("%w: unknown segment strategy %d", ErrInvalidSegmentStrategy, config.SegmentStrategy)
+	}
+
+	muxer := config.SegmentMuxer
+	if muxer != nil {
+		if (muxer.MuxDelay != nil && *muxer.MuxDelay < 0) || (muxer.MuxPreload != nil && *muxer.MuxPreload < 0) {
+			return fmt.Errorf("%w: mux delay and preload must not be negative", ErrInvalidSegmentStrategy)
+		}
+
+		if muxer.MPEGTS != nil {
+			if config.SegmentFormat != SegmentFormatMPEGTS {
+				return fmt.Errorf("%w: mpegts options require MPEG-TS segments", ErrInvalidConfig)
+			}
+
+			if err := muxer.MPEGTS.validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if muxer != nil && muxer.ResetTimestamps {
+		// every part would start at zero, so that assembled segment would be broken
+		if config.PartDuration > 0 {
+			return fmt.Errorf("%w: timestamps cannot be reset with partial segments", ErrInvalidSegmentStrategy)
+		}
+
+		if config.BreakNonKeyframes {
+			return fmt.Errorf("%w: self-contained segments must start with keyframe", ErrInvalidSegmentStrategy)
+		}
+	}
+
+	return nil
+}
+
+func formatSegmentTimes(segmentTimes []float64) string {
+	fmtSegTimes := []string{}
+	for _, segmentTime := range segmentTimes {
+		fmtSegTimes = append(
+			fmtSegTimes,
+			fmt.Sprintf("%.6f", segmentTime),
+		)
+	}
+
+	return strings.Join(fmtSegTimes, ",")
+}
+
+// evenly spaced segment times, so that every segment fits approximately into segment size
+func sizeSegmentTimes(config TranscodeConfig, startAt, endAt float64) []float64 {
+	bitrate := config.VideoProfile.Bitrate
+	if config.AudioProfile != nil {
+		bitrate += config.AudioProfile.Bitrate
+	}
+
+	// bitrate is in kbit/s
+	segmentDuration := float64(config.SegmentSize*8) / float64(bitrate*1000)
+
+	segmentTimes := []float64{startAt}
+	for t := startAt + segmentDuration; t < endAt; t += segmentDuration {
+		segmentTimes = append(segmentTimes, t)
+	}
+
+	return append(segmentTimes, endAt)
+}
+
+// Returns times, where segments are cut by the strategy, relative to TrimStart as segment times,
+// that give only start and end of the encode, unless segmenting by time. Frame cuts are unknown
+// without frame rate, then segment times are returned, but such encode fails anyway.
+func strategySegmentTimes(config *TranscodeConfig, segmentTimes []float64, videoInfo *VideoInfo) []float64 {
+	startAt, endAt := segmentTimes[0], segmentTimes[len(segmentTimes)-1]
+
+	switch config.SegmentStrategy {
+	case SegmentBySize:
+		return sizeSegmentTimes(*config, startAt, endAt)
+	case SegmentByFrames:
+		var frameRate float64
+		if videoInfo != nil {
+			frameRate = parseFrameRate(videoInfo.AvgFrameRate)
+		}
+
+		if frameRate <= 0 {
+			return segmentTimes
+		}
+
+		// same frames as passed to the muxer by segmentationArgs
+		totalFrames := int(math.Ceil((endAt - startAt) * frameRate))
+		times := []float64{startAt}
+		for n := config.SegmentFrames; n < totalFrames; n += config.SegmentFrames {
+			times = append(times, startAt+float64(n)/frameRate)
+		}
+
+		return append(times, endAt)
+	default:
+		return segmentTimes
+	}
+}
+
+// Returns segment muxer arguments splitting at inner segment times. End time must not be
+// passed to the muxer, since a keyframe within -segment_time_delta before it would cause
+// an extra trailing segment, that would take file name of the next window's first segment.
+// Muxer sees timestamps shifted by -output_ts_offset, so that its times are shifted as well.
+// Evenly spaced times are given by expression and duration instead of listing all of them,
+// since single argument listing thousands of times exceeds command line length limits.
+func segmentTimesArgs(segmentTimes []float64, outputOffset float64) (forceKeyFrames string, segmentArgs []string) {
+	innerTimes := segmentTimes[1 : len(segmentTimes)-1]
+	if len(innerTimes) == 0 {
+		// muxer splits every 2 seconds by default, so that it must be told to never split
+		return "", []string{"-segment_time", fmt.Sprintf("%d", neverSplitSegmentTime)}
+	}
+
+	step, even := evenSegmentDuration(segmentTimes)
+	if even {
+		forceKeyFrames = fmt.Sprintf("expr:gte(t,%.6f+n_forced*%.6f)", segmentTimes[0], step)
+	} else {
+		forceKeyFrames = formatSegmentTimes(innerTimes)
+	}
+
+	// Muxer splits every duration counted from zero, that must be where the encode starts.
+	// Last segment must be shorter by time delta, otherwise a keyframe near the end would split it.
+	start := segmentTimes[0] - outputOffset
+	last := segmentTimes[len(segmentTimes)-1] - innerTimes[len(innerTimes)-1]
+	if even && math.Abs(start) < segmentTimesTolerance && last < step-segmentTimeDelta {
+		return forceKeyFrames, []string{"-segment_time", fmt.Sprintf("%.6f", step)}
+	}
+
+	muxerTimes := make([]float64, len(innerTimes))
+	for i, segmentTime := range innerTimes {
+		muxerTimes[i] = segmentTime - outputOffset
+	}
+
+	return forceKeyFrames, []string{"-segment_times", formatSegmentTimes(muxerTimes)}
+}
+
+// how much can segment times deviate from even spacing, in seconds
+const segmentTimesTolerance = 0.000001
+
+// segment muxer splits on keyframes, that are this many seconds before the split time
+const segmentTimeDelta = 0.2
+
+// Returns duration of segments, if inner segment times are evenly spaced from the start.
+// Last segment can be of any duration. At least two inner times are needed, so that a
+// single one is not considered evenly spaced.
+func evenSegmentDuration(segmentTimes []float64) (float64, bool) {
+	if len(segmentTimes) < 4 {
+		return 0, false
+	}
+
+	start := segmentTimes[0]
+	step := segmentTimes[1] - start
+	if step <= 0 {
+		return 0, false
+	}
+
+	for i := 2; i < len(segmentTimes)-1; i++ {
+		if math.Abs(segmentTimes[i]-(start+float64(i)*step)) > segmentTimesTolerance {
+			return 0, false
+		}
+	}
+
+	return step, true
+}
+
+// segment duration, that is longer than any input, in seconds
+const neverSplitSegmentTime = 1000000000
+
+// returns value for -force_key_frames and segment muxer arguments specific to the strategy
+func segmentationArgs(config TranscodeConfig, videoInfo *VideoInfo, startAt, endAt float64) (string, []string, error) {
+	switch config.SegmentStrategy {
+	case SegmentByFrames:
+		var frameRate float64
+		if videoInfo != nil {
+			frameRate = parseFrameRate(videoInfo.AvgFrameRate)
+		}
+
+		if frameRate <= 0 {
+			return "", nil, fmt.Errorf("%w: segmenting by frames requires known source frame rate", ErrInvalidSegmentStrategy)
+		}
+
+		// segment muxer requires list of frame numbers, frames are counted from zero
+		totalFrames := int(math.Ceil((endAt - startAt) * frameRate))
+		frames := []string{}
+		for n := config.SegmentFrames; n < totalFrames; n += config.SegmentFrames {
+			frames = append(frames, fmt.Sprintf("%d", n))
+		}
+
+		forceKeyFrames := fmt.Sprintf("expr:gte(n,n_forced*%d)", config.SegmentFrames)
+		if len(frames) == 0 {
+			// everything fits into single segment, that muxer would split every 2 seconds
+			return forceKeyFrames, []string{"-segment_time", fmt.Sprintf("%d", neverSplitSegmentTime)}, nil
+		}
+
+		return forceKeyFrames, []string{"-segment_frames", strings.Join(frames, ",")}, nil
+	case SegmentBySize:
+		forceKeyFrames, segmentArgs := segmentTimesArgs(sizeSegmentTimes(config, startAt, endAt), config.TrimStart)
+		return forceKeyFrames, segmentArgs, nil
+	default:
+		forceKeyFrames, segmentArgs := segmentTimesArgs(config.SegmentTimes, config.TrimStart)
+		if config.PartDuration > 0 {
+			// muxer cuts parts, keyframes are still forced only at segment times
+			_, segmentArgs = segmentTimesArgs(flattenPartTimes(PartTimes(config.SegmentTimes, config.PartDuration)), config.TrimStart)
+		}
+		return forceKeyFrames, segmentArgs, nil
+	}
+}
@@ -0,0 +1,39 @@
+package hlsvod
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// SegmentSink opens destination of a finished segment, e.g. object storage upload.
+type SegmentSink func(segmentName string) (io.WriteCloser, error)
+
+// copies segment from the output path to the sink and removes the local file
+func (config *TranscodeConfig) sinkSegment(segmentName string) error {
+	segmentPath := path.Join(config.OutputDirPath, segmentName)
+
+	file, err := os.Open(segmentPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := config.SegmentSink(segmentName)
+	if err != nil {
+		return fmt.Errorf("unable to open sink: %w", err)
+	}
+
+	if _, err := io.Copy(w, file); err != nil {
+		w.Close()
+		return fmt.Errorf("unable to write to sink: %w", err)
+	}
+
+	// writers, e.g. uploads, often report errors only when closed
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("unable to close sink: %w", err)
+	}
+
+	return os.Remove(segmentPath)
+}
@@ -0,0 +1,63 @@
+package hlsvod
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moves finished segment from staging to output directory, so that
+// consumers of the output directory never read a partially written segment
+func publishSegment(stagingPath string, outputPath string) error {
+	err := os.Rename(stagingPath, outputPath)
+	if err == nil {
+		return nil
+	}
+
+	// rename does not work across filesystems
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copySegment(stagingPath, outputPath); err != nil {
+		return err
+	}
+
+	return os.Remove(stagingPath)
+}
+
+// copies segment to temporary file in the output directory first, then renames it
+func copySegment(stagingPath string, outputPath string) error {
+	src, err := os.Open(stagingPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), filepath.Base(outputPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// data must be on the disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), outputPath)
+}
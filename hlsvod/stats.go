@@ -0,0 +1,196 @@
+package hlsvod
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeMetrics are aggregate statistics of a finished encode.
+type EncodeMetrics struct {
+	Frames     int           // Total encoded frames.
+	DupFrames  int           // Frames duplicated to keep frame rate.
+	DropFrames int           // Frames dropped to keep frame rate.
+	MediaTime  float64       // Encoded media time in seconds.
+	FPS        float64       // Average encoded frames per second.
+	Speed      float64       // Average speed multiplier, below 1 is slower than real time.
+	WallTime   time.Duration // Total time ffmpeg was running.
+
+	Bitrate         float64   // Average output bitrate in kbit/s reported by ffmpeg.
+	SegmentBitrates []float64 // Bitrate of every encoded segment in kbit/s, from its size and duration.
+	PeakBitrate     float64   // Highest segment bitrate in kbit/s.
+
+	// Average x264 quantizer weighted by frame count, higher values mean heavier quantization
+	// and lower quality. It is reported only when log level is info or more verbose, 0 otherwise.
+	AvgQP float64
+}
+
+// single ffmpeg stats line, e.g.
+// frame=  240 fps= 60 q=28.0 size=     512kB time=00:00:10.00 bitrate= 419.4kbits/s dup=0 drop=3 speed=2.51x
+type encodeStats struct {
+	Frame   int
+	FPS     float64
+	Time    float64 // in seconds
+	Bitrate float64 // in kbits/s
+	Dup     int
+	Drop    int
+	Speed   float64
+}
+
+var statsFieldRegex = regexp.MustCompile(`(\w+)=\s*(\S+)`)
+
+func parseStatsLine(line string) (encodeStats, bool) {
+	stats := encodeStats{}
+
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "frame=") && !strings.HasPrefix(line, "size=") {
+		return stats, false
+	}
+
+	for _, match := range statsFieldRegex.FindAllStringSubmatch(line, -1) {
+		key, value := match[1], match[2]
+
+		switch key {
+		case "frame":
+			stats.Frame, _ = strconv.Atoi(value)
+		case "fps":
+			stats.FPS, _ = strconv.ParseFloat(value, 64)
+		case "time":
+			stats.Time = parseStatsTime(value)
+		case "bitrate":
+			stats.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "dup":
+			stats.Dup, _ = strconv.Atoi(value)
+		case "drop":
+			stats.Drop, _ = strconv.Atoi(value)
+		case "speed":
+			stats.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		}
+	}
+
+	return stats, true
+}
+
+// parses time in HH:MM:SS.ms format, returns 0 if invalid
+func parseStatsTime(value string) float64 {
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		num, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + num
+	}
+
+	if negative {
+		return -seconds
+	}
+	return seconds
+}
+
+func (stats encodeStats) metrics(wallTime time.Duration) EncodeMetrics {
+	metrics := EncodeMetrics{
+		Frames:     stats.Frame,
+		DupFrames:  stats.Dup,
+		DropFrames: stats.Drop,
+		MediaTime:  stats.Time,
+		Speed:      stats.Speed,
+		WallTime:   wallTime,
+		Bitrate:    stats.Bitrate,
+	}
+
+	if seconds := wallTime.Seconds(); seconds > 0 {
+		metrics.FPS = float64(stats.Frame) / seconds
+
+		// speed is not reported when it cannot be computed
+		if metrics.Speed == 0 {
+			metrics.Speed = stats.Time / seconds
+		}
+	}
+
+	return metrics
+}
+
+// x264 summary printed at info level, one line per frame type, e.g.
+// [libx264 @ 0x5581c4c0] frame I:3     Avg QP:21.07  size: 31234
+var x264FrameSummaryRegex = regexp.MustCompile(`^\[libx264 @ [^\]]+\] frame [IPB]:\s*([0-9]+)\s+Avg QP:\s*([0-9.]+)`)
+
+// accumulates x264 summary of frame types into average quantizer
+type x264Summary struct {
+	frames int
+	qpSum  float64
+}
+
+// returns false if the line is not x264 frame summary
+func (summary *x264Summary) parseLine(line string) bool {
+	match := x264FrameSummaryRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return false
+	}
+
+	frames, err1 := strconv.Atoi(match[1])
+	qp, err2 := strconv.ParseFloat(match[2], 64)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	summary.frames += frames
+	summary.qpSum += float64(frames) * qp
+	return true
+}
+
+func (summary *x264Summary) avgQP() float64 {
+	if summary.frames == 0 {
+		return 0
+	}
+	return summary.qpSum / float64(summary.frames)
+}
+
+// computes bitrates of segments from their sizes, segment times must belong to them
+func (metrics *EncodeMetrics) addSegmentBitrates(segments []string, events map[string]SegmentEvent, segmentTimes []float64) {
+	for i, segmentName := range segments {
+		if i+1 >= len(segmentTimes) {
+			break
+		}
+
+		event, ok := events[segmentName]
+		duration := segmentTimes[i+1] - segmentTimes[i]
+		if !ok || duration <= 0 {
+			continue
+		}
+
+		bitrate := float64(event.Size*8) / 1000 / duration
+		metrics.SegmentBitrates = append(metrics.SegmentBitrates, bitrate)
+		if bitrate > metrics.PeakBitrate {
+			metrics.PeakBitrate = bitrate
+		}
+	}
+}
+
+// bufio.SplitFunc, that splits on both new lines and carriage returns,
+// since ffmpeg separates periodic stats lines by a carriage return
+func scanStderrLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
@@ -0,0 +1,186 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// StreamDelivery selects what is delivered for every finished segment.
+type StreamDelivery int
+
+const (
+	// Segment is read and its content is delivered in Data.
+	StreamData StreamDelivery = iota
+	// Only the path is delivered, Data is nil, so that segments are not held in memory.
+	StreamPath
+)
+
+// StreamOptions bound memory used by streamed segments, at most Buffer+1 segments
+// of at most MaxSegmentSize bytes are held at once.
+type StreamOptions struct {
+	Delivery       StreamDelivery
+	Buffer         int   // Segments queued for slow consumer, 1 when zero.
+	MaxSegmentSize int64 // Larger segment fails the encode with ErrSegmentTooLarge, 32 MiB when zero.
+}
+
+const (
+	defaultStreamBuffer         = 1
+	defaultStreamMaxSegmentSize = 32 << 20
+)
+
+func (options *StreamOptions) validate(config *TranscodeConfig) error {
+	if options.Delivery != StreamData && options.Delivery != StreamPath {
+		return fmt.Errorf("%w: unknown stream delivery %d", ErrInvalidConfig, options.Delivery)
+	}
+
+	if options.Buffer < 0 || options.MaxSegmentSize < 0 {
+		return fmt.Errorf("%w: stream buffer and segment size limit must not be negative", ErrInvalidConfig)
+	}
+
+	// segments are removed from the output path before they could be read
+	if config.SegmentSink != nil {
+		return fmt.Errorf("%w: stream cannot be used with segment sink", ErrInvalidConfig)
+	}
+
+	// parts would take segment numbers and be counted as finished segments
+	if config.PartDuration > 0 {
+		return fmt.Errorf("%w: stream cannot be used with partial segments", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// SegmentData is a finished segment delivered by stream.
+type SegmentData struct {
+	Name  string // Base name relative to OutputDirPath.
+	Path  string // Path of the segment in OutputDirPath.
+	Index int    // Segment number, -1 for the init segment.
+	Data  []byte // Content of the segment, nil with StreamPath delivery.
+}
+
+// reads the whole segment, unless it is larger than the limit
+func readSegment(segmentPath string, limit int64) ([]byte, error) {
+	file, err := os.Open(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// one more byte reveals the limit has been exceeded
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrSegmentTooLarge, path.Base(segmentPath), limit)
+	}
+
+	return data, nil
+}
+
+// StartStream starts transcode, that delivers finished segments on the returned channel
+// instead of the job's segment channel, which is closed right away. Unlike segment names,
+// streamed segments are not queued without limit, ffmpeg is not slowed down by a slow
+// consumer, but no more than Buffer segments are read ahead. The channel is closed once
+// the last segment is delivered or the encode fails, Job then reports the error.
+func (e *Encoder) StartStream(ctx context.Context, config TranscodeConfig, options StreamOptions) (*Job, <-chan SegmentData, error) {
+	e.applyDefaults(&config)
+
+	if err := options.validate(&config); err != nil {
+		return nil, nil, err
+	}
+
+	buffer := options.Buffer
+	if buffer == 0 {
+		buffer = defaultStreamBuffer
+	}
+
+	maxSegmentSize := options.MaxSegmentSize
+	if maxSegmentSize == 0 {
+		maxSegmentSize = defaultStreamMaxSegmentSize
+	}
+
+	// segment numbers continue from the append point
+	index := config.SegmentOffset
+	if config.Append != nil {
+		index = config.Append.NextSegment
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	exitHook := config.ExitHook
+	config.ExitHook = nil
+
+	inner, err := e.Start(ctx, config)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	job := newJob(inner.total)
+	job.cancel = cancel
+	close(job.segments)
+
+	data := make(chan SegmentData, buffer)
+
+	go func() {
+		defer cancel()
+
+		var err error
+		for segmentName := range inner.Segments() {
+			// drain remaining segments, so that the inner job can finish
+			if err != nil {
+				continue
+			}
+
+			segment := SegmentData{
+				Name:  segmentName,
+				Path:  path.Join(config.OutputDirPath, segmentName),
+				Index: -1,
+			}
+
+			if !IsInitSegment(segmentName) {
+				segment.Index = index
+				index++
+			}
+
+			if options.Delivery == StreamData {
+				segment.Data, err = readSegment(segment.Path, maxSegmentSize)
+				if err != nil {
+					inner.Cancel()
+					continue
+				}
+			}
+
+			select {
+			case data <- segment:
+				if segment.Index >= 0 {
+					job.segmentEncoded()
+				}
+			case <-ctx.Done():
+			}
+		}
+		close(data)
+
+		// read error is the root cause of cancelled encode
+		if innerErr := inner.Wait(); err == nil {
+			err = innerErr
+		}
+
+		if err == nil {
+			job.manifest = inner.Manifest()
+		}
+
+		if exitHook != nil {
+			exitHook(err)
+		}
+
+		job.finish(err)
+	}()
+
+	return job, data, nil
+}
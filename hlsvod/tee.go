@@ -0,0 +1,63 @@
+package hlsvod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TeeOutput is an additional output written in the same ffmpeg pass as segments,
+// e.g. an archival MP4. Encoded streams are shared, so that all outputs have the
+// same codec settings given by profiles and cover the same time as SegmentTimes.
+// Output is finalized only when ffmpeg exits successfully, formats that write
+// index at the end (e.g. mp4) are unusable when encode is canceled.
+type TeeOutput struct {
+	Path   string // Output file path.
+	Format string // Muxer name, e.g. mp4 or matroska, guessed from path when empty.
+}
+
+func (output *TeeOutput) validate() error {
+	if output.Path == "" {
+		return fmt.Errorf("%w: tee output path must be set", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// escapes tee slave option value, option separator and escape character itself
+func teeEscapeOption(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `:`, `\:`).Replace(value)
+}
+
+// quotes tee slave, so that slave separator and brackets in paths are kept
+func teeQuoteSlave(slave string) string {
+	return "'" + strings.ReplaceAll(slave, "'", `'\''`) + "'"
+}
+
+// Returns tee muxer args, segment muxer is the first slave, so that completed segments
+// are still reported on stdout. Segment options are given as -flag value pairs.
+func teeArgs(segmentOptions []string, segmentPath string, outputs []TeeOutput) []string {
+	options := []string{"f=segment"}
+	for i := 0; i+1 < len(segmentOptions); i += 2 {
+		key := strings.TrimPrefix(segmentOptions[i], "-")
+		options = append(options, key+"="+teeEscapeOption(segmentOptions[i+1]))
+	}
+
+	slaves := []string{
+		teeQuoteSlave("[" + strings.Join(options, ":") + "]" + segmentPath),
+	}
+
+	for _, output := range outputs {
+		slave := output.Path
+		if output.Format != "" {
+			slave = "[f=" + teeEscapeOption(output.Format) + "]" + slave
+		}
+		slaves = append(slaves, teeQuoteSlave(slave))
+	}
+
+	return []string{
+		// Containers such as mp4 require codec headers out of band,
+		// mpegts muxer still repeats them in band on keyframes.
+		"-flags", "+global_header",
+		"-f", "tee", strings.Join(slaves, "|"),
+	}
+}
@@ -0,0 +1,173 @@
+package hlsvod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+type ThumbnailOptions struct {
+	OutputDirPath string // Thumbnails output path.
+	Prefix        string // e.g. prefix-000001.jpg
+	Format        string // jpg (default) or png
+	Width         int    // Height is computed from aspect ratio, source width when zero.
+	Quality       int    // JPEG qscale, 2 (best) to 31 (worst), encoder default when zero.
+}
+
+func (opts *ThumbnailOptions) validate() error {
+	switch opts.Format {
+	case "":
+		opts.Format = "jpg"
+	case "jpg", "png":
+	default:
+		return fmt.Errorf("%w: unsupported thumbnail format %q", ErrInvalidThumbnailOptions, opts.Format)
+	}
+
+	if opts.Width < 0 {
+		return fmt.Errorf("%w: thumbnail width must not be negative", ErrInvalidThumbnailOptions)
+	}
+
+	if opts.Quality != 0 && (opts.Quality < 2 || opts.Quality > 31) {
+		return fmt.Errorf("%w: thumbnail quality must be between 2 and 31", ErrInvalidThumbnailOptions)
+	}
+
+	return nil
+}
+
+func (opts *ThumbnailOptions) outputArgs() []string {
+	args := []string{}
+
+	if opts.Width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", opts.Width))
+	}
+
+	if opts.Quality > 0 && opts.Format == "jpg" {
+		args = append(args, "-q:v", fmt.Sprintf("%d", opts.Quality))
+	}
+
+	return args
+}
+
+func runFFmpeg(ctx context.Context, ffmpegBinary string, args []string) error {
+	process := &runner{binary: ffmpegBinary, args: args}
+
+	if stderr, err := process.run(ctx); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr))
+	}
+
+	return nil
+}
+
+// ExtractThumbnails seeks to each timestamp and writes a single frame as an image,
+// returns paths of written images in the same order as the timestamps.
+func ExtractThumbnails(ctx context.Context, ffmpegBinary string, inputPath string, times []float64, opts ThumbnailOptions) ([]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for i, time := range times {
+		outputPath := path.Join(opts.OutputDirPath, fmt.Sprintf("%s-%05d.%s", opts.Prefix, i, opts.Format))
+
+		args := []string{
+			"-loglevel", "error",
+			"-ss", fmt.Sprintf("%.6f", time),
+			"-i", inputPath,
+			"-frames:v", "1",
+			"-an", "-sn",
+		}
+		args = append(args, opts.outputArgs()...)
+		args = append(args, "-y", outputPath)
+
+		if err := runFFmpeg(ctx, ffmpegBinary, args); err != nil {
+			return paths, fmt.Errorf("unable to extract thumbnail at %.3f: %w", time, err)
+		}
+
+		paths = append(paths, outputPath)
+	}
+
+	return paths, nil
+}
+
+type SpriteOptions struct {
+	ThumbnailOptions
+
+	Interval float64 // Seconds between sampled frames.
+	Columns  int
+	Rows     int
+}
+
+// ExtractSprites samples a frame every interval and tiles them into sprite sheets,
+// returns paths of written sprite sheets in order. Every sheet holds up to
+// columns*rows frames, last one may be partially filled. Sheets of an earlier run
+// with the same prefix are removed.
+func ExtractSprites(ctx context.Context, ffmpegBinary string, inputPath string, opts SpriteOptions) ([]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("%w: sprite interval must be positive", ErrInvalidThumbnailOptions)
+	}
+
+	if opts.Columns <= 0 || opts.Rows <= 0 {
+		return nil, fmt.Errorf("%w: sprite columns and rows must be positive", ErrInvalidThumbnailOptions)
+	}
+
+	filters := []string{fmt.Sprintf("fps=1/%.6f", opts.Interval)}
+	if opts.Width > 0 {
+		filters = append(filters, fmt.Sprintf("scale=%d:-2", opts.Width))
+	}
+	filters = append(filters, fmt.Sprintf("tile=%dx%d", opts.Columns, opts.Rows))
+
+	args := []string{
+		"-loglevel", "error",
+		"-i", inputPath,
+		"-an", "-sn",
+		"-vf", strings.Join(filters, ","),
+	}
+
+	if opts.Quality > 0 && opts.Format == "jpg" {
+		args = append(args, "-q:v", fmt.Sprintf("%d", opts.Quality))
+	}
+
+	sheetPath := func(i int) string {
+		return path.Join(opts.OutputDirPath, fmt.Sprintf("%s-%05d.%s", opts.Prefix, i, opts.Format))
+	}
+
+	// sheets of an earlier, longer run would be taken for sheets of this run
+	for i := 0; ; i++ {
+		err := os.Remove(sheetPath(i))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to remove old sprite sheet: %w", err)
+		}
+	}
+
+	// prefix is taken literally by the image2 muxer
+	outputPattern := path.Join(opts.OutputDirPath, fmt.Sprintf("%s-%%05d.%s", strings.ReplaceAll(opts.Prefix, "%", "%%"), opts.Format))
+	args = append(args, "-start_number", "0", "-y", outputPattern)
+
+	if err := runFFmpeg(ctx, ffmpegBinary, args); err != nil {
+		return nil, fmt.Errorf("unable to extract sprites: %w", err)
+	}
+
+	// sheets are numbered without gaps
+	paths := []string{}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(sheetPath(i)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return nil, err
+		}
+		paths = append(paths, sheetPath(i))
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,41 @@
+package hlsvod
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+type traceIDKey struct{}
+
+// ContextWithTraceID returns context carrying trace ID, that is used by encodes started
+// with it, unless TranscodeConfig.TraceID is set, e.g. trace ID of the incoming request.
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns trace ID carried by the context, empty if there is none.
+func TraceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// fills trace ID from the context and returns logger annotated with job and trace IDs
+func (config *TranscodeConfig) jobLogger(ctx context.Context, logger zerolog.Logger) zerolog.Logger {
+	if config.TraceID == "" {
+		config.TraceID = TraceIDFromContext(ctx)
+	}
+
+	if config.JobID == "" && config.TraceID == "" {
+		return logger
+	}
+
+	logContext := logger.With()
+	if config.JobID != "" {
+		logContext = logContext.Str("job", config.JobID)
+	}
+	if config.TraceID != "" {
+		logContext = logContext.Str("trace_id", config.TraceID)
+	}
+	return logContext.Logger()
+}
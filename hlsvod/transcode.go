@@ -4,68 +4,806 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os/exec"
+	"io"
+	"math"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils/cmdgroup"
 )
 
 type TranscodeConfig struct {
 	InputFilePath string // Transcoded video input.
-	OutputDirPath string // Segments output path.
-	SegmentPrefix string // e.g. prefix-000001.ts
-	SegmentOffset int    // Start segment number.
+	InputOptions  InputOptions
+
+	// If set, video is encoded from the image, that is shown for the whole audio of the input,
+	// video of the input is ignored. Output ends with the shorter of audio and the sequence.
+	ImageInput *ImageInput
+
+	// If set, input is read from it through ffmpeg stdin and InputFilePath is ignored. Stream
+	// cannot be probed nor seeked, so that InputOptions.Format must be given, profiles are used
+	// without knowing the source and seeking is always done by decoding (SeekOutput).
+	InputReader io.Reader
+
+	OutputDirPath   string // Segments output path.
+	CreateOutputDir bool   // Create output path if it does not exist.
+	SegmentPrefix   string // e.g. prefix-00001.ts
+	SegmentOffset   int    // Start segment number.
+	// Digits of zero-padded segment number, 5 when zero, e.g. 6 for prefix-000001.ts.
+	SegmentIndexWidth int
+	// Include start time of the segment from SegmentTimes in its name, e.g. prefix-00123-45.678.ts,
+	// for time-addressable segment stores. Finished segments are renamed, before they are
+	// delivered, so that segment muxer does not need to know the times.
+	TimestampedNames bool
+
+	// First segment starts a discontinuity, e.g. appended segments or a range encoded with
+	// different parameters than the previous segments. It is marked in the manifest, so that
+	// playlists list it after #EXT-X-DISCONTINUITY, and MPEG-TS packets are flagged as discontinuous.
+	Discontinuity bool
+
+	// Skip leading segments, that already exist in the output path and are complete,
+	// only the missing tail is encoded. Skipped segments are delivered first.
+	Resume bool
+	// What happens with segments, that already exist in the output path, e.g. from
+	// a previous run. They are overwritten by default.
+	Overwrite OverwritePolicy
+	// If set, output of a growing source is extended after the append point, segment times
+	// before it are ignored and SegmentOffset is replaced by the next segment number.
+	Append *AppendPoint
+
+	// If set, ffmpeg writes segments here and every finished segment is atomically
+	// moved to the output path, so that partially written segments are never visible.
+	StagingDirPath string
+
+	// If set, every finished segment is written to the sink and removed from the output
+	// path, that is then used only as a scratch space. Segment names are delivered on
+	// the channel only once the sink is closed.
+	SegmentSink SegmentSink
+
+	// Compare free space of the output path with estimated output size (duration
+	// times bitrates) before ffmpeg is started, ErrDiskFull is returned if it does not fit.
+	// Once the disk fills up during the encode, job fails with ErrDiskFull regardless.
+	CheckFreeSpace bool
+
+	// Parent of the job scratch directory, that is uniquely named and removed once
+	// ffmpeg exits. It is used as ffmpeg temp directory and for partial segments.
+	// System temp directory by default.
+	TempDir    string
+	scratchDir string // created for the job
+
+	// Container of the segments, MPEG-TS by default.
+	SegmentFormat SegmentFormat
+
+	// Where is seek to the first segment time placed.
+	SeekMode SeekMode
+
+	// Skip probing source video, that saves a subprocess and its possible failure, when the
+	// source is known to suit the profile, e.g. 8-bit 4:2:0 not smaller than the output.
+	// Profile is then used without knowing the source, as for InputReader, so that pixel
+	// format and H.264 profile are not selected, output can be upscaled, and video is never
+	// copied. Audio is still probed.
+	SkipVideoProbe bool
+
+	// Region of the source in media time, that is encoded. SegmentTimes are relative
+	// to TrimStart and output timestamps are rebased to start at zero. TrimEnd is
+	// optional, when set, segment times must not exceed the trimmed range.
+	TrimStart float64
+	TrimEnd   float64
+
+	// Encode only the beginning for quick check of profiles, e.g. scaling and quality, before
+	// the full encode. Segment times are truncated to the preview, so that only its segments
+	// are produced. Frames are counted in the video output, the shorter of both limits is used.
+	// Supervised encodes, e.g. StartParallel, cut segment times once, frame count is converted
+	// to duration using average frame rate then, so that it is approximate for VFR sources.
+	PreviewDuration float64
+	PreviewFrames   int
+
+	// Timestamps of the output start at zero, instead of keeping timestamps of the source.
+	// By default, -copyts keeps source timestamps, so that segment times, seeking and the end
+	// refer to the source timeline, and segments of separate encodes (appended, resumed,
+	// parallel) join seamlessly. With reset timestamps, -copyts is dropped and keyframes and
+	// cuts are placed relative to the first segment time, so that segments are still aligned
+	// within this encode, but not across encodes, since each starts at zero again. Some
+	// players handle non-zero start poorly. It cannot be used with Append.
+	ResetTimestamps bool
 
+	// How are segments cut, SegmentTimes always define start and end of the encode regardless
+	// of the strategy. Job progress, milestones and manifest durations follow cuts of the
+	// strategy, that are derived from source frame rate or profile bitrates.
+	SegmentStrategy SegmentStrategy
+	SegmentFrames   int   // Frames per segment, for SegmentByFrames.
+	SegmentSize     int64 // Approximate bytes per segment, for SegmentBySize.
+
+	// Additional times, relative to TrimStart as SegmentTimes, where keyframes are forced
+	// without cutting segments, e.g. chapter boundaries for clean chapter seeking. They are
+	// merged with segment times, that are always kept, when segmenting by time.
+	KeyframeTimes []float64
+	// Force keyframes at chapter starts of the source as well, chapters are probed.
+	KeyframesAtChapters bool
+
+	// Allow cutting segments on non-keyframes, segments then match requested
+	// times more precisely, but may not start with a keyframe.
+	BreakNonKeyframes bool
+
+	// If set, segments are split into parts not longer than this many seconds for low-latency
+	// HLS. Every part is delivered as soon as it is ready, see IsPartialSegment, and full segment
+	// is assembled from its parts and delivered after them. Parts start on non-keyframes, only
+	// the first part of a segment is independent. Supported only for MPEG-TS segmented by time.
+	PartDuration float64
+
+	// Make sure that SPS and PPS are carried before every keyframe, so that every MPEG-TS
+	// segment is decodable on its own, when players seek to it directly. Encoded video repeats
+	// them already, unless global header is requested, e.g. using ExtraOutputArgs, copied
+	// video from MP4 relies on the filter inserted by the muxer. This enforces both.
+	InBandParameterSets bool
+
+	// Segment muxer tunables, see SelfContainedSegments.
+	SegmentMuxer *SegmentMuxerOptions
+
+	// Muxer cutting segments, segment muxer by default.
+	Muxer MuxerBackend
+	// Media playlist written by HLS muxer, OutputDirPath/SegmentPrefix.m3u8 by default.
+	PlaylistPath string
+
+	// Boundaries of the segments, at least start and end of a single segment, e.g. a clip
+	// shorter than segment duration is encoded as [0, duration] into exactly one segment,
+	// that has no forced keyframes other than the first one. Times must be increasing.
 	SegmentTimes []float64
 	VideoProfile *VideoProfile
 	AudioProfile *AudioProfile
+
+	Encryption *Encryption // Encrypt segments using AES-128.
+
+	// Additional outputs encoded in the same pass, see TeeOutput for constraints.
+	TeeOutputs []TeeOutput
+
+	// What to do when AudioProfile is set, but input has no audio stream.
+	MissingAudio MissingAudio
+	// Keep language and title tags and default and forced dispositions of the source audio
+	// track, that players need to label it in audio selection. Only the first audio stream
+	// is mapped, so that it is the one preserved. Streamed input is not probed, ignored then.
+	PreserveAudioMetadata bool
+	// Shifts audio relative to video in milliseconds, to fix constant A/V offset of the source.
+	// Positive delays audio, that leads video, negative advances audio, that lags behind it.
+	// Audio is read from the source opened once again, so that it cannot be used with InputReader.
+	AudioDelay int
+
+	// Streams included in the segments, both by default. Video-only and audio-only
+	// renditions of the same segment times can be referenced by a master playlist
+	// as separate audio group, see StreamsPlaylistWithAudio.
+	Streams StreamSelection
+
+	// Global metadata of the segments, e.g. title or encoder signature. MPEG-TS keeps only
+	// service_name and service_provider (SDT), fMP4 (DASH) segments keep the MP4 tag set,
+	// e.g. title, artist, comment, copyright and encoder (replaces ffmpeg version).
+	Metadata map[string]string
+
+	// Escape hatch for flags, that are not modeled by the config. Input args are placed
+	// before -i, output args before the output. Flags managed here are rejected.
+	ExtraInputArgs  []string
+	ExtraOutputArgs []string
+
+	// Identifies the job in logs, useful when multiple encodes run concurrently.
+	JobID string
+	// Correlates the encode with the originating request across services, e.g. OpenTelemetry
+	// trace ID. It is added to log lines as trace_id, to JobStatus and to the manifest.
+	// Trace ID of the context is used when empty, see ContextWithTraceID.
+	TraceID string
+
+	// FFmpeg log level, e.g. error, warning (default), info, verbose, debug.
+	LogLevel string
+	// ffmpeg log lines containing any of these patterns, e.g. "non-monotonic DTS", are promoted
+	// to errors, so that ffmpeg is killed and the job fails with ErrPromotedWarning. Patterns
+	// are matched only in lines printed at the log level.
+	FailOnWarnings []string
+	// Complete ffmpeg stderr, including progress lines, is written to this file, e.g. for
+	// post-mortem debugging of failed encodes. The file is truncated, if it exists. Encodes
+	// running several ffmpeg processes write a file for each, suffixed before the extension,
+	// e.g. ffmpeg-0.log for the first chunk of StartParallel.
+	LogFilePath string
+
+	// Limits threads used by decoder and video encoder, encoder default when zero.
+	Threads int
+	// Limits threads used by decoder, overrides Threads for it, e.g. when decoding of high
+	// resolution source is the bottleneck. ffmpeg applies -threads to the input, when it is
+	// placed before -i, and to the encoder, when placed after it, so that they are independent.
+	DecodeThreads int
+	// Niceness of ffmpeg process from -20 (highest priority) to 19 (lowest), Unix only.
+	Nice int
+	// Pin ffmpeg process, all its threads and children to given CPU cores, Linux only.
+	CPUAffinity []int
+	// Environment variables of ffmpeg process, that are added to the environment of this
+	// process, e.g. CUDA_VISIBLE_DEVICES to pin the encode to a GPU, or FFREPORT to write
+	// detailed report of a single job. TMPDIR is set to the job scratch directory already.
+	Env map[string]string
+
+	// Called exactly once when the first segment is ready, before it is
+	// delivered on the channel, elapsed is measured since the transcode call.
+	FirstSegmentHook func(segmentName string, elapsed time.Duration)
+	// Called for every media segment before it is delivered on the channel,
+	// including segments skipped by Resume.
+	SegmentHook func(event SegmentEvent)
+	// If set, checksum of every media segment is computed once it is final (e.g. after
+	// encryption), it is passed to SegmentHook and included in the manifest.
+	Checksum ChecksumAlgorithm
+	// Called for every non-fatal issue, e.g. probe failure or variable frame
+	// rate source, so that degraded encodes can be recorded.
+	WarningHook func(warning Warning)
+	// If set, durations of produced segments are probed once ffmpeg exits, and
+	// those deviating from SegmentTimes by more than this many seconds are reported
+	// using WarningHook.
+	SegmentDurationTolerance float64
+	// If set, first frame of every produced segment is probed once ffmpeg exits, segments
+	// that do not start with a keyframe at the requested time, e.g. with copied video, are
+	// reported using WarningHook and flagged in the manifest, see Encoder.ReencodeSegments.
+	VerifyKeyframes bool
+	// How many times is a corrupt segment of parallel encode encoded again, see StartParallel.
+	// Every segment is decoded once its chunk delivers it, corrupt ones, e.g. due to transient
+	// I/O failure, are encoded again using their time window only. Zero disables the check.
+	CorruptSegmentRetries int
+	// Called for every reached milestone, e.g. halfway, they are derived from progress
+	// and finished segments, they are reached in order and at most once.
+	MilestoneHook func(event MilestoneEvent)
+	// Called once ffmpeg exits with aggregate statistics of the encode.
+	MetricsHook  func(metrics EncodeMetrics)
+	progressHook func(stats encodeStats) // called for every ffmpeg stats line
+	// segments are removed once they are delivered, e.g. by upload, so that they cannot be verified
+	segmentsRemoved bool
+	// If set, manifest describing the result is written here once the encode succeeds,
+	// it is also available using Job.Manifest.
+	ManifestPath string
+	// Called once ffmpeg exits, err is nil on success. Known failures
+	// are wrapped with typed errors, e.g. ErrInputNotFound, ErrDecodeFailed.
+	ExitHook func(err error)
+}
+
+// flags controlled by the transcoder, that must not be overridden by extra args
+var managedFlags = []string{
+	"-i", "-f", "-ss", "-to", "-t", "-copyts", "-output_ts_offset", "-avoid_negative_ts", "-y", "-n",
+	"-loglevel", "-v", "-stats", "-force_key_frames",
+	"-vf", "-filter", "-c", "-codec", "-vcodec", "-acodec",
+}
+
+func validateExtraArgs(args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		// ignore stream specifier, e.g. -c:v
+		flag := strings.SplitN(arg, ":", 2)[0]
+
+		if strings.HasPrefix(flag, "-segment_") {
+			return fmt.Errorf("%w: extra argument %s is managed by transcoder", ErrInvalidConfig, arg)
+		}
+
+		for _, managed := range managedFlags {
+			if flag == managed {
+				return fmt.Errorf("%w: extra argument %s is managed by transcoder", ErrInvalidConfig, arg)
+			}
+		}
+	}
+
+	return nil
+}
+
+var ffmpegLogLevels = []string{
+	"quiet", "panic", "fatal", "error", "warning",
+	"info", "verbose", "debug", "trace",
 }
 
 type VideoProfile struct {
 	Width   int
 	Height  int
-	Bitrate int // in kilobytes
+	Bitrate int // in kbit/s, as ffmpeg k suffix is 1000 bits
+
+	// H.264 level, e.g. 4.1 or 5.1, LevelAuto selects the lowest level supporting the output,
+	// so that small renditions are not over-constrained. Explicit level is verified to support
+	// selected profile, output resolution, frame rate and bitrate (MaxRate, or Bitrate unless
+	// CRF is used). 4.0 when empty.
+	Level string
+	// Use the lowest sufficient higher level, instead of failing when explicit level is too low.
+	BumpLevel bool
+
+	// Constant rate factor, if set, it controls quality instead of Bitrate,
+	// that is then only an estimate for playlists. Combine with MaxRate to cap peaks.
+	CRF int
+
+	// Resolution tier, if set, Width and Height are ignored and orientation
+	// follows the source aspect ratio, e.g. 720p is 1280x720 or 720x1280.
+	Resolution Resolution
+
+	// Constrained VBR, caps bitrate peaks so that players can estimate bandwidth.
+	MaxRate int // in kbit/s
+	BufSize int // in kbit/s, defaults to twice the MaxRate
+	// By default, output is never larger than the source.
+	AllowUpscale bool
+	// How is source fitted into Width and Height (or Resolution tier), when
+	// cropping or padding, output has always exact size regardless of AllowUpscale,
+	// see AspectExact for exact size without upscaling.
+	AspectMode AspectMode
+	// Scaling algorithm, e.g. lanczos for detail or bilinear for speed, bicubic when empty.
+	ScaleFlags string
+	// x264 preset trading speed for quality, faster when empty. Preset meeting a deadline
+	// can be chosen using Encoder.CalibratePreset.
+	Preset string
+
+	// Encode using baseline profile for legacy devices, this
+	// disables B-frames and CABAC, output is always 4:2:0.
+	Baseline bool
+	BFrames  *int // Maximum consecutive B-frames, encoder default when nil.
+	Refs     int  // Maximum reference frames, encoder default when zero.
+
+	// Disallow open GOPs, so that every segment starts with an IDR frame and can be decoded
+	// without the previous one. This costs slightly more bitrate, since frames at GOP
+	// boundary cannot reference the previous GOP.
+	ClosedGOP bool
+
+	// Encode every frame as a keyframe, e.g. for editing proxies or fast-seek intermediates,
+	// that can be scrubbed without decoding previous frames. Intra-only frames cannot use
+	// temporal prediction, so that bitrate needed for the same quality is several times
+	// higher, combine it with lower resolution or CRF to keep the size reasonable.
+	IntraOnly bool
+
+	// Duplicate or drop frames to produce constant frame rate output,
+	// prevents segment durations from drifting on variable frame rate sources.
+	ConstantFrameRate bool
+
+	// Output color tags, source tags are kept by default and full
+	// range sources (e.g. yuvj420p) are converted to limited range.
+	// Color space (matrix) overrides the one selected by output size.
+	Color *ColorTags
+	// Source color matrix is kept, even if the output crosses SD/HD boundary.
+	// By default, SD output uses bt601 and HD output bt709, as players assume
+	// when the matrix is not tagged, and pixels are converted when it changes.
+	KeepColorMatrix bool
+
+	// Image burned into the video, e.g. logo.
+	Overlay *Overlay
+
+	// Round odd Width and Height down to even numbers, instead of failing, when output
+	// has subsampled chroma (e.g. 4:2:0), that requires even dimensions. One row or column
+	// is lost, or cropped, when the source is scaled to the exact size.
+	RoundOddDimensions bool
+
+	// Copy source video instead of encoding it, if it is 8-bit 4:2:0 H.264 at or below
+	// Bitrate (MaxRate, if set), that fits into the profile size and needs neither scaling,
+	// range nor color matrix conversion, e.g. a phone video, that is only rotated. Keyframes
+	// cannot be forced, so that segment times must be at source keyframes. Rotation is kept
+	// as display matrix, that only fMP4 segments can carry, rotated sources are therefore
+	// encoded for MPEG-TS, so that their pixels are rotated. Video is encoded when source
+	// cannot be probed.
+	CopyCompatible bool
 }
 
 type AudioProfile struct {
-	Bitrate int // in kilobytes
+	Bitrate    int // in kbit/s
+	SampleRate int // in Hz, source sample rate is kept when zero
+	Channels   int // source channel count is kept when zero
+
+	// AAC encoder, native aac (default) or libfdk_aac, that has better quality
+	// at low bitrates, but is not shipped with all ffmpeg builds.
+	Encoder string
+	// libfdk_aac VBR mode from 1 (lowest) to 5 (highest quality), if set, Bitrate
+	// is used only as an estimate for playlists. Constant bitrate when zero.
+	VBR int
+	// AAC profile, LC by default. HE profiles require libfdk_aac and are meant for
+	// low bitrates, e.g. 32k mobile renditions, HEv2 requires stereo.
+	Profile AACProfile
+
+	// Copy source audio instead of encoding it, if it is AAC at or below Bitrate and
+	// matches SampleRate and Channels (when set), so that it does not lose quality by
+	// encoding twice. Audio is encoded when source cannot be probed.
+	CopyCompatible bool
+
+	// Bitstream filters applied to output audio packets in order, see audioBitstreamFilters,
+	// filter options can follow the name, e.g. setts=ts=PTS-STARTPTS.
+	BitstreamFilters []string
+}
+
+// audio bitstream filters, that are meaningful for AAC output
+var audioBitstreamFilters = map[string]bool{
+	// Strips ADTS headers of copied AAC, e.g. from MPEG-TS source, that are not allowed
+	// in fMP4 segments. Packets without ADTS headers are passed as they are.
+	"aac_adtstoasc": true,
+	// Rewrites packet timestamps using expressions, e.g. to fix broken source timestamps
+	// of copied audio.
+	"setts": true,
+}
+
+// returns whether source audio can be copied instead of encoded
+func (profile *AudioProfile) canCopy(info *AudioInfo) bool {
+	if !profile.CopyCompatible || info == nil || info.CodecName != "aac" {
+		return false
+	}
+
+	// bitrate is unknown for some containers, e.g. mkv
+	bitRate, err := strconv.Atoi(info.BitRate)
+	if err != nil || bitRate <= 0 || bitRate > profile.Bitrate*1000 {
+		return false
+	}
+
+	if profile.SampleRate != 0 && fmt.Sprintf("%d", profile.SampleRate) != info.SampleRate {
+		return false
+	}
+
+	if profile.Channels != 0 && profile.Channels != info.Channels {
+		return false
+	}
+
+	return true
+}
+
+// returns whether source video can be copied instead of encoded
+func (config *TranscodeConfig) canCopyVideo(info *VideoInfo) bool {
+	profile := config.VideoProfile
+	if profile == nil || !profile.CopyCompatible || info == nil || info.CodecName != "h264" {
+		return false
+	}
+
+	// frames would be changed by filters or encoder settings
+	if profile.Overlay != nil || profile.Color != nil || profile.Baseline || profile.IntraOnly || profile.ConstantFrameRate {
+		return false
+	}
+
+	// keyframes are forced by frame count, splitting at time can use source keyframes
+	if config.SegmentStrategy == SegmentByFrames {
+		return false
+	}
+
+	if info.PixelFormat != "yuv420p" || isFullRange(info) || info.isAnamorphic() {
+		return false
+	}
+
+	// bitrate is unknown for some containers, e.g. mkv
+	maxRate := profile.Bitrate
+	if profile.MaxRate > 0 {
+		maxRate = profile.MaxRate
+	}
+	bitRate, err := strconv.Atoi(info.BitRate)
+	if err != nil || bitRate <= 0 || bitRate > maxRate*1000 {
+		return false
+	}
+
+	// source must be kept as is, i.e. fit into the output, that is never upscaled
+	sourceWidth, sourceHeight := info.displaySize()
+	width, height := frameSize(profile, info)
+	if profile.AspectMode != AspectFit || sourceWidth > width || sourceHeight > height {
+		return false
+	}
+
+	if in, out := colorMatrixConversion(profile, info); in != out {
+		return false
+	}
+
+	// MPEG-TS cannot carry display matrix
+	if info.rotation() != 0 && config.SegmentFormat != SegmentFormatDASH {
+		return false
+	}
+
+	return true
+}
+
+// AACProfile is an audio object type passed to the encoder as -profile:a.
+type AACProfile string
+
+const (
+	AACLowComplexity AACProfile = ""
+	AACHE            AACProfile = "aac_he"
+	AACHEv2          AACProfile = "aac_he_v2"
+)
+
+// returns AAC encoder name
+func (profile *AudioProfile) encoder() string {
+	if profile.Encoder == "" {
+		return "aac"
+	}
+	return profile.Encoder
+}
+
+// sample rates supported by AAC encoders
+var aacSampleRates = []int{
+	8000, 11025, 12000, 16000, 22050, 24000,
+	32000, 44100, 48000, 64000, 88200, 96000,
+}
+
+// AAC channel configurations go up to 7.1
+const aacMaxChannels = 8
+
+func (profile *AudioProfile) validate() error {
+	if profile.SampleRate != 0 {
+		supported := false
+		for _, sampleRate := range aacSampleRates {
+			if profile.SampleRate == sampleRate {
+				supported = true
+				break
+			}
+		}
+
+		if !supported {
+			return fmt.Errorf("%w: audio sample rate %d is not supported by AAC", ErrInvalidAudioProfile, profile.SampleRate)
+		}
+	}
+
+	if profile.Channels < 0 || profile.Channels > aacMaxChannels {
+		return fmt.Errorf("%w: audio channels %d is not supported by AAC", ErrInvalidAudioProfile, profile.Channels)
+	}
+
+	if encoder := profile.encoder(); encoder != "aac" && encoder != "libfdk_aac" {
+		return fmt.Errorf("%w: unsupported audio encoder %q", ErrInvalidAudioProfile, encoder)
+	}
+
+	if profile.VBR < 0 || profile.VBR > 5 {
+		return fmt.Errorf("%w: audio VBR mode must be between 1 and 5", ErrInvalidAudioProfile)
+	}
+
+	for _, filter := range profile.BitstreamFilters {
+		name := strings.SplitN(filter, "=", 2)[0]
+		if !audioBitstreamFilters[name] {
+			return fmt.Errorf("%w: unsupported audio bitstream filter %q", ErrInvalidAudioProfile, name)
+		}
+
+		// filters are separated by comma
+		if strings.Contains(filter, ",") {
+			return fmt.Errorf("%w: audio bitstream filter %q must not contain comma", ErrInvalidAudioProfile, filter)
+		}
+	}
+
+	if profile.VBR > 0 && profile.encoder() != "libfdk_aac" {
+		return fmt.Errorf("%w: audio VBR mode requires libfdk_aac encoder", ErrInvalidAudioProfile)
+	}
+
+	switch profile.Profile {
+	case AACLowComplexity:
+	case AACHE, AACHEv2:
+		// native encoder implements only LC
+		if profile.encoder() != "libfdk_aac" {
+			return fmt.Errorf("%w: audio profile %s requires libfdk_aac encoder", ErrInvalidAudioProfile, profile.Profile)
+		}
+	default:
+		return fmt.Errorf("%w: unknown audio profile %q", ErrInvalidAudioProfile, profile.Profile)
+	}
+
+	// parametric stereo is coded from two channels
+	if profile.Profile == AACHEv2 && profile.Channels != 2 {
+		return fmt.Errorf("%w: audio profile %s requires 2 channels", ErrInvalidAudioProfile, profile.Profile)
+	}
+
+	return nil
+}
+
+type StreamSelection int
+
+const (
+	// Both video and audio are encoded, if their profiles are set.
+	StreamsAll StreamSelection = iota
+	// Audio is omitted (-an), even if audio profile is set.
+	StreamsVideoOnly
+	// Video is omitted (-vn), even if video profile is set.
+	StreamsAudioOnly
+)
+
+// clears profile of omitted stream, so that it is neither probed nor encoded
+func (config *TranscodeConfig) selectStreams() {
+	switch config.Streams {
+	case StreamsVideoOnly:
+		config.AudioProfile = nil
+	case StreamsAudioOnly:
+		config.VideoProfile = nil
+	}
+}
+
+type SeekMode int
+
+const (
+	// Seek placed before input (default). Demuxer jumps to the keyframe preceding
+	// the start and, since ffmpeg uses accurate seek when transcoding, frames up
+	// to the start are decoded and dropped. Fast, and precise for seekable inputs.
+	SeekInput SeekMode = iota
+	// Seek placed after input. Input is decoded from the very beginning and frames
+	// before the start are dropped. Slow for later windows, but does not depend
+	// on demuxer seeking, that can be imprecise for some containers or broken indexes.
+	SeekOutput
+	// Two-stage seek for frame-exact start. Seek placed before input jumps near the start,
+	// seekAccuratePreroll earlier, and seek placed after input drops decoded frames up to
+	// the exact start. Almost as fast as SeekInput, but keeps exact start even when demuxer
+	// lands past the preceding keyframe.
+	SeekAccurate
+)
+
+// how much earlier than the start is the input seeked by SeekAccurate
+const seekAccuratePreroll = 10.0
+
+type MissingAudio int
+
+const (
+	// Encode without audio.
+	MissingAudioSkip MissingAudio = iota
+	// Add silent audio track, so that all renditions have the same stream layout.
+	MissingAudioSilence
+)
+
+// results of input probing, nil values are unknown
+type inputInfo struct {
+	Video   *VideoInfo
+	Audio   *AudioInfo // first audio stream
+	NoAudio bool       // input was detected to have no audio stream
+}
+
+type AudioInfo struct {
+	CodecName  string `json:"codec_name"`
+	BitRate    string `json:"bit_rate"`
+	SampleRate string `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+
+	Tags struct {
+		Language string `json:"language"` // ISO 639-2 code, e.g. eng.
+		Title    string `json:"title"`
+	} `json:"tags"`
+	Disposition struct {
+		Default int `json:"default"`
+		Forced  int `json:"forced"`
+	} `json:"disposition"`
+}
+
+// returns arguments, that set tags and dispositions of the output audio stream to those of the
+// source, dispositions are always set, so that they are not guessed by the muxer
+func (info *AudioInfo) metadataArgs() []string {
+	args := []string{}
+	if info.Tags.Language != "" {
+		args = append(args, "-metadata:s:a:0", "language="+info.Tags.Language)
+	}
+	if info.Tags.Title != "" {
+		args = append(args, "-metadata:s:a:0", "title="+info.Tags.Title)
+	}
+
+	dispositions := []string{}
+	if info.Disposition.Default != 0 {
+		dispositions = append(dispositions, "default")
+	}
+	if info.Disposition.Forced != 0 {
+		dispositions = append(dispositions, "forced")
+	}
+
+	disposition := "0"
+	if len(dispositions) > 0 {
+		disposition = strings.Join(dispositions, "+")
+	}
+
+	return append(args, "-disposition:a:0", disposition)
 }
 
 type VideoInfo struct {
-	PixelFormat string `json:"pix_fmt"`
+	Index        int    `json:"index"` // Stream index in the input, it is the one encoded.
+	CodecName    string `json:"codec_name"`
+	BitRate      string `json:"bit_rate"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	PixelFormat  string `json:"pix_fmt"`
+	RFrameRate   string `json:"r_frame_rate"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+
+	SampleAspectRatio  string `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string `json:"display_aspect_ratio"`
+
+	// Display matrix rotation in degrees, ffmpeg rotates frames before filtering.
+	SideDataList []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+	Tags struct {
+		Rotate string `json:"rotate"` // legacy rotation metadata
+	} `json:"tags"`
+
+	// Attached picture, e.g. cover art of audio file, is not a real video stream.
+	Disposition struct {
+		Default     int `json:"default"`
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+
+	ColorRange     string `json:"color_range"`
+	ColorSpace     string `json:"color_space"`
+	ColorTransfer  string `json:"color_transfer"`
+	ColorPrimaries string `json:"color_primaries"`
+}
+
+// variable frame rate streams have their average frame rate differ from real base frame rate
+func (info *VideoInfo) IsVariableFrameRate() bool {
+	return isVariableFrameRate(info.RFrameRate, info.AvgFrameRate)
 }
 
 type FFProbeOutput struct {
 	Streams []VideoInfo `json:"streams"`
 }
 
-func detectVideoFormat(ctx context.Context, ffprobeBinary string, inputPath string) (string, error) {
-	args := []string{
+func detectVideoFormat(ctx context.Context, ffprobeBinary string, inputPath string, inputOptions InputOptions) (*VideoInfo, error) {
+	args := append(inputOptions.args(), []string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_streams",
-		"-select_streams", "v:0",
+		"-select_streams", "v",
 		inputPath,
-	}
+	}...)
 
-	cmd := exec.CommandContext(ctx, ffprobeBinary, args...)
-	output, err := cmd.Output()
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
 	if err != nil {
-		return "", fmt.Errorf("failed to run ffprobe: %w", err)
+		return nil, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
 	}
 
 	var probeOutput FFProbeOutput
 	if err := json.Unmarshal(output, &probeOutput); err != nil {
-		return "", fmt.Errorf("failed to parse ffprobe output: %w", err)
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	principal := principalVideoStream(probeOutput.Streams)
+	if principal == nil {
+		return nil, ErrNoVideoStream
+	}
+
+	return principal, nil
+}
+
+// Returns the main video stream, that is not necessarily the first one, e.g. in multi-program
+// MPEG-TS or MKV with preview streams. Stream marked as default wins, then the one with
+// the highest resolution, the first one on tie. Attached pictures are reported as single
+// frame video streams, they are never chosen.
+func principalVideoStream(streams []VideoInfo) *VideoInfo {
+	var principal *VideoInfo
+	for i := range streams {
+		stream := &streams[i]
+		if stream.Disposition.AttachedPic != 0 {
+			continue
+		}
+
+		if principal == nil {
+			principal = stream
+			continue
+		}
+
+		if stream.Disposition.Default != principal.Disposition.Default {
+			if stream.Disposition.Default != 0 {
+				principal = stream
+			}
+			continue
+		}
+
+		if stream.Width*stream.Height > principal.Width*principal.Height {
+			principal = stream
+		}
+	}
+
+	return principal
+}
+
+func detectAudioStreams(ctx context.Context, ffprobeBinary string, inputPath string, inputOptions InputOptions) ([]AudioInfo, error) {
+	args := append(inputOptions.args(), []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_entries", "stream=index,codec_name,bit_rate,sample_rate,channels:stream_tags=language,title:stream_disposition=default,forced",
+		"-select_streams", "a",
+		inputPath,
+	}...)
+
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
 	}
 
-	if len(probeOutput.Streams) == 0 {
-		return "", fmt.Errorf("no video streams found")
+	var probeOutput struct {
+		Streams []AudioInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probeOutput); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
 	}
 
-	return probeOutput.Streams[0].PixelFormat, nil
+	return probeOutput.Streams, nil
 }
 
 func is422Format(pixelFormat string) bool {
@@ -115,182 +853,1759 @@ func is422Format(pixelFormat string) bool {
 	return false
 }
 
-// returns a channel, that delivers name of the segments as they are encoded
-func TranscodeSegments(ctx context.Context, ffmpegBinary string, config TranscodeConfig) (chan string, error) {
-	totalSegments := len(config.SegmentTimes)
-	if totalSegments < 2 {
-		return nil, fmt.Errorf("minimum 2 segment times needed")
+func (profile *VideoProfile) validate() error {
+	if profile.Resolution < 0 {
+		return fmt.Errorf("%w: video resolution must not be negative", ErrInvalidVideoProfile)
 	}
 
-	// set time bountary
-	var startAt, endAt float64
-	if totalSegments > 0 {
-		startAt = config.SegmentTimes[0]
-		endAt = config.SegmentTimes[totalSegments-1]
+	if profile.Resolution == 0 && (profile.Width <= 0 || profile.Height <= 0) {
+		return fmt.Errorf("%w: video width and height must be positive", ErrInvalidVideoProfile)
 	}
 
-	// convet to comma separated segment times
-	fmtSegTimes := []string{}
-	for _, segmentTime := range config.SegmentTimes {
-		fmtSegTimes = append(
-			fmtSegTimes,
-			fmt.Sprintf("%.6f", segmentTime),
-		)
+	if profile.AspectMode < AspectFit || profile.AspectMode > AspectExact {
+		return fmt.Errorf("%w: unknown aspect mode %d", ErrInvalidVideoProfile, profile.AspectMode)
 	}
-	commaSeparatedSegTimes := strings.Join(fmtSegTimes[1:], ",")
 
-	args := []string{
-		"-loglevel", "warning",
+	if err := validateLevel(profile.Level); err != nil {
+		return err
 	}
 
-	// Seek to start point. Note there is a bug(?) in ffmpeg: https://github.com/FFmpeg/FFmpeg/blob/fe964d80fec17f043763405f5804f397279d6b27/fftools/ffmpeg_opt.c#L1240
-	// can possible set `seek_timestamp` to a negative value, which will cause `avformat_seek_file` to reject the input timestamp.
-	// To prevent this, the first break point, which we know will be zero, will not be fed to `-ss`.
-	if startAt > 0 {
-		args = append(args, []string{
-			"-ss", fmt.Sprintf("%.6f", startAt),
-		}...)
+	if profile.CRF < 0 || profile.CRF > 51 {
+		return fmt.Errorf("%w: video CRF must be between 0 and 51", ErrInvalidVideoProfile)
 	}
 
-	// Input specs
-	args = append(args, []string{
-		"-i", config.InputFilePath, // Input file
-		"-to", fmt.Sprintf("%.6f", endAt),
-		"-copyts", // So the "-to" refers to the original TS
-		"-force_key_frames", commaSeparatedSegTimes,
-		"-sn", // No subtitles
-	}...)
-
-	// Detect video format to determine appropriate profile
-	var useHigh422Profile bool
-	if config.VideoProfile != nil {
-		ffprobeBinary := strings.Replace(ffmpegBinary, "ffmpeg", "ffprobe", 1)
-		pixelFormat, err := detectVideoFormat(ctx, ffprobeBinary, config.InputFilePath)
-		if err != nil {
-			log.Printf("Warning: Could not detect video format, using default profile: %v", err)
-		} else {
-			log.Printf("Detected pixel format: %s", pixelFormat)
-			useHigh422Profile = is422Format(pixelFormat)
-			if useHigh422Profile {
-				log.Printf("Detected 4:2:2 format (%s), using high422 profile", pixelFormat)
-			} else {
-				log.Printf("Using default profile for format: %s", pixelFormat)
-			}
+	if profile.BFrames != nil {
+		if *profile.BFrames < 0 || *profile.BFrames > 16 {
+			return fmt.Errorf("%w: video B-frames must be between 0 and 16", ErrInvalidVideoProfile)
 		}
-	}
-
-	// Video specs
-	if config.VideoProfile != nil {
-		profile := config.VideoProfile
 
-		var scale string
-		if profile.Width >= profile.Height {
-			scale = fmt.Sprintf("scale=-2:%d", profile.Height)
-		} else {
-			scale = fmt.Sprintf("scale=%d:-2", profile.Width)
+		if profile.Baseline && *profile.BFrames > 0 {
+			return fmt.Errorf("%w: video baseline profile does not support B-frames", ErrInvalidVideoProfile)
 		}
 
-		videoProfile := "high"
-		if useHigh422Profile {
-			videoProfile = "high422"
+		if profile.IntraOnly && *profile.BFrames > 0 {
+			return fmt.Errorf("%w: video intra-only encode does not support B-frames", ErrInvalidVideoProfile)
 		}
-
-		args = append(args, []string{
-			"-vf", scale,
-			"-c:v", "libx264",
-			"-preset", "faster",
-			"-profile:v", videoProfile,
-			"-level:v", "4.0",
-			"-b:v", fmt.Sprintf("%dk", profile.Bitrate),
-		}...)
 	}
 
-	// Audio specs
-	if config.AudioProfile != nil {
-		profile := config.AudioProfile
+	if profile.MaxRate < 0 || profile.BufSize < 0 {
+		return fmt.Errorf("%w: video max rate and buffer size must not be negative", ErrInvalidVideoProfile)
+	}
 
-		args = append(args, []string{
-			"-c:a", "aac",
-			"-b:a", fmt.Sprintf("%dk", profile.Bitrate),
-		}...)
+	if profile.MaxRate > 0 && profile.MaxRate < profile.Bitrate {
+		return fmt.Errorf("%w: video max rate must not be lower than bitrate", ErrInvalidVideoProfile)
 	}
 
-	// Segmenting specs
-	args = append(args, []string{
-		"-f", "segment",
-		"-segment_time_delta", "0.2",
-		"-segment_format", "mpegts",
-		"-segment_times", commaSeparatedSegTimes,
-		"-segment_start_number", fmt.Sprintf("%d", config.SegmentOffset),
-		"-segment_list_type", "flat",
-		"-segment_list", "pipe:1", // Output completed segments to stdout.
-		path.Join(config.OutputDirPath, fmt.Sprintf("%s-%%05d.ts", config.SegmentPrefix)),
-	}...)
+	if profile.BufSize > 0 && profile.MaxRate == 0 {
+		return fmt.Errorf("%w: video buffer size requires max rate", ErrInvalidVideoProfile)
+	}
 
-	cmd := exec.CommandContext(ctx, ffmpegBinary, args...)
-	log.Println("Starting FFmpeg process with args", strings.Join(cmd.Args[:], " "))
+	if profile.Refs < 0 || profile.Refs > 16 {
+		return fmt.Errorf("%w: video reference frames must be between 1 and 16", ErrInvalidVideoProfile)
+	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	if profile.ScaleFlags != "" && !isScaleFlag(profile.ScaleFlags) {
+		return fmt.Errorf("%w: unknown scale flags %q", ErrInvalidVideoProfile, profile.ScaleFlags)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+	if profile.Preset != "" && !isX264Preset(profile.Preset) {
+		return fmt.Errorf("%w: unknown preset %q", ErrInvalidVideoProfile, profile.Preset)
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
+	if profile.Color != nil {
+		if err := profile.Color.validate(); err != nil {
+			return err
+		}
+	}
 
-	segments := make(chan string, 1)
+	if profile.Overlay != nil {
+		if err := profile.Overlay.validate(); err != nil {
+			return err
+		}
+	}
 
-	// handle stdout
-	go func() {
-		defer func() {
-			wg.Wait()
+	return nil
+}
 
-			close(segments)
-		}()
+func (config *TranscodeConfig) validate() error {
+	if len(config.SegmentTimes) < 2 {
+		return fmt.Errorf("%w: got %d", ErrTooFewSegmentTimes, len(config.SegmentTimes))
+	}
 
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			segments <- scanner.Text()
+	// empty segment would not be produced at all, so that segments would be misnumbered
+	for i := 1; i < len(config.SegmentTimes); i++ {
+		if config.SegmentTimes[i] <= config.SegmentTimes[i-1] {
+			return fmt.Errorf("%w: segment times must be increasing, got %.6f after %.6f", ErrInvalidConfig, config.SegmentTimes[i], config.SegmentTimes[i-1])
 		}
+	}
 
-		if err := scanner.Err(); err != nil {
-			log.Println("Error while reading FFmpeg stdout:", err)
+	switch config.Streams {
+	case StreamsAll:
+	case StreamsVideoOnly:
+		if config.VideoProfile == nil {
+			return fmt.Errorf("%w: video profile must be set for video-only segments", ErrInvalidConfig)
 		}
-	}()
-
+	case StreamsAudioOnly:
+		if config.AudioProfile == nil {
+			return fmt.Errorf("%w: audio profile must be set for audio-only segments", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown stream selection %d", ErrInvalidConfig, config.Streams)
+	}
+
+	if config.SegmentIndexWidth < 0 || config.SegmentIndexWidth > 10 {
+		return fmt.Errorf("%w: segment index width must be between 1 and 10", ErrInvalidConfig)
+	}
+
+	if config.VideoProfile != nil {
+		if err := config.VideoProfile.validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.AudioProfile != nil {
+		if err := config.AudioProfile.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := config.validateSegmentStrategy(); err != nil {
+		return err
+	}
+
+	if err := config.validateMuxer(); err != nil {
+		return err
+	}
+
+	if err := config.validateParts(); err != nil {
+		return err
+	}
+
+	if config.Encryption != nil {
+		if err := config.Encryption.validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.SegmentFormat != SegmentFormatMPEGTS && config.SegmentFormat != SegmentFormatDASH {
+		return fmt.Errorf("%w: unknown segment format %d", ErrInvalidConfig, config.SegmentFormat)
+	}
+
+	if config.SegmentFormat == SegmentFormatDASH && config.Encryption != nil {
+		return fmt.Errorf("%w: encryption is supported only for MPEG-TS segments", ErrInvalidConfig)
+	}
+
+	// media segments without init segment cannot be verified
+	if config.SegmentFormat == SegmentFormatDASH && config.Resume {
+		return fmt.Errorf("%w: resume is supported only for MPEG-TS segments", ErrInvalidConfig)
+	}
+
+	if config.SegmentSink != nil {
+		// complete segments are not left in the output path
+		if config.Resume {
+			return fmt.Errorf("%w: resume cannot be used with segment sink", ErrInvalidConfig)
+		}
+
+		// parts are needed to assemble the segment
+		if config.PartDuration > 0 {
+			return fmt.Errorf("%w: partial segments cannot be used with segment sink", ErrInvalidConfig)
+		}
+	}
+
+	if config.Append != nil {
+		if err := config.Append.validate(config); err != nil {
+			return err
+		}
+	}
+
+	// fMP4 carries parameter sets in the init segment
+	if config.InBandParameterSets && config.SegmentFormat != SegmentFormatMPEGTS {
+		return fmt.Errorf("%w: in-band parameter sets are supported only for MPEG-TS segments", ErrInvalidConfig)
+	}
+
+	if config.TimestampedNames {
+		// complete segments are looked up by their numbers only
+		if config.Resume {
+			return fmt.Errorf("%w: resume cannot be used with timestamped names", ErrInvalidConfig)
+		}
+
+		if config.Muxer != MuxerSegment {
+			return fmt.Errorf("%w: timestamped names are supported only with segment muxer", ErrInvalidConfig)
+		}
+
+		// other strategies do not cut at segment times
+		if config.SegmentStrategy != SegmentByTime {
+			return fmt.Errorf("%w: timestamped names are supported only when segmenting by time", ErrInvalidConfig)
+		}
+	}
+
+	if config.Overwrite < OverwriteAlways || config.Overwrite > OverwriteError {
+		return fmt.Errorf("%w: unknown overwrite policy %d", ErrInvalidConfig, config.Overwrite)
+	}
+
+	if config.Resume && len(config.TeeOutputs) > 0 {
+		return fmt.Errorf("%w: resume cannot be used with tee outputs", ErrInvalidConfig)
+	}
+
+	// complete segments are matched with segment times
+	if config.Resume && config.SegmentStrategy != SegmentByTime {
+		return fmt.Errorf("%w: resume is supported only when segmenting by time", ErrInvalidConfig)
+	}
+
+	for i := range config.TeeOutputs {
+		if err := config.TeeOutputs[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := config.InputOptions.validate(); err != nil {
+		return err
+	}
+
+	if err := config.Checksum.validate(); err != nil {
+		return err
+	}
+
+	if config.InputReader != nil && config.InputOptions.Format == "" {
+		return fmt.Errorf("%w: input format must be set when reading from stream", ErrInvalidConfig)
+	}
+
+	if config.ImageInput != nil {
+		if err := config.ImageInput.validate(config); err != nil {
+			return err
+		}
+	}
+
+	// stream can be read only once, audio is read from the source opened again
+	if config.InputReader != nil && config.AudioDelay != 0 {
+		return fmt.Errorf("%w: audio delay cannot be used when reading from stream", ErrInvalidConfig)
+	}
+
+	if err := validateExtraArgs(config.ExtraInputArgs); err != nil {
+		return err
+	}
+
+	if err := validateExtraArgs(config.ExtraOutputArgs); err != nil {
+		return err
+	}
+
+	for key := range config.Metadata {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("%w: invalid metadata key %q", ErrInvalidConfig, key)
+		}
+	}
+
+	for key := range config.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("%w: invalid environment variable %q", ErrInvalidConfig, key)
+		}
+	}
+
+	if config.SeekMode < SeekInput || config.SeekMode > SeekAccurate {
+		return fmt.Errorf("%w: unknown seek mode %d", ErrInvalidConfig, config.SeekMode)
+	}
+
+	if err := config.validatePreview(); err != nil {
+		return err
+	}
+
+	if config.TrimStart < 0 {
+		return fmt.Errorf("%w: trim start must not be negative", ErrInvalidConfig)
+	}
+
+	if config.TrimEnd != 0 {
+		if config.TrimEnd <= config.TrimStart {
+			return fmt.Errorf("%w: trim end must be after trim start", ErrInvalidConfig)
+		}
+
+		if last := config.SegmentTimes[len(config.SegmentTimes)-1]; last > config.TrimEnd-config.TrimStart {
+			return fmt.Errorf("%w: segment time %.3f is beyond trimmed range", ErrInvalidConfig, last)
+		}
+	}
+
+	if config.Threads < 0 || config.DecodeThreads < 0 {
+		return fmt.Errorf("%w: threads must not be negative", ErrInvalidConfig)
+	}
+
+	if config.SegmentDurationTolerance < 0 {
+		return fmt.Errorf("%w: segment duration tolerance must not be negative", ErrInvalidConfig)
+	}
+
+	for _, cpu := range config.CPUAffinity {
+		if cpu < 0 {
+			return fmt.Errorf("%w: CPU affinity must not contain negative cores", ErrInvalidConfig)
+		}
+	}
+
+	if config.Nice < -20 || config.Nice > 19 {
+		return fmt.Errorf("%w: nice must be between -20 and 19", ErrInvalidConfig)
+	}
+
+	if config.LogLevel != "" {
+		supported := false
+		for _, logLevel := range ffmpegLogLevels {
+			if config.LogLevel == logLevel {
+				supported = true
+				break
+			}
+		}
+
+		if !supported {
+			return fmt.Errorf("%w: unsupported ffmpeg log level %q", ErrInvalidConfig, config.LogLevel)
+		}
+	}
+
+	return nil
+}
+
+func (config *TranscodeConfig) ensureOutputDir() error {
+	if config.OutputDirPath == "" {
+		return nil
+	}
+
+	stat, err := os.Stat(config.OutputDirPath)
+	if err == nil {
+		if !stat.IsDir() {
+			return fmt.Errorf("%w: %s is not a directory", ErrOutputDirMissing, config.OutputDirPath)
+		}
+		return nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if !config.CreateOutputDir {
+		return fmt.Errorf("%w: %s", ErrOutputDirMissing, config.OutputDirPath)
+	}
+
+	return os.MkdirAll(config.OutputDirPath, 0755)
+}
+
+// returns input passed to ffmpeg
+func (config *TranscodeConfig) inputPath() string {
+	if config.InputReader != nil {
+		return "pipe:0"
+	}
+	return config.InputFilePath
+}
+
+// creates unique job scratch directory, it must be removed using removeScratchDir
+func (config *TranscodeConfig) createScratchDir() error {
+	tempDir := config.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+
+	prefix := "hlsvod-"
+	if config.JobID != "" {
+		prefix += config.JobID + "-"
+	}
+
+	scratchDir, err := os.MkdirTemp(tempDir, prefix)
+	if err != nil {
+		return fmt.Errorf("unable to create scratch directory: %w", err)
+	}
+
+	config.scratchDir = scratchDir
+	return nil
+}
+
+func (config *TranscodeConfig) removeScratchDir() error {
+	if config.scratchDir == "" {
+		return nil
+	}
+	return os.RemoveAll(config.scratchDir)
+}
+
+// returns directory, where ffmpeg writes intermediate files
+func (config *TranscodeConfig) scratchDirPath() string {
+	if config.scratchDir != "" {
+		return config.scratchDir
+	}
+	return config.writeDirPath()
+}
+
+// returns log file path of another ffmpeg process of the same encode, e.g. parallel chunk,
+// so that processes neither truncate nor interleave logs of each other
+func (config *TranscodeConfig) derivedLogFilePath(suffix string) string {
+	if config.LogFilePath == "" {
+		return ""
+	}
+
+	ext := path.Ext(config.LogFilePath)
+	return strings.TrimSuffix(config.LogFilePath, ext) + "-" + suffix + ext
+}
+
+// returns directory, where ffmpeg writes segments
+func (config *TranscodeConfig) writeDirPath() string {
+	if config.StagingDirPath != "" {
+		return config.StagingDirPath
+	}
+	return config.OutputDirPath
+}
+
+// returns segment times in source media time
+func (config *TranscodeConfig) sourceSegmentTimes() []float64 {
+	segmentTimes := make([]float64, len(config.SegmentTimes))
+	for i, segmentTime := range config.SegmentTimes {
+		segmentTimes[i] = config.TrimStart + segmentTime
+	}
+	return segmentTimes
+}
+
+// returns times shifted so that the first one is zero
+func rebaseTimes(times []float64) []float64 {
+	rebased := make([]float64, len(times))
+	for i, t := range times {
+		rebased[i] = t - times[0]
+	}
+	return rebased
+}
+
+// returns segment times in output timestamps, as seen by the segment muxer and in progress
+func (config *TranscodeConfig) outputSegmentTimes() []float64 {
+	if config.ResetTimestamps {
+		return rebaseTimes(config.SegmentTimes)
+	}
+	return config.SegmentTimes
+}
+
+func buildArgs(config TranscodeConfig, input inputInfo) ([]string, error) {
+	videoInfo := input.Video
+
+	// seeking and forcing keyframes refer to the source, with -copyts
+	// only the segment muxer sees rebased timestamps
+	if config.TrimStart > 0 {
+		config.SegmentTimes = config.sourceSegmentTimes()
+	}
+
+	totalSegments := len(config.SegmentTimes)
+
+	// set time bountary
+	var startAt, endAt float64
+	if totalSegments > 0 {
+		startAt = config.SegmentTimes[0]
+		endAt = config.SegmentTimes[totalSegments-1]
+	}
+
+	// keyframes are forced and segments cut at output timestamps
+	segmentConfig := config
+	segmentStart, segmentEnd := startAt, endAt
+	if config.ResetTimestamps {
+		segmentConfig.SegmentTimes = rebaseTimes(config.SegmentTimes)
+		segmentConfig.TrimStart = 0
+		segmentStart, segmentEnd = 0, endAt-startAt
+	}
+
+	forceKeyFrames, segmentArgs, err := segmentationArgs(segmentConfig, videoInfo, segmentStart, segmentEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// keyframe times are shifted to the timeline of forced keyframes, as segment times are
+	if len(config.KeyframeTimes) > 0 && config.SegmentStrategy == SegmentByTime {
+		keyframeTimes := make([]float64, len(config.KeyframeTimes))
+		for i, keyframeTime := range config.KeyframeTimes {
+			keyframeTimes[i] = config.TrimStart + keyframeTime
+			if config.ResetTimestamps {
+				keyframeTimes[i] -= startAt
+			}
+		}
+
+		if times := mergeKeyframeTimes(segmentConfig.SegmentTimes, keyframeTimes); len(times) > 0 {
+			forceKeyFrames = formatSegmentTimes(times)
+		}
+	}
+
+	logLevel := config.LogLevel
+	if config.Muxer == MuxerHLS {
+		logLevel = hlsMuxerLogLevel(logLevel)
+	} else if logLevel == "" {
+		logLevel = "warning"
+	}
+
+	args := []string{
+		"-loglevel", logLevel,
+		"-stats", // Print stats even if loglevel is below info.
+	}
+
+	// Seek to start point. Note there is a bug(?) in ffmpeg: https://github.com/FFmpeg/FFmpeg/blob/fe964d80fec17f043763405f5804f397279d6b27/fftools/ffmpeg_opt.c#L1240
+	// can possible set `seek_timestamp` to a negative value, which will cause `avformat_seek_file` to reject the input timestamp.
+	// To prevent this, the first break point, which we know will be zero, will not be fed to `-ss`.
+	inputSeek := 0.0
+	switch config.SeekMode {
+	case SeekInput:
+		inputSeek = startAt
+	case SeekAccurate:
+		inputSeek = math.Max(startAt-seekAccuratePreroll, 0)
+	}
+
+	if inputSeek > 0 {
+		args = append(args, []string{
+			"-ss", fmt.Sprintf("%.6f", inputSeek),
+		}...)
+	}
+
+	// Decoder threads
+	decodeThreads := config.Threads
+	if config.DecodeThreads > 0 {
+		decodeThreads = config.DecodeThreads
+	}
+	if decodeThreads > 0 {
+		args = append(args, "-threads", fmt.Sprintf("%d", decodeThreads))
+	}
+
+	args = append(args, config.InputOptions.args()...)
+	args = append(args, config.ExtraInputArgs...)
+
+	// Input specs
+	args = append(args, []string{
+		"-i", config.inputPath(), // Input file
+	}...)
+
+	// probed stream, since the first one may not be the main one
+	videoMap := "0:V:0?"
+	if videoInfo != nil {
+		videoMap = fmt.Sprintf("0:%d", videoInfo.Index)
+	}
+
+	// image is the second input, so that seeking and audio of the first input are kept
+	inputs := 1
+	if config.ImageInput != nil {
+		args = append(args, config.ImageInput.args(startAt, config.ResetTimestamps)...)
+		videoMap = "1:v:0"
+		inputs++
+	}
+
+	// Audio is read from the second input of the same source, that is shifted by -itsoffset and
+	// seeked, so that audio at startAt comes from before (or after) it, instead of inserting silence.
+	delayedAudio := config.AudioProfile != nil && !input.NoAudio && config.AudioDelay != 0
+	if delayedAudio {
+		delay := float64(config.AudioDelay) / 1000
+
+		// with -copyts, seek does not rebase timestamps, that are shifted by the delay only,
+		// otherwise audio seeked by the delay starts at zero together with video
+		audioSeek := inputSeek - delay
+		offset := delay
+		if config.ResetTimestamps && config.SeekMode != SeekOutput {
+			offset = 0
+			if audioSeek < 0 {
+				offset = -audioSeek
+			}
+		}
+
+		if audioSeek > 0 && config.SeekMode != SeekOutput {
+			args = append(args, "-ss", fmt.Sprintf("%.6f", audioSeek))
+		}
+
+		args = append(args, config.InputOptions.args()...)
+		args = append(args, config.ExtraInputArgs...)
+		args = append(args, []string{
+			"-itsoffset", fmt.Sprintf("%.6f", offset),
+			"-i", config.inputPath(),
+		}...)
+	}
+
+	silentAudio := config.AudioProfile != nil && input.NoAudio && config.MissingAudio == MissingAudioSilence
+	if silentAudio {
+		// Offset silence, so that it starts together with seeked input when using -copyts.
+		silenceOffset := startAt
+		if config.ResetTimestamps {
+			silenceOffset = 0
+		}
+
+		channels := config.AudioProfile.Channels
+		if channels == 0 {
+			channels = 2
+		}
+
+		sampleRate := config.AudioProfile.SampleRate
+		if sampleRate == 0 {
+			sampleRate = 48000
+		}
+
+		args = append(args, []string{
+			"-itsoffset", fmt.Sprintf("%.6f", silenceOffset),
+			"-f", "lavfi",
+			"-i", fmt.Sprintf("anullsrc=channel_layout=%dc:sample_rate=%d", channels, sampleRate),
+			"-map", videoMap,
+			"-map", fmt.Sprintf("%d:a:0", inputs),
+		}...)
+	}
+
+	// With -copyts, output seek refers to the original TS as well, otherwise input
+	// seek rebases timestamps, so that only the remainder is left for output seek
+	if startAt > 0 && config.SeekMode != SeekInput {
+		outputSeek := startAt
+		if config.ResetTimestamps {
+			outputSeek -= inputSeek
+		}
+
+		if outputSeek > 0 {
+			args = append(args, []string{
+				"-ss", fmt.Sprintf("%.6f", outputSeek),
+			}...)
+		}
+	}
+
+	if config.ResetTimestamps {
+		// output starts at zero, so that only duration can be given
+		args = append(args, "-t", fmt.Sprintf("%.6f", endAt-startAt))
+	} else {
+		args = append(args, []string{
+			"-to", fmt.Sprintf("%.6f", endAt),
+			"-copyts", // So the "-to" refers to the original TS
+		}...)
+	}
+
+	// frame count of preview is exact, time limit may be a frame off
+	if config.PreviewFrames > 0 {
+		args = append(args, "-frames:v", fmt.Sprintf("%d", config.PreviewFrames))
+	}
+
+	// Negative timestamps, e.g. of sources with edit lists or B-frames, would be shifted by
+	// the segment muxer, so that segments would not be split at requested times. Inner
+	// muxer still shifts them consistently across segments, when container needs it.
+	args = append(args, "-avoid_negative_ts", "disabled")
+
+	// Rebase trimmed output, so that it starts at zero
+	if config.TrimStart > 0 && !config.ResetTimestamps {
+		args = append(args, "-output_ts_offset", fmt.Sprintf("%.6f", -config.TrimStart))
+	}
+
+	// Single segment does not need any forced keyframes, copied video uses source keyframes
+	copyVideo := config.canCopyVideo(videoInfo)
+	if forceKeyFrames != "" && !copyVideo {
+		args = append(args, "-force_key_frames", forceKeyFrames)
+	}
+
+	args = append(args, "-sn") // No subtitles
+
+	// Audio only, e.g. MP3 with cover art, that would be encoded as video otherwise
+	if config.VideoProfile == nil {
+		args = append(args, "-vn")
+	}
+
+	// Video specs
+	if copyVideo {
+		args = append(args, "-c:v", "copy")
+
+		// source in MP4 keeps headers in extradata, filter inserts them before every keyframe
+		if config.InBandParameterSets {
+			args = append(args, "-bsf:v", "h264_mp4toannexb")
+		}
+	} else if config.VideoProfile != nil {
+		profile, err := checkFrameSize(config.VideoProfile, videoInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		scale := scaleFilter(profile, videoInfo)
+		if profile.ScaleFlags != "" {
+			scale += ":flags=" + profile.ScaleFlags
+		}
+
+		colorTags := outputColorTags(profile, videoInfo)
+		convertRange := videoInfo != nil && isFullRange(videoInfo) && colorTags.Range == "tv"
+		if convertRange {
+			scale += ":in_range=pc:out_range=tv"
+		}
+
+		if in, out := colorMatrixConversion(profile, videoInfo); in != out {
+			scale += ":in_color_matrix=" + in + ":out_color_matrix=" + out
+			colorTags.Space = colorMatrixTags[out]
+		}
+
+		scale += aspectFilter(profile, videoInfo)
+
+		// overlay is placed after scaling, so that it is not distorted by it
+		if profile.Overlay != nil {
+			scale = profile.Overlay.filterGraph(scale)
+		}
+
+		var profileArgs []string
+		if profile.Baseline {
+			profileArgs = []string{"-profile:v", "baseline"}
+		} else {
+			subsampling, bitDepth := subsampling420, 8
+			if videoInfo != nil {
+				subsampling, bitDepth = pixelFormatInfo(videoInfo.PixelFormat)
+			}
+			profileArgs = selectProfile("libx264", subsampling, bitDepth)
+		}
+
+		preset := profile.Preset
+		if preset == "" {
+			preset = defaultX264Preset
+		}
+
+		args = append(args, []string{
+			"-vf", scale,
+			"-c:v", "libx264",
+			"-preset", preset,
+		}...)
+		args = append(args, profileArgs...)
+
+		switch profile.Level {
+		case "":
+			args = append(args, "-level:v", defaultH264Level)
+		default:
+			width, height := outputSize(profile, videoInfo)
+
+			var frameRate float64
+			if videoInfo != nil {
+				frameRate = parseFrameRate(videoInfo.AvgFrameRate)
+			}
+
+			// peak bitrate is unknown for CRF without cap
+			bitrate := profile.MaxRate
+			if bitrate == 0 && profile.CRF == 0 {
+				bitrate = profile.Bitrate
+			}
+
+			if profile.Level == LevelAuto {
+				level := autoLevel(width, height, frameRate, bitrate)
+				if level == "" {
+					return nil, fmt.Errorf("%w: no H.264 level supports %dx%d at %.2f fps and %d kbit/s", ErrInvalidVideoProfile, width, height, frameRate, bitrate)
+				}
+
+				args = append(args, "-level:v", level)
+				break
+			}
+
+			level := profile.Level
+			if err := checkLevelLimits(level, profileName(profileArgs), width, height, frameRate, bitrate); err != nil {
+				if !profile.BumpLevel {
+					return nil, err
+				}
+
+				if level, err = bumpLevel(level, profileName(profileArgs), width, height, frameRate, bitrate); err != nil {
+					return nil, err
+				}
+			}
+
+			args = append(args, "-level:v", level)
+		}
+
+		if profile.CRF > 0 {
+			args = append(args, "-crf", fmt.Sprintf("%d", profile.CRF))
+		} else {
+			args = append(args, "-b:v", fmt.Sprintf("%dk", profile.Bitrate))
+		}
+
+		if profile.MaxRate > 0 {
+			bufSize := profile.BufSize
+			if bufSize == 0 {
+				bufSize = profile.MaxRate * 2
+			}
+
+			args = append(args, []string{
+				"-maxrate", fmt.Sprintf("%dk", profile.MaxRate),
+				"-bufsize", fmt.Sprintf("%dk", bufSize),
+			}...)
+		}
+
+		// baseline supports only 8-bit 4:2:0
+		if profile.Baseline {
+			args = append(args, "-pix_fmt", "yuv420p")
+		} else if videoInfo != nil && videoInfo.PixelFormat != "" {
+			pixelFormat := videoInfo.PixelFormat
+			if convertRange {
+				pixelFormat = limitedRangePixelFormat(pixelFormat)
+			}
+
+			// e.g. 12-bit source is encoded as 10-bit instead of relying on ffmpeg negotiation,
+			// JPEG-range formats are accepted as their limited range counterparts
+			if convert, suggested := NeedsConversion(limitedRangePixelFormat(pixelFormat), "libx264", profileName(profileArgs)); convert && suggested != "" {
+				pixelFormat = suggested
+			}
+
+			if pixelFormat != videoInfo.PixelFormat {
+				args = append(args, "-pix_fmt", pixelFormat)
+			}
+		}
+
+		args = append(args, colorTags.args()...)
+
+		if config.Threads > 0 {
+			args = append(args, encoderThreadsArgs("libx264", config.Threads)...)
+		}
+
+		if profile.BFrames != nil {
+			args = append(args, "-bf", fmt.Sprintf("%d", *profile.BFrames))
+		}
+
+		if profile.Refs > 0 {
+			args = append(args, "-refs", fmt.Sprintf("%d", profile.Refs))
+		}
+
+		x264Params := []string{}
+
+		// stream specifier keeps flags from being replaced by flags of tee outputs
+		if profile.ClosedGOP {
+			args = append(args, "-flags:v", "+cgop")
+			x264Params = append(x264Params, "open-gop=0")
+		}
+
+		// headers are written before every keyframe, even if global header is requested
+		if config.InBandParameterSets {
+			x264Params = append(x264Params, "repeat-headers=1")
+		}
+
+		if len(x264Params) > 0 {
+			args = append(args, "-x264-params", strings.Join(x264Params, ":"))
+		}
+
+		// GOP of a single frame, forced segment keyframes are then trivially aligned
+		if profile.IntraOnly {
+			args = append(args, "-g", "1")
+		}
+
+		if config.ImageInput != nil {
+			// looped image never ends, sequence may end before audio
+			args = append(args, "-r", fmt.Sprintf("%d", imageOutputFrameRate), "-shortest")
+		} else if profile.ConstantFrameRate {
+			args = append(args, "-vsync", "cfr")
+
+			// keep average frame rate of the source, so that duration stays the same
+			if videoInfo != nil && parseFrameRate(videoInfo.AvgFrameRate) > 0 {
+				args = append(args, "-r", videoInfo.AvgFrameRate)
+			}
+		}
+	}
+
+	// Audio specs
+	if config.AudioProfile == nil {
+		args = append(args, "-an")
+	} else if input.NoAudio && !silentAudio {
+		args = append(args, "-an")
+	} else if config.AudioProfile.canCopy(input.Audio) {
+		// packets are cut at segment times, that are given by video keyframes
+		args = append(args, "-c:a", "copy")
+	} else {
+		profile := config.AudioProfile
+
+		args = append(args, "-c:a", profile.encoder())
+
+		if profile.Profile != AACLowComplexity {
+			args = append(args, "-profile:a", string(profile.Profile))
+		}
+
+		if profile.VBR > 0 {
+			args = append(args, "-vbr", fmt.Sprintf("%d", profile.VBR))
+		} else {
+			args = append(args, "-b:a", fmt.Sprintf("%dk", profile.Bitrate))
+		}
+
+		if profile.SampleRate != 0 {
+			args = append(args, "-ar", fmt.Sprintf("%d", profile.SampleRate))
+		}
+
+		// When reducing channel count, libswresample downmixes using ITU-R BS.775
+		// coefficients (center and surrounds at -3dB, LFE dropped) instead of
+		// just discarding the extra channels.
+		if profile.Channels != 0 {
+			args = append(args, "-ac", fmt.Sprintf("%d", profile.Channels))
+		}
+	}
+
+	if config.AudioProfile != nil && len(config.AudioProfile.BitstreamFilters) > 0 && (!input.NoAudio || silentAudio) {
+		args = append(args, "-bsf:a", strings.Join(config.AudioProfile.BitstreamFilters, ","))
+	}
+
+	// Streams are mapped explicitly, so that attached picture (e.g. cover art) is never picked
+	// as video by automatic selection, that prefers the highest resolution. Tee muxer requires it too.
+	if !silentAudio {
+		audioMap := "0:a:0?"
+		if delayedAudio {
+			audioMap = "1:a:0?"
+		}
+
+		args = append(args, []string{
+			"-map", videoMap,
+			"-map", audioMap,
+		}...)
+
+		if config.PreserveAudioMetadata && input.Audio != nil {
+			args = append(args, input.Audio.metadataArgs()...)
+		}
+	}
+
+	// Segmenting specs
+	segmentOptions := []string{
+		"-segment_time_delta", fmt.Sprintf("%.1f", segmentTimeDelta),
+	}
+	if config.SegmentFormat == SegmentFormatDASH {
+		segmentOptions = append(segmentOptions, []string{
+			"-segment_format", "mp4",
+			"-segment_format_options", "movflags=+dash+frag_keyframe+empty_moov+default_base_moof",
+		}...)
+	} else {
+		segmentOptions = append(segmentOptions, "-segment_format", "mpegts")
+
+		formatOptions := []string{}
+		if config.Discontinuity {
+			formatOptions = append(formatOptions, "mpegts_flags=+initial_discontinuity")
+		}
+		if config.SegmentMuxer != nil && config.SegmentMuxer.MPEGTS != nil {
+			formatOptions = append(formatOptions, config.SegmentMuxer.MPEGTS.formatOptions()...)
+			// output metadata is passed to every segment, even by tee muxer
+			args = append(args, config.SegmentMuxer.MPEGTS.metadataArgs()...)
+		}
+		if len(formatOptions) > 0 {
+			segmentOptions = append(segmentOptions, "-segment_format_options", strings.Join(formatOptions, ":"))
+		}
+	}
+	segmentOptions = append(segmentOptions, segmentArgs...)
+	if config.SegmentMuxer != nil {
+		segmentOptions = append(segmentOptions, config.SegmentMuxer.args()...)
+	}
+
+	// parts are cut between keyframes, that are forced only at segment times
+	if config.BreakNonKeyframes || config.PartDuration > 0 {
+		segmentOptions = append(segmentOptions, "-break_non_keyframes", "1")
+	}
+
+	// parts are numbered from zero and renamed once they are written
+	segmentPattern := fmt.Sprintf("%s-%s.%s", config.SegmentPrefix, config.segmentIndexFormat(), config.SegmentFormat.extension())
+	startNumber := config.SegmentOffset
+	if config.PartDuration > 0 {
+		segmentPattern = config.partPattern()
+		startNumber = 0
+	}
+
+	segmentOptions = append(segmentOptions, []string{
+		"-segment_start_number", fmt.Sprintf("%d", startNumber),
+		"-segment_list_type", "flat",
+		"-segment_list", "pipe:1", // Output completed segments to stdout.
+	}...)
+
+	// sorted, so that arguments are deterministic
+	metadataKeys := []string{}
+	for key := range config.Metadata {
+		metadataKeys = append(metadataKeys, key)
+	}
+	sort.Strings(metadataKeys)
+	for _, key := range metadataKeys {
+		args = append(args, "-metadata", key+"="+config.Metadata[key])
+	}
+
+	args = append(args, config.ExtraOutputArgs...)
+
+	segmentPath := path.Join(config.writeDirPath(), segmentPattern)
+	if config.PartDuration > 0 {
+		segmentPath = path.Join(config.scratchDirPath(), segmentPattern)
+	}
+
+	if config.Muxer == MuxerHLS {
+		args = append(args, hlsMuxerArgs(config, segmentPath)...)
+	} else if len(config.TeeOutputs) > 0 {
+		args = append(args, teeArgs(segmentOptions, segmentPath, config.TeeOutputs)...)
+	} else {
+		args = append(args, "-f", "segment")
+		args = append(args, segmentOptions...)
+		args = append(args, segmentPath)
+	}
+
+	return args, nil
+}
+
+// Segment list entries are file names, but depending on ffmpeg version and output pattern
+// they can contain directories, so that only base name is kept. Channel always delivers
+// names relative to OutputDirPath, regardless of whether it is relative or absolute.
+func segmentListName(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return ""
+	}
+	return filepath.Base(line)
+}
+
+// Encoders interpret threads differently, e.g. libx264 treats 0 as auto
+// and honors -threads, while libx265 ignores it in favor of its thread pools.
+func encoderThreadsArgs(encoder string, threads int) []string {
+	switch encoder {
+	case "libx265":
+		return []string{"-x265-params", fmt.Sprintf("pools=%d", threads)}
+	default:
+		return []string{"-threads:v", fmt.Sprintf("%d", threads)}
+	}
+}
+
+// returns a channel, that delivers name of the segments as they are encoded
+func TranscodeSegments(ctx context.Context, ffmpegBinary string, config TranscodeConfig) (chan string, error) {
+	return NewEncoder(ffmpegBinary, "").Transcode(ctx, config)
+}
+
+// returns a channel, that delivers name of the segments as they are encoded from the reader,
+// input format must be given using InputOptions.Format, see TranscodeConfig.InputReader
+func TranscodeReader(ctx context.Context, ffmpegBinary string, reader io.Reader, config TranscodeConfig) (chan string, error) {
+	config.InputReader = reader
+	return TranscodeSegments(ctx, ffmpegBinary, config)
+}
+
+// returns a channel, that delivers name of the segments as they are encoded
+func (e *Encoder) Transcode(ctx context.Context, config TranscodeConfig) (chan string, error) {
+	job, err := e.Start(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return job.segments, nil
+}
+
+// Start starts transcoding and returns job, that delivers segments and signals completion.
+func (e *Encoder) Start(ctx context.Context, config TranscodeConfig) (*Job, error) {
+	requestedAt := time.Now()
+	e.applyDefaults(&config)
+	config.selectStreams()
+
+	logger := config.jobLogger(ctx, e.logger)
+
+	// existing complete segments are kept by resume
+	if config.Overwrite == OverwriteSkip {
+		config.Resume = true
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.ensureOutputDir(); err != nil {
+		return nil, err
+	}
+
+	if config.PreviewDuration > 0 || config.PreviewFrames > 0 {
+		if err := e.applyPreview(ctx, &config); err != nil {
+			return nil, err
+		}
+
+		logger.Info().Float64("end", config.SegmentTimes[len(config.SegmentTimes)-1]).Int("segments", len(config.SegmentTimes)-1).Msg("encoding preview")
+	}
+
+	if config.Append != nil {
+		config.SegmentTimes = config.Append.segmentTimes(config.SegmentTimes)
+		config.SegmentOffset = config.Append.NextSegment
+		if len(config.SegmentTimes) < 2 {
+			return nil, ErrNothingToAppend
+		}
+
+		logger.Info().Int("segment", config.SegmentOffset).Float64("time", config.Append.Time).Msg("appending to existing output")
+	}
+
+	if config.Overwrite == OverwriteError {
+		if err := config.checkExistingSegments(); err != nil {
+			return nil, err
+		}
+	}
+
+	totalSegments := len(config.SegmentTimes) - 1
+	segmentTimes := config.SegmentTimes
+
+	// collect warnings for the manifest
+	var warnings []Warning
+	warningHook := config.WarningHook
+	config.WarningHook = func(warning Warning) {
+		warnings = append(warnings, warning)
+		if warningHook != nil {
+			warningHook(warning)
+		}
+	}
+
+	if config.StagingDirPath != "" {
+		if err := os.MkdirAll(config.StagingDirPath, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	// Skip segments written by previous, possibly interrupted, encode
+	var skipped []string
+	if config.Resume {
+		complete := e.completeSegments(ctx, &config)
+		for i := 0; i < complete; i++ {
+			skipped = append(skipped, config.segmentName(config.SegmentOffset+i))
+		}
+
+		if complete > 0 {
+			logger.Info().Int("segments", complete).Msg("resuming encode, skipping complete segments")
+
+			config.SegmentTimes = config.SegmentTimes[complete:]
+			config.SegmentOffset += complete
+		}
+
+		// nothing left to encode
+		if len(config.SegmentTimes) < 2 {
+			job := newJob(totalSegments)
+			produced := make(chan string, len(skipped))
+			events := map[string]SegmentEvent{}
+			for i, segmentName := range skipped {
+				event, err := config.segmentEvent(segmentName, config.SegmentOffset-len(skipped)+i)
+				if err != nil {
+					return nil, err
+				}
+
+				events[segmentName] = event
+				if config.SegmentHook != nil {
+					config.SegmentHook(event)
+				}
+
+				produced <- segmentName
+				job.segmentEncoded()
+			}
+			close(produced)
+
+			go forwardSegments(produced, job.segments)
+
+			job.manifest = buildManifest(&config, segmentTimes, inputInfo{}, skipped, events, warnings)
+			if config.ManifestPath != "" {
+				if err := job.manifest.writeFile(config.ManifestPath); err != nil {
+					return nil, fmt.Errorf("unable to write manifest: %w", err)
+				}
+			}
+
+			job.finish(nil)
+			return job, nil
+		}
+	}
+
+	if config.CheckFreeSpace {
+		if err := config.checkFreeSpace(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fail fast if ffmpeg is not compiled with required encoders, filters or demuxer
+	if capabilities, err := cachedCapabilities(ctx, e.ffmpegBinary); err != nil {
+		logger.Warn().Err(err).Msg("could not check ffmpeg capabilities")
+		config.warn(Warning{Kind: WarningCapabilitiesUnknown, Message: "could not check ffmpeg capabilities", Err: err})
+	} else if err := capabilities.checkRequirements(&config); err != nil {
+		return nil, err
+	}
+
+	// recorded in manifest, so that output changes can be related to ffmpeg upgrades
+	ffmpegVersion, err := Version(ctx, e.ffmpegBinary)
+	if err != nil {
+		logger.Warn().Err(err).Msg("could not determine ffmpeg version")
+	}
+
+	// Stream can be read only once, so that it is neither checked nor probed
+	streamed := config.InputReader != nil
+	if streamed {
+		config.SeekMode = SeekOutput
+	}
+
+	// Reject corrupt or unsupported inputs, instead of returning segments channel that closes empty
+	if !streamed {
+		if err := checkDecodable(ctx, e.ffmpegBinary, config.InputFilePath, config.InputOptions, config.TrimStart+config.SegmentTimes[0], decodeCheckDuration); err != nil {
+			return nil, err
+		}
+	}
+
+	// Chapter starts are converted to the timeline of segment times
+	if config.KeyframesAtChapters && config.VideoProfile != nil && !streamed {
+		var chapters []float64
+		err := e.withProbeTimeout(ctx, func(ctx context.Context) (err error) {
+			chapters, err = ProbeChapters(ctx, e.ffprobeBinary, config.InputFilePath, config.InputOptions)
+			return
+		})
+
+		if errors.Is(err, ErrProbeTimeout) {
+			return nil, fmt.Errorf("%w: %s", err, config.InputFilePath)
+		} else if err != nil {
+			logger.Warn().Err(err).Msg("could not detect chapters")
+			config.warn(Warning{Kind: WarningProbeFailed, Message: "could not detect chapters", Err: err})
+		} else {
+			keyframeTimes := append([]float64{}, config.KeyframeTimes...)
+			for _, chapter := range chapters {
+				keyframeTimes = append(keyframeTimes, chapter-config.TrimStart)
+			}
+			config.KeyframeTimes = keyframeTimes
+		}
+	}
+
+	// Detect video format to determine appropriate profile
+	var input inputInfo
+	if config.VideoProfile != nil && !streamed && !config.SkipVideoProbe && config.ImageInput == nil {
+		var videoInfo *VideoInfo
+		err := e.withProbeTimeout(ctx, func(ctx context.Context) (err error) {
+			videoInfo, err = detectVideoFormat(ctx, e.ffprobeBinary, config.InputFilePath, config.InputOptions)
+			return
+		})
+
+		if errors.Is(err, ErrNoVideoStream) || errors.Is(err, ErrProbeTimeout) {
+			return nil, fmt.Errorf("%w: %s", err, config.InputFilePath)
+		} else if err != nil {
+			logger.Warn().Err(err).Msg("could not detect video format, using default profile")
+			config.warn(Warning{Kind: WarningProbeFailed, Message: "could not detect video format, using default profile", Err: err})
+		} else {
+			logger.Info().Int("stream", videoInfo.Index).Str("pix_fmt", videoInfo.PixelFormat).Msg("detected pixel format")
+			if config.canCopyVideo(videoInfo) {
+				logger.Info().Str("bit_rate", videoInfo.BitRate).Int("rotation", videoInfo.rotation()).Msg("source video is compatible, copying it")
+			}
+			if is422Format(videoInfo.PixelFormat) {
+				logger.Info().Str("pix_fmt", videoInfo.PixelFormat).Msg("detected 4:2:2 format, using high422 profile")
+			} else {
+				logger.Info().Str("pix_fmt", videoInfo.PixelFormat).Msg("using default profile for format")
+			}
+
+			if videoInfo.IsVariableFrameRate() {
+				logger.Warn().
+					Str("r_frame_rate", videoInfo.RFrameRate).
+					Str("avg_frame_rate", videoInfo.AvgFrameRate).
+					Bool("cfr", config.VideoProfile.ConstantFrameRate).
+					Msg("detected variable frame rate, segment durations may drift")
+				config.warn(Warning{Kind: WarningVariableFrameRate, Message: fmt.Sprintf("detected variable frame rate %s (average %s)", videoInfo.RFrameRate, videoInfo.AvgFrameRate)})
+			}
+
+			if videoInfo.isAnamorphic() {
+				logger.Info().
+					Str("sar", videoInfo.SampleAspectRatio).
+					Str("dar", videoInfo.DisplayAspectRatio).
+					Msg("detected anamorphic video, scaling to square pixels")
+			}
+
+			if isUpscaled(config.VideoProfile, videoInfo) {
+				logger.Warn().Int("width", videoInfo.Width).Int("height", videoInfo.Height).Msg("output is upscaled")
+				config.warn(Warning{Kind: WarningUpscale, Message: fmt.Sprintf("source %dx%d is upscaled", videoInfo.Width, videoInfo.Height)})
+			}
+
+			input.Video = videoInfo
+		}
+	}
+
+	// Detect audio presence, so that encode does not fail on inputs without audio
+	if config.AudioProfile != nil && !streamed {
+		var audioStreams []AudioInfo
+		err := e.withProbeTimeout(ctx, func(ctx context.Context) (err error) {
+			audioStreams, err = detectAudioStreams(ctx, e.ffprobeBinary, config.InputFilePath, config.InputOptions)
+			return
+		})
+
+		if errors.Is(err, ErrProbeTimeout) {
+			return nil, fmt.Errorf("%w: %s", err, config.InputFilePath)
+		} else if err != nil {
+			logger.Warn().Err(err).Msg("could not detect audio streams")
+			config.warn(Warning{Kind: WarningProbeFailed, Message: "could not detect audio streams", Err: err})
+		} else if len(audioStreams) > 0 {
+			input.Audio = &audioStreams[0]
+
+			if config.AudioProfile.canCopy(input.Audio) {
+				logger.Info().Str("bit_rate", input.Audio.BitRate).Msg("source audio is compatible, copying it")
+			}
+		} else {
+			input.NoAudio = true
+
+			if config.MissingAudio == MissingAudioSilence {
+				logger.Warn().Msg("input has no audio stream, adding silent track")
+				config.warn(Warning{Kind: WarningMissingAudio, Message: "input has no audio stream, adding silent track"})
+			} else {
+				logger.Warn().Msg("input has no audio stream, skipping audio")
+				config.warn(Warning{Kind: WarningMissingAudio, Message: "input has no audio stream, skipping audio"})
+			}
+		}
+	}
+
+	// other strategies cut at their own times, that are known only once the source is probed
+	if config.SegmentStrategy != SegmentByTime {
+		segmentTimes = strategySegmentTimes(&config, segmentTimes, input.Video)
+		totalSegments = len(segmentTimes) - 1
+	}
+
+	if err := config.createScratchDir(); err != nil {
+		return nil, err
+	}
+
+	args, err := buildArgs(config, input)
+	if err != nil {
+		config.removeScratchDir()
+		return nil, err
+	}
+
+	// allows to stop ffmpeg when processing of its output fails
+	ctx, cancel := context.WithCancel(ctx)
+
+	env := []string{"TMPDIR=" + config.scratchDir}
+
+	// sorted, so that environment is deterministic, duplicate keys are overridden
+	envKeys := []string{}
+	for key := range config.Env {
+		envKeys = append(envKeys, key)
+	}
+	sort.Strings(envKeys)
+	for _, key := range envKeys {
+		env = append(env, key+"="+config.Env[key])
+	}
+
+	// finished segments are listed on stdout by segment muxer, HLS muxer
+	// announces them on stderr, that are translated to the same list
+	process := &runner{
+		binary:     e.ffmpegBinary,
+		args:       args,
+		env:        env,
+		stdin:      config.InputReader,
+		stdoutMode: stdoutSegmentList,
+
+		cpuAffinity: config.CPUAffinity,
+	}
+
+	var segmentList io.Reader
+	var hlsList *hlsSegmentList
+	var listReader *io.PipeReader
+	if config.Muxer == MuxerHLS {
+		var listWriter *io.PipeWriter
+		listReader, listWriter = io.Pipe()
+		segmentList = listReader
+		hlsList = &hlsSegmentList{writer: listWriter, suffix: "." + config.SegmentFormat.extension()}
+		process.stdoutMode = stdoutIgnored
+	}
+
+	// queued until number of running encodes drops below the limit
+	if err := e.acquireSlot(ctx); err != nil {
+		cancel()
+		config.removeScratchDir()
+		return nil, err
+	}
+
+	logger.Info().Str("args", strings.Join(append([]string{e.ffmpegBinary}, redactArgs(args)...), " ")).Msg("starting ffmpeg process")
+
+	var logFile *os.File
+	if config.LogFilePath != "" {
+		logFile, err = os.Create(config.LogFilePath)
+		if err != nil {
+			e.releaseSlot()
+			cancel()
+			config.removeScratchDir()
+			return nil, fmt.Errorf("unable to create log file: %w", err)
+		}
+	}
+
+	// start execution
+	startedAt := time.Now()
+	if err := process.start(ctx); err != nil {
+		if logFile != nil {
+			logFile.Close()
+		}
+		e.releaseSlot()
+		cancel()
+		config.removeScratchDir()
+		return nil, err
+	}
+
+	cmd := process.cmd
+	stderr := process.stderr
+	if segmentList == nil {
+		segmentList = process.stdout
+	}
+
+	if config.Nice != 0 {
+		if err := cmdgroup.SetNice(cmd, config.Nice); err != nil {
+			logger.Warn().Err(err).Int("nice", config.Nice).Msg("unable to set ffmpeg niceness")
+		}
+	}
+
+	if process.affinityErr != nil {
+		logger.Warn().Err(process.affinityErr).Ints("cpus", config.CPUAffinity).Msg("unable to set ffmpeg CPU affinity")
+	}
+
+	var parts [][]float64
+	if config.PartDuration > 0 {
+		parts = PartTimes(config.SegmentTimes, config.PartDuration)
+	}
+
+	job := newJob(totalSegments)
+	job.cancel = cancel
+
+	e.addActive(job, JobStatus{
+		JobID:     config.JobID,
+		TraceID:   config.TraceID,
+		PID:       cmd.Process.Pid,
+		Args:      redactArgs(cmd.Args),
+		StartedAt: startedAt,
+		Cancel:    job.Cancel,
+	})
+	e.recorder.EncodeStarted()
+
+	// context only kills ffmpeg itself, its children are killed with the process group
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := cmdgroup.Kill(cmd); err != nil {
+				logger.Warn().Err(err).Msg("unable to kill ffmpeg process group")
+			}
+		case <-exited:
+		}
+	}()
+
+	produced := make(chan string)
+	go forwardSegments(produced, job.segments)
+
+	milestones := newMilestoneTracker(config.MilestoneHook, startedAt, totalSegments, config.outputSegmentTimes())
+
+	var encoded []string                // segments produced by ffmpeg
+	events := map[string]SegmentEvent{} // of delivered segments
+	var lastStats encodeStats
+	var x264Stats x264Summary
+	var stderrErr error
+	var outputErr error   // first failure of segment processing, ffmpeg is killed because of it
+	var drainStopped bool // ffmpeg was killed after a finished segment of drained job
+
+	readers := sync.WaitGroup{}
+	readers.Add(2)
+
+	// handle stdout
+	go func() {
+		defer readers.Done()
+		defer close(produced)
+
+		// stderr reader must not block on the list, once it is not read anymore
+		if listReader != nil {
+			defer listReader.Close()
+		}
+
+		// stops ffmpeg, the first failure is reported by the job
+		fail := func(err error, segmentName string, msg string) {
+			logger.Err(err).Str("segment", segmentName).Msg(msg + ", stopping ffmpeg")
+			if outputErr == nil {
+				outputErr = fmt.Errorf("%s %s: %w", msg, segmentName, err)
+			}
+			cancel()
+		}
+
+		// returns false if the segment must not be delivered
+		segmentReady := func(segmentName string, sequence int) bool {
+			event, err := config.segmentEvent(segmentName, sequence)
+			if err != nil {
+				fail(err, segmentName, "unable to prepare segment event")
+				return false
+			}
+
+			events[segmentName] = event
+			if config.SegmentHook != nil {
+				config.SegmentHook(event)
+			}
+			return true
+		}
+
+		for i, segmentName := range skipped {
+			if !segmentReady(segmentName, config.SegmentOffset-len(skipped)+i) {
+				return
+			}
+
+			produced <- segmentName
+			job.segmentEncoded()
+		}
+
+		sequence := config.SegmentOffset
+		partNames := []string{} // parts of the current segment
+
+		scanner := bufio.NewScanner(segmentList)
+		for scanner.Scan() {
+			segmentName := segmentListName(scanner.Text())
+			if segmentName == "" {
+				continue
+			}
+
+			if config.PartDuration > 0 {
+				partName, err := config.publishPart(segmentName, sequence, len(partNames))
+				if err != nil {
+					fail(err, segmentName, "unable to publish partial segment")
+					break
+				}
+
+				produced <- partName
+				partNames = append(partNames, partName)
+
+				// wait for remaining parts of the segment
+				if i := sequence - config.SegmentOffset; i < len(parts) && len(partNames) < len(parts[i])-1 {
+					continue
+				}
+
+				segmentName = config.segmentName(sequence)
+				if err := config.assembleSegment(segmentName, partNames); err != nil {
+					fail(err, segmentName, "unable to assemble segment from parts")
+					break
+				}
+
+				partNames = []string{}
+			}
+
+			segmentPath := path.Join(config.writeDirPath(), segmentName)
+
+			if config.Encryption != nil {
+				if err := config.Encryption.encryptSegment(segmentPath, sequence); err != nil {
+					// never leave unencrypted segment behind
+					os.Remove(segmentPath)
+					fail(err, segmentName, "unable to encrypt segment")
+					break
+				}
+			}
+
+			initSegment := config.SegmentFormat == SegmentFormatDASH && sequence == config.SegmentOffset
+			if config.SegmentFormat == SegmentFormatDASH {
+				initPath := ""
+				if initSegment {
+					initPath = path.Join(config.OutputDirPath, config.initSegmentName())
+				}
+
+				if err := prepareDASHSegment(segmentPath, initPath); err != nil {
+					os.Remove(segmentPath)
+					fail(err, segmentName, "unable to prepare DASH segment")
+					break
+				}
+			}
+
+			if config.StagingDirPath != "" {
+				if err := publishSegment(segmentPath, path.Join(config.OutputDirPath, segmentName)); err != nil {
+					os.Remove(segmentPath)
+					fail(err, segmentName, "unable to publish segment")
+					break
+				}
+			}
+
+			if config.TimestampedNames {
+				timedName := config.timestampedSegmentName(sequence, config.SegmentTimes[sequence-config.SegmentOffset])
+				if err := os.Rename(path.Join(config.OutputDirPath, segmentName), path.Join(config.OutputDirPath, timedName)); err != nil {
+					fail(err, segmentName, "unable to rename segment")
+					break
+				}
+
+				segmentName = timedName
+			}
+
+			if sequence == config.SegmentOffset {
+				elapsed := time.Since(requestedAt)
+				logger.Info().Str("segment", segmentName).Dur("elapsed", elapsed).Msg("first segment ready")
+
+				if config.FirstSegmentHook != nil {
+					config.FirstSegmentHook(segmentName, elapsed)
+				}
+			}
+
+			if !segmentReady(segmentName, sequence) {
+				break
+			}
+
+			if config.SegmentSink != nil {
+				sinkNames := []string{segmentName}
+				if initSegment {
+					sinkNames = []string{config.initSegmentName(), segmentName}
+				}
+
+				var err error
+				for _, sinkName := range sinkNames {
+					if err = config.sinkSegment(sinkName); err != nil {
+						fail(err, sinkName, "unable to write segment to sink")
+						break
+					}
+				}
+
+				if err != nil {
+					break
+				}
+			}
+
+			if initSegment {
+				produced <- config.initSegmentName()
+			}
+
+			produced <- segmentName
+			encoded = append(encoded, segmentName)
+			job.segmentEncoded()
+			e.recorder.SegmentProduced()
+			milestones.segmentFinished(len(skipped) + len(encoded))
+			sequence++
+
+			if job.draining() && len(skipped)+len(encoded) < totalSegments {
+				logger.Info().Str("segment", segmentName).Msg("segment finished, stopping drained ffmpeg")
+				drainStopped = true
+				cancel()
+				break
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			logger.Err(err).Msg("error while reading ffmpeg stdout")
+		}
+	}()
+
 	// handle stderr
 	go func() {
-		defer wg.Done()
+		defer readers.Done()
+
+		// stderr is closed once ffmpeg exits, whether it failed or not
+		var logWriter *bufio.Writer
+		if logFile != nil {
+			logWriter = bufio.NewWriter(logFile)
+			defer func() {
+				if err := logWriter.Flush(); err != nil {
+					logger.Warn().Err(err).Str("path", config.LogFilePath).Msg("unable to write log file")
+				}
+				if err := logFile.Close(); err != nil {
+					logger.Warn().Err(err).Str("path", config.LogFilePath).Msg("unable to close log file")
+				}
+			}()
+		}
+
+		// stderr ends once ffmpeg exits, so that the list ends as well
+		if hlsList != nil {
+			defer hlsList.writer.Close()
+		}
 
 		scanner := bufio.NewScanner(stderr)
+		scanner.Split(scanStderrLines)
 		for scanner.Scan() {
-			log.Println(scanner.Text())
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+
+			if logWriter != nil {
+				// write errors are reported by flush
+				logWriter.WriteString(line + "\n")
+			}
+
+			if hlsList != nil && hlsList.parseLine(line) {
+				logger.Debug().Msg(line)
+				continue
+			}
+
+			if stats, ok := parseStatsLine(line); ok {
+				lastStats = stats
+				milestones.progress(stats.Time)
+				if config.progressHook != nil {
+					config.progressHook(stats)
+				}
+				continue
+			}
+
+			if x264Stats.parseLine(line) {
+				logger.Info().Msg(line)
+				continue
+			}
+
+			if err := promoteWarning(line, config.FailOnWarnings); err != nil {
+				if stderrErr == nil {
+					stderrErr = err
+				}
+
+				logger.Error().Msg(line)
+				cancel()
+				continue
+			}
+
+			if err := classifyStderr(line); err != nil {
+				// first error is usually the root cause
+				if stderrErr == nil {
+					stderrErr = err
+				}
+
+				logger.Error().Msg(line)
+				continue
+			}
+
+			logger.Warn().Msg(line)
 		}
 
 		if err := scanner.Err(); err != nil {
-			log.Println("Error while reading FFmpeg stderr:", err)
+			logger.Err(err).Msg("error while reading ffmpeg stderr")
 		}
 	}()
 
-	// start execution
-	err = cmd.Start()
-
 	// wait until execution finishes
 	go func() {
-		defer wg.Done()
+		defer cancel()
 
-		err := cmd.Wait()
-		if err != nil {
-			log.Println("FFmpeg process exited with error:", err)
+		// pipes must be fully read before calling wait, since it closes them
+		readers.Wait()
+
+		err := process.wait()
+		close(exited)
+		e.removeActive(job)
+		e.releaseSlot()
+
+		if err := config.removeScratchDir(); err != nil {
+			logger.Warn().Err(err).Str("dir", config.scratchDir).Msg("unable to remove scratch directory")
+		}
+
+		var misaligned map[string]bool // nil unless verified
+		if outputErr != nil {
+			// ffmpeg was killed or has already finished, either way the output is incomplete
+			logger.Err(outputErr).Msg("segment processing failed")
+			err = outputErr
+		} else if err != nil && (drainStopped || job.drainTimedOut()) && stderrErr == nil {
+			// killed after a finished segment or after drain timeout, not failed on its own
+			logger.Info().Int("segments", len(encoded)).Msg("ffmpeg process was drained")
+			if err := config.removePartialSegment(len(encoded)); err != nil {
+				logger.Warn().Err(err).Msg("unable to remove partial segment")
+			}
+
+			err = ErrDrained
+		} else if err != nil {
+			logger.Err(err).Msg("ffmpeg process exited with error")
+
+			// prefer classified error, since exit status alone is not descriptive
+			if stderrErr != nil {
+				err = fmt.Errorf("%w (%v)", stderrErr, err)
+			}
+
+			if errors.Is(err, ErrDiskFull) {
+				if err := config.removePartialSegment(len(encoded)); err != nil {
+					logger.Warn().Err(err).Msg("unable to remove partial segment")
+				}
+			}
 		} else {
-			log.Println("FFmpeg process successfully finished.")
+			logger.Info().Msg("ffmpeg process successfully finished")
+
+			// encrypted and DASH media segments cannot be probed on their own, sunk and uploaded segments are gone
+			if config.SegmentDurationTolerance > 0 && config.Encryption == nil && config.SegmentFormat == SegmentFormatMPEGTS && config.SegmentSink == nil && !config.segmentsRemoved {
+				if deviating := e.verifySegmentDurations(ctx, &config, encoded); deviating > 0 {
+					logger.Warn().Int("segments", deviating).Msg("segment durations deviate from requested segment times")
+				}
+			}
+
+			// other strategies do not cut at segment times
+			if config.VerifyKeyframes && config.VideoProfile != nil && config.SegmentStrategy == SegmentByTime && config.Encryption == nil && config.SegmentFormat == SegmentFormatMPEGTS && config.SegmentSink == nil && !config.segmentsRemoved {
+				misaligned = e.verifySegmentKeyframes(ctx, &config, encoded)
+				if len(misaligned) > 0 {
+					logger.Warn().Int("segments", len(misaligned)).Msg("segments do not start with keyframe at requested time")
+				}
+			}
+		}
+
+		metrics := lastStats.metrics(time.Since(startedAt))
+		metrics.AvgQP = x264Stats.avgQP()
+		metrics.addSegmentBitrates(encoded, events, segmentTimes[len(skipped):])
+		if config.MetricsHook != nil {
+			config.MetricsHook(metrics)
+		}
+
+		if err == nil {
+			job.manifest = buildManifest(&config, segmentTimes, input, append(skipped, encoded...), events, warnings)
+			job.manifest.Metrics = &metrics
+			job.manifest.FFmpeg = ffmpegVersion
+
+			for i, segment := range job.manifest.Segments {
+				job.manifest.Segments[i].KeyframeMisaligned = misaligned[segment.Name]
+			}
+
+			if config.ManifestPath != "" {
+				if err = job.manifest.writeFile(config.ManifestPath); err != nil {
+					logger.Err(err).Msg("unable to write manifest")
+					err = fmt.Errorf("unable to write manifest: %w", err)
+				}
+			}
 		}
+
+		e.recorder.EncodeFinished(time.Since(startedAt), metrics.Speed, err)
+
+		if config.ExitHook != nil {
+			config.ExitHook(err)
+		}
+
+		job.finish(err)
 	}()
 
-	return segments, err
+	return job, nil
 }
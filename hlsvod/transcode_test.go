@@ -0,0 +1,1047 @@
+package hlsvod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func argIndex(args []string, flag string) int {
+	for i, arg := range args {
+		if arg == flag {
+			return i
+		}
+	}
+	return -1
+}
+
+func argValue(args []string, flag string) string {
+	i := argIndex(args, flag)
+	if i == -1 || i+1 >= len(args) {
+		return ""
+	}
+	return args[i+1]
+}
+
+func TestBuildArgsSeekMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		seekMode   SeekMode
+		startAt    float64
+		wantSeek   bool
+		wantBefore bool // seek placed before input
+	}{
+		{
+			name:     "input seek: from zero",
+			seekMode: SeekInput,
+			startAt:  0,
+			wantSeek: false,
+		},
+		{
+			name:       "input seek: mid stream",
+			seekMode:   SeekInput,
+			startAt:    12.5,
+			wantSeek:   true,
+			wantBefore: true,
+		},
+		{
+			name:     "output seek: from zero",
+			seekMode: SeekOutput,
+			startAt:  0,
+			wantSeek: false,
+		},
+		{
+			name:       "output seek: mid stream",
+			seekMode:   SeekOutput,
+			startAt:    12.5,
+			wantSeek:   true,
+			wantBefore: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildArgs(TranscodeConfig{
+				InputFilePath: "input.mp4",
+				SeekMode:      tt.seekMode,
+				SegmentTimes:  []float64{tt.startAt, tt.startAt + 4, tt.startAt + 8},
+			}, inputInfo{})
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+
+			seek, input := argIndex(args, "-ss"), argIndex(args, "-i")
+			if (seek != -1) != tt.wantSeek {
+				t.Fatalf("buildArgs() seek present = %v, want %v", seek != -1, tt.wantSeek)
+			}
+
+			if !tt.wantSeek {
+				return
+			}
+
+			if (seek < input) != tt.wantBefore {
+				t.Errorf("buildArgs() seek before input = %v, want %v", seek < input, tt.wantBefore)
+			}
+
+			if got, want := args[seek+1], fmt.Sprintf("%.6f", tt.startAt); got != want {
+				t.Errorf("buildArgs() seek = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildArgsSegmentOffset(t *testing.T) {
+	tests := []struct {
+		name          string
+		segmentOffset int
+		trimStart     float64
+		segmentTimes  []float64
+		// values of -ss, -segment_start_number, -segment_times, -segment_time and -force_key_frames
+		want []string
+	}{
+		{
+			name:          "from zero",
+			segmentOffset: 0,
+			segmentTimes:  []float64{0, 4, 8},
+			want:          []string{"", "0", "4.000000", "", "4.000000"},
+		},
+		{
+			name:          "mid stream window",
+			segmentOffset: 10,
+			segmentTimes:  []float64{40, 44, 48, 52},
+			want:          []string{"40.000000", "10", "44.000000,48.000000", "", "expr:gte(t,40.000000+n_forced*4.000000)"},
+		},
+		{
+			name:          "irregular window",
+			segmentOffset: 10,
+			segmentTimes:  []float64{40, 44, 49, 52},
+			want:          []string{"40.000000", "10", "44.000000,49.000000", "", "44.000000,49.000000"},
+		},
+		{
+			name:          "evenly spaced from zero",
+			segmentOffset: 0,
+			segmentTimes:  []float64{0, 4, 8, 10},
+			want:          []string{"", "0", "", "4.000000", "expr:gte(t,0.000000+n_forced*4.000000)"},
+		},
+		{
+			name:          "evenly spaced from zero with full last segment",
+			segmentOffset: 0,
+			segmentTimes:  []float64{0, 4, 8, 12},
+			want:          []string{"", "0", "4.000000,8.000000", "", "expr:gte(t,0.000000+n_forced*4.000000)"},
+		},
+		{
+			name:          "single segment",
+			segmentOffset: 3,
+			segmentTimes:  []float64{12, 16},
+			want:          []string{"12.000000", "3", "", "1000000000", ""},
+		},
+		{
+			name:          "trimmed window",
+			segmentOffset: 2,
+			trimStart:     30,
+			segmentTimes:  []float64{8, 12, 16},
+			want:          []string{"38.000000", "2", "12.000000", "", "42.000000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildArgs(TranscodeConfig{
+				InputFilePath: "input.mp4",
+				SegmentOffset: tt.segmentOffset,
+				TrimStart:     tt.trimStart,
+				SegmentTimes:  tt.segmentTimes,
+			}, inputInfo{})
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+
+			got := []string{
+				argValue(args, "-ss"),
+				argValue(args, "-segment_start_number"),
+				argValue(args, "-segment_times"),
+				argValue(args, "-segment_time"),
+				argValue(args, "-force_key_frames"),
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildArgsNegativeTimestamps(t *testing.T) {
+	args, err := buildArgs(TranscodeConfig{
+		InputFilePath: "input.mp4",
+		SegmentTimes:  []float64{0, 4, 8},
+	}, inputInfo{})
+	if err != nil {
+		t.Fatalf("buildArgs() error = %v", err)
+	}
+
+	if got := argValue(args, "-avoid_negative_ts"); got != "disabled" {
+		t.Errorf("buildArgs() -avoid_negative_ts = %q, want %q", got, "disabled")
+	}
+
+	// output option, it must not be applied to the input
+	if argIndex(args, "-avoid_negative_ts") < argIndex(args, "-i") {
+		t.Errorf("buildArgs() -avoid_negative_ts placed before input")
+	}
+}
+
+func TestBuildArgsKeyframeTimes(t *testing.T) {
+	args, err := buildArgs(TranscodeConfig{
+		InputFilePath: "input.mp4",
+		SegmentTimes:  []float64{40, 44, 48, 52},
+		// before the window, duplicate of segment time, within the window twice
+		KeyframeTimes: []float64{10, 44.05, 46.5, 46.5},
+	}, inputInfo{})
+	if err != nil {
+		t.Fatalf("buildArgs() error = %v", err)
+	}
+
+	want := "44.000000,46.500000,48.000000"
+	if got := argValue(args, "-force_key_frames"); got != want {
+		t.Errorf("buildArgs() -force_key_frames = %q, want %q", got, want)
+	}
+
+	// muxer still cuts only at segment times
+	if got := argValue(args, "-segment_times"); got != "44.000000,48.000000" {
+		t.Errorf("buildArgs() -segment_times = %q, want %q", got, "44.000000,48.000000")
+	}
+}
+
+func TestBuildArgsSingleSegment(t *testing.T) {
+	tests := []struct {
+		name         string
+		segmentTimes []float64
+		wantTo       string
+	}{
+		{"clip shorter than segment", []float64{0, 1.5}, "1.500000"},
+		{"near-zero duration", []float64{0, 0.04}, "0.040000"},
+		{"window mid stream", []float64{10, 10.001}, "10.001000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildArgs(TranscodeConfig{
+				InputFilePath: "input.mp4",
+				SegmentTimes:  tt.segmentTimes,
+			}, inputInfo{})
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+
+			// only the first keyframe, that is always encoded
+			if argIndex(args, "-force_key_frames") != -1 {
+				t.Errorf("buildArgs() forces keyframes %q", argValue(args, "-force_key_frames"))
+			}
+
+			if argIndex(args, "-segment_times") != -1 {
+				t.Errorf("buildArgs() -segment_times = %q, want none", argValue(args, "-segment_times"))
+			}
+
+			want := fmt.Sprintf("%d", neverSplitSegmentTime)
+			if got := argValue(args, "-segment_time"); got != want {
+				t.Errorf("buildArgs() -segment_time = %q, want %q", got, want)
+			}
+
+			if got := argValue(args, "-to"); got != tt.wantTo {
+				t.Errorf("buildArgs() -to = %q, want %q", got, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestSegmentationArgsSingleSegmentByFrames(t *testing.T) {
+	config := TranscodeConfig{
+		SegmentStrategy: SegmentByFrames,
+		SegmentFrames:   100,
+		SegmentTimes:    []float64{0, 1},
+	}
+
+	// 25 frames fit into single segment of 100 frames
+	_, segmentArgs, err := segmentationArgs(config, &VideoInfo{AvgFrameRate: "25/1"}, 0, 1)
+	if err != nil {
+		t.Fatalf("segmentationArgs() error = %v", err)
+	}
+
+	want := []string{"-segment_time", fmt.Sprintf("%d", neverSplitSegmentTime)}
+	if !reflect.DeepEqual(segmentArgs, want) {
+		t.Errorf("segmentationArgs() = %v, want %v", segmentArgs, want)
+	}
+}
+
+func TestValidateSegmentTimes(t *testing.T) {
+	tests := []struct {
+		name         string
+		segmentTimes []float64
+		wantErr      error
+	}{
+		{"no segment", []float64{0}, ErrTooFewSegmentTimes},
+		{"single segment", []float64{0, 1.5}, nil},
+		{"near-zero duration", []float64{0, 0.001}, nil},
+		{"zero duration", []float64{0, 0}, ErrInvalidConfig},
+		{"duplicate time", []float64{0, 4, 4, 8}, ErrInvalidConfig},
+		{"decreasing time", []float64{4, 0}, ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := TranscodeConfig{
+				InputFilePath: "input.mp4",
+				SegmentTimes:  tt.segmentTimes,
+			}
+
+			err := config.validate()
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConvertToSegmentsShortInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		want     []float64
+	}{
+		{"shorter than segment", 1500 * time.Millisecond, []float64{0, 1.5}},
+		{"near-zero duration", 40 * time.Millisecond, []float64{0, 0.04}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertToSegments([]float64{0}, tt.duration, 4, 1)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertToSegments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentListName(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"base name", "test-00001.ts", "test-00001.ts"},
+		{"relative path", "out/test-00001.ts", "test-00001.ts"},
+		{"absolute path", "/var/lib/out/test-00001.ts", "test-00001.ts"},
+		{"trailing carriage return", "test-00001.ts\r", "test-00001.ts"},
+		{"empty line", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := segmentListName(tt.line); got != tt.want {
+				t.Errorf("segmentListName(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+//
+// integration tests, they require ffmpeg and ffprobe
+//
+
+func requireFFmpeg(t *testing.T) (ffmpegBinary, ffprobeBinary string) {
+	t.Helper()
+
+	ffmpegBinary, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg not found")
+	}
+
+	ffprobeBinary, err = exec.LookPath("ffprobe")
+	if err != nil {
+		t.Skip("ffprobe not found")
+	}
+
+	return
+}
+
+// generates test input with keyframes every 2 seconds
+func generateTestInput(t *testing.T, ffmpegBinary string, duration int) string {
+	t.Helper()
+
+	inputPath := path.Join(t.TempDir(), "input.mp4")
+	err := runFFmpeg(context.Background(), ffmpegBinary, []string{
+		"-loglevel", "error",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc=duration=%d:size=320x240:rate=25", duration),
+		"-f", "lavfi", "-i", fmt.Sprintf("sine=duration=%d", duration),
+		"-c:v", "libx264", "-g", "50", "-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-y", inputPath,
+	})
+	if err != nil {
+		t.Fatalf("unable to generate test input: %v", err)
+	}
+
+	return inputPath
+}
+
+func probeStartTime(t *testing.T, ffprobeBinary string, inputPath string) float64 {
+	t.Helper()
+
+	out, err := exec.Command(ffprobeBinary,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=start_time",
+		"-of", "csv=p=0",
+		inputPath,
+	).Output()
+	if err != nil {
+		t.Fatalf("unable to probe %s: %v", inputPath, err)
+	}
+
+	startTime, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		t.Fatalf("unable to parse start time of %s: %v", inputPath, err)
+	}
+
+	return startTime
+}
+
+// transcodes and returns paths of all produced segments
+func transcodeTestSegments(t *testing.T, ffmpegBinary string, config TranscodeConfig) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	job, err := NewEncoder(ffmpegBinary, "").Start(ctx, config)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	paths := []string{}
+	for segmentName := range job.Segments() {
+		paths = append(paths, path.Join(config.OutputDirPath, segmentName))
+	}
+
+	if err := job.Wait(); err != nil {
+		t.Fatalf("transcode error = %v", err)
+	}
+
+	return paths
+}
+
+func TestTranscodeSeekModeFirstSegmentPTS(t *testing.T) {
+	ffmpegBinary, ffprobeBinary := requireFFmpeg(t)
+	inputPath := generateTestInput(t, ffmpegBinary, 10)
+
+	config := func(seekMode SeekMode, segmentTimes []float64) TranscodeConfig {
+		return TranscodeConfig{
+			InputFilePath: inputPath,
+			OutputDirPath: t.TempDir(),
+			SegmentPrefix: "test",
+			SeekMode:      seekMode,
+			SegmentTimes:  segmentTimes,
+			VideoProfile:  &VideoProfile{Width: 320, Height: 240, Bitrate: 500},
+			AudioProfile:  &AudioProfile{Bitrate: 64},
+		}
+	}
+
+	// muxer may shift timestamps by a constant, so that it is measured from encode starting at zero
+	reference := transcodeTestSegments(t, ffmpegBinary, config(SeekInput, []float64{0, 4}))
+	offset := probeStartTime(t, ffprobeBinary, reference[0])
+
+	// start between keyframes, so that keyframe snapping would be detected
+	const startAt = 3.0
+	const frameDuration = 1.0 / 25
+
+	for _, seekMode := range []SeekMode{SeekInput, SeekOutput} {
+		segments := transcodeTestSegments(t, ffmpegBinary, config(seekMode, []float64{startAt, 5, 7}))
+		if len(segments) != 2 {
+			t.Fatalf("seek mode %d: got %d segments, want 2", seekMode, len(segments))
+		}
+
+		got := probeStartTime(t, ffprobeBinary, segments[0]) - offset
+		if math.Abs(got-startAt) > frameDuration {
+			t.Errorf("seek mode %d: first segment starts at %.3f, want %.3f", seekMode, got, startAt)
+		}
+	}
+}
+
+func TestTranscodeSegmentOffsetFilenames(t *testing.T) {
+	ffmpegBinary, ffprobeBinary := requireFFmpeg(t)
+	inputPath := generateTestInput(t, ffmpegBinary, 12)
+
+	config := func(segmentOffset int, segmentTimes []float64) TranscodeConfig {
+		return TranscodeConfig{
+			InputFilePath: inputPath,
+			OutputDirPath: t.TempDir(),
+			SegmentPrefix: "test",
+			SegmentOffset: segmentOffset,
+			SegmentTimes:  segmentTimes,
+			VideoProfile:  &VideoProfile{Width: 320, Height: 240, Bitrate: 500},
+			AudioProfile:  &AudioProfile{Bitrate: 64},
+		}
+	}
+
+	// muxer may shift timestamps by a constant, so that it is measured from encode starting at zero
+	reference := transcodeTestSegments(t, ffmpegBinary, config(0, []float64{0, 4}))
+	offset := probeStartTime(t, ffprobeBinary, reference[0])
+
+	const frameDuration = 1.0 / 25
+
+	// window of segments 5-7 of 2 seconds each, starting at 4 seconds
+	segmentTimes := []float64{4, 6, 8, 10}
+	segments := transcodeTestSegments(t, ffmpegBinary, config(5, segmentTimes))
+
+	want := []string{"test-00005.ts", "test-00006.ts", "test-00007.ts"}
+	got := []string{}
+	for _, segmentPath := range segments {
+		got = append(got, path.Base(segmentPath))
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("segments = %v, want %v", got, want)
+	}
+
+	for i, segmentPath := range segments {
+		startTime := probeStartTime(t, ffprobeBinary, segmentPath) - offset
+		if math.Abs(startTime-segmentTimes[i]) > frameDuration {
+			t.Errorf("%s starts at %.3f, want %.3f", got[i], startTime, segmentTimes[i])
+		}
+	}
+}
+
+func TestTranscodeNegativeStartTime(t *testing.T) {
+	ffmpegBinary, ffprobeBinary := requireFFmpeg(t)
+	source := generateTestInput(t, ffmpegBinary, 10)
+
+	// shift source, so that it starts before zero using an edit list
+	inputPath := path.Join(t.TempDir(), "negative.mp4")
+	err := runFFmpeg(context.Background(), ffmpegBinary, []string{
+		"-loglevel", "error",
+		"-i", source,
+		"-c", "copy",
+		"-output_ts_offset", "-1",
+		"-avoid_negative_ts", "disabled",
+		"-y", inputPath,
+	})
+	if err != nil {
+		t.Fatalf("unable to generate test input: %v", err)
+	}
+
+	if startTime := probeStartTime(t, ffprobeBinary, inputPath); startTime >= 0 {
+		t.Skipf("ffmpeg did not produce negative start time, got %.3f", startTime)
+	}
+
+	const frameDuration = 1.0 / 25
+
+	// keyframes are every 2 seconds, shifted to odd source times
+	segmentTimes := []float64{1, 3, 5, 7}
+	segments := transcodeTestSegments(t, ffmpegBinary, TranscodeConfig{
+		InputFilePath: inputPath,
+		OutputDirPath: t.TempDir(),
+		SegmentPrefix: "test",
+		SegmentTimes:  segmentTimes,
+		VideoProfile:  &VideoProfile{Width: 320, Height: 240, Bitrate: 500},
+		AudioProfile:  &AudioProfile{Bitrate: 64},
+	})
+	if len(segments) != len(segmentTimes)-1 {
+		t.Fatalf("got %d segments, want %d", len(segments), len(segmentTimes)-1)
+	}
+
+	// muxer may shift timestamps by a constant, so that boundaries are compared to the first one
+	first := probeStartTime(t, ffprobeBinary, segments[0])
+	for i, segmentPath := range segments {
+		got := probeStartTime(t, ffprobeBinary, segmentPath) - first
+		want := segmentTimes[i] - segmentTimes[0]
+		if math.Abs(got-want) > frameDuration {
+			t.Errorf("%s starts at %.3f, want %.3f", path.Base(segmentPath), got, want)
+		}
+	}
+}
+
+func TestTranscodeOutputDirPathSegmentNames(t *testing.T) {
+	ffmpegBinary, _ := requireFFmpeg(t)
+	inputPath := generateTestInput(t, ffmpegBinary, 4)
+
+	absDir := t.TempDir()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unable to get working directory: %v", err)
+	}
+
+	relDir, err := filepath.Rel(wd, t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to make relative path: %v", err)
+	}
+
+	for _, outputDirPath := range []string{absDir, relDir} {
+		segments := transcodeTestSegments(t, ffmpegBinary, TranscodeConfig{
+			InputFilePath: inputPath,
+			OutputDirPath: outputDirPath,
+			SegmentPrefix: "test",
+			SegmentTimes:  []float64{0, 2, 4},
+			VideoProfile:  &VideoProfile{Width: 320, Height: 240, Bitrate: 500},
+			AudioProfile:  &AudioProfile{Bitrate: 64},
+		})
+
+		want := []string{path.Join(outputDirPath, "test-00000.ts"), path.Join(outputDirPath, "test-00001.ts")}
+		if !reflect.DeepEqual(segments, want) {
+			t.Errorf("output %s: segments = %v, want %v", outputDirPath, segments, want)
+		}
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	ffmpegBinary, _ := requireFFmpeg(t)
+
+	result, err := Benchmark(context.Background(), ffmpegBinary, &VideoProfile{
+		Width:   320,
+		Height:  240,
+		Bitrate: 500,
+	}, 6)
+	if err != nil {
+		t.Fatalf("Benchmark() error = %v", err)
+	}
+
+	// segments every 4 seconds
+	if result.Segments != 2 {
+		t.Errorf("Benchmark() segments = %d, want 2", result.Segments)
+	}
+
+	if result.Size <= 0 || result.Metrics.Speed <= 0 {
+		t.Errorf("Benchmark() size = %d, speed = %.2f, want positive", result.Size, result.Metrics.Speed)
+	}
+}
+
+func TestTranscodeInBandParameterSets(t *testing.T) {
+	ffmpegBinary, ffprobeBinary := requireFFmpeg(t)
+	inputPath := generateTestInput(t, ffmpegBinary, 6)
+
+	segments := transcodeTestSegments(t, ffmpegBinary, TranscodeConfig{
+		InputFilePath: inputPath,
+		OutputDirPath: t.TempDir(),
+		SegmentPrefix: "test",
+		SegmentTimes:  []float64{0, 2, 4, 6},
+		VideoProfile:  &VideoProfile{Width: 320, Height: 240, Bitrate: 500},
+		AudioProfile:  &AudioProfile{Bitrate: 64},
+		// global header would move parameter sets out of the stream
+		ExtraOutputArgs:     []string{"-flags:v", "+global_header"},
+		InBandParameterSets: true,
+	})
+
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+
+	// mid-stream segment is decoded on its own, as when seeked to directly
+	segmentPath := segments[1]
+
+	_, keyframe, err := probeFirstFrame(context.Background(), ffprobeBinary, segmentPath)
+	if err != nil {
+		t.Fatalf("unable to probe first frame of %s: %v", segmentPath, err)
+	}
+
+	if !keyframe {
+		t.Errorf("%s does not start with keyframe", segmentPath)
+	}
+
+	out, err := exec.Command(ffmpegBinary,
+		"-v", "error",
+		"-i", segmentPath,
+		"-map", "0:v:0",
+		"-f", "null", "-",
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to decode %s: %v: %s", segmentPath, err, out)
+	}
+
+	if stderr := strings.TrimSpace(string(out)); stderr != "" {
+		t.Errorf("decoding %s reported errors: %s", segmentPath, stderr)
+	}
+}
+
+func TestTranscodeSingleSegment(t *testing.T) {
+	ffmpegBinary, _ := requireFFmpeg(t)
+	inputPath := generateTestInput(t, ffmpegBinary, 1)
+
+	// whole input shorter than segment duration and a single frame
+	for _, segmentTimes := range [][]float64{{0, 1}, {0, 0.04}} {
+		segments := transcodeTestSegments(t, ffmpegBinary, TranscodeConfig{
+			InputFilePath: inputPath,
+			OutputDirPath: t.TempDir(),
+			SegmentPrefix: "test",
+			SegmentTimes:  segmentTimes,
+			VideoProfile:  &VideoProfile{Width: 320, Height: 240, Bitrate: 500},
+			AudioProfile:  &AudioProfile{Bitrate: 64},
+		})
+
+		if len(segments) != 1 {
+			t.Fatalf("segment times %v produced %d segments, want 1", segmentTimes, len(segments))
+		}
+
+		stat, err := os.Stat(segments[0])
+		if err != nil {
+			t.Fatalf("unable to stat segment: %v", err)
+		}
+		if stat.Size() == 0 {
+			t.Errorf("segment times %v produced empty segment", segmentTimes)
+		}
+	}
+}
+
+func TestAutoLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		width     int
+		height    int
+		frameRate float64
+		bitrate   int
+		want      string
+	}{
+		{"360p30", 640, 360, 30, 800, "3"},
+		{"720p30", 1280, 720, 30, 3000, "3.1"},
+		{"1080p30", 1920, 1080, 30, 6000, "4"},
+		{"1080p30 high bitrate", 1920, 1080, 30, 25000, "4.1"},
+		{"1080p60", 1920, 1080, 60, 8000, "4.2"},
+		{"2160p30", 3840, 2160, 30, 20000, "5.1"},
+		{"unknown frame rate", 1920, 1080, 0, 0, "4"},
+		{"unsupported", 16384, 16384, 30, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := autoLevel(tt.width, tt.height, tt.frameRate, tt.bitrate); got != tt.want {
+				t.Errorf("autoLevel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioMetadataArgs(t *testing.T) {
+	info := AudioInfo{}
+	if got, want := info.metadataArgs(), []string{"-disposition:a:0", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("metadataArgs() = %v, want %v", got, want)
+	}
+
+	info.Tags.Language = "eng"
+	info.Tags.Title = "Director's commentary"
+	info.Disposition.Default = 1
+	info.Disposition.Forced = 1
+
+	want := []string{
+		"-metadata:s:a:0", "language=eng",
+		"-metadata:s:a:0", "title=Director's commentary",
+		"-disposition:a:0", "default+forced",
+	}
+	if got := info.metadataArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadataArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestMilestoneTrackerResumed(t *testing.T) {
+	var reached []Milestone
+	hook := func(event MilestoneEvent) {
+		if event.Total != 4 {
+			t.Errorf("milestone %s total = %d, want 4", event.Milestone, event.Total)
+		}
+		reached = append(reached, event.Milestone)
+	}
+
+	// two of four segments were skipped, the remaining ones are encoded
+	tracker := newMilestoneTracker(hook, time.Now(), 4, []float64{8, 12, 16})
+	tracker.progress(9)
+	tracker.segmentFinished(3)
+
+	want := []Milestone{MilestoneDecodingStarted, MilestoneFirstKeyframeForced, MilestoneHalfway}
+	if !reflect.DeepEqual(reached, want) {
+		t.Fatalf("reached %v, want %v", reached, want)
+	}
+
+	tracker.progress(16)
+	if last := reached[len(reached)-1]; last != MilestoneFinalizing {
+		t.Errorf("reached %v after progress to the end, want %v", last, MilestoneFinalizing)
+	}
+}
+
+func TestBuildArgsLevelWideSource(t *testing.T) {
+	// 2.39:1 source fitted into 720p is 1720x720, that exceeds frame size limit of level 3.1
+	input := inputInfo{Video: &VideoInfo{Width: 1920, Height: 804, AvgFrameRate: "30/1"}}
+
+	tests := []struct {
+		name      string
+		profile   VideoProfile
+		wantLevel string
+		wantErr   error
+	}{
+		{"auto frame size", VideoProfile{Width: 1280, Height: 720, Bitrate: 3000, Level: LevelAuto}, "3.2", nil},
+		{"auto resolution tier", VideoProfile{Resolution: Res720p, Bitrate: 3000, Level: LevelAuto}, "3.2", nil},
+		{"explicit level too low", VideoProfile{Width: 1280, Height: 720, Bitrate: 3000, Level: "3.1"}, "", ErrInvalidVideoProfile},
+		{"bumped level", VideoProfile{Width: 1280, Height: 720, Bitrate: 3000, Level: "3.1", BumpLevel: true}, "3.2", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := tt.profile
+			args, err := buildArgs(TranscodeConfig{
+				InputFilePath: "input.mp4",
+				SegmentTimes:  []float64{0, 4, 8},
+				VideoProfile:  &profile,
+			}, input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("buildArgs() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+
+			if got := argValue(args, "-level:v"); got != tt.wantLevel {
+				t.Errorf("buildArgs() -level:v = %q, want %q", got, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"34000/50000", 0.68},
+		{"10000000/10000", 1000},
+		{"0.5", 0.5},
+		{"1/0", 0},
+		{"", 0},
+		{"a/b", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRational(tt.value); got != tt.want {
+			t.Errorf("parseRational(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHDRMetadataFromSideData(t *testing.T) {
+	if got := hdrMetadataFromSideData([]frameSideData{{SideDataType: "Display Matrix"}}); got != nil {
+		t.Errorf("hdrMetadataFromSideData() = %+v, want nil", got)
+	}
+
+	got := hdrMetadataFromSideData([]frameSideData{
+		{
+			SideDataType: "Mastering display metadata",
+			RedX:         "34000/50000", RedY: "16000/50000",
+			GreenX: "13250/50000", GreenY: "34500/50000",
+			BlueX: "7500/50000", BlueY: "3000/50000",
+			WhiteX: "15635/50000", WhiteY: "16450/50000",
+			MinLuminance: "50/10000", MaxLuminance: "10000000/10000",
+		},
+		{SideDataType: "Content light level metadata", MaxContent: 1000, MaxAverage: 400},
+	})
+
+	want := &HDRMetadata{
+		MasteringDisplay: &MasteringDisplay{
+			RedX: 0.68, RedY: 0.32,
+			GreenX: 0.265, GreenY: 0.69,
+			BlueX: 0.15, BlueY: 0.06,
+			WhiteX: 0.3127, WhiteY: 0.329,
+			MinLuminance: 0.005, MaxLuminance: 1000,
+		},
+		MaxCLL:  1000,
+		MaxFALL: 400,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hdrMetadataFromSideData() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildManifestRemovedSegments(t *testing.T) {
+	// uploaded segments are no longer in the output path
+	config := TranscodeConfig{OutputDirPath: t.TempDir(), SegmentTimes: []float64{0, 4, 8}}
+	segments := []string{"seg-00000.ts", "seg-00001.ts"}
+	events := map[string]SegmentEvent{
+		"seg-00000.ts": {Name: "seg-00000.ts", Size: 500000, Checksum: "a"},
+		"seg-00001.ts": {Name: "seg-00001.ts", Size: 1000000, Checksum: "b"},
+	}
+
+	manifest := buildManifest(&config, config.SegmentTimes, inputInfo{}, segments, events, nil)
+	for i, segment := range manifest.Segments {
+		if want := events[segments[i]]; segment.Size != want.Size || segment.Checksum != want.Checksum {
+			t.Errorf("segment %s size = %d, checksum = %q, want %d, %q", segment.Name, segment.Size, segment.Checksum, want.Size, want.Checksum)
+		}
+	}
+
+	var metrics EncodeMetrics
+	metrics.addSegmentBitrates(segments, events, config.SegmentTimes)
+	if want := []float64{1000, 2000}; !reflect.DeepEqual(metrics.SegmentBitrates, want) {
+		t.Errorf("addSegmentBitrates() = %v, want %v", metrics.SegmentBitrates, want)
+	}
+}
+
+func TestMediaPlaylistParts(t *testing.T) {
+	playlist := MediaPlaylist([]float64{0, 4, 8}, func(index int) string {
+		return fmt.Sprintf("seg-%d.ts", index)
+	}, MediaPlaylistOptions{
+		TargetDuration: 4,
+		PartDuration:   1,
+		PartName: func(index, part int) string {
+			return fmt.Sprintf("seg-%d.%d.ts", index, part)
+		},
+	})
+
+	for _, tag := range []string{
+		"#EXT-X-VERSION:9\n",
+		"#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=3.000\n",
+		"#EXT-X-PART-INF:PART-TARGET=1.000\n",
+	} {
+		if !strings.Contains(playlist, tag) {
+			t.Errorf("MediaPlaylist() has no %q:\n%s", strings.TrimSpace(tag), playlist)
+		}
+	}
+
+	playlist = MediaPlaylist([]float64{0, 4, 8}, func(index int) string {
+		return fmt.Sprintf("seg-%d.ts", index)
+	}, MediaPlaylistOptions{TargetDuration: 4})
+
+	if !strings.Contains(playlist, "#EXT-X-VERSION:4\n") || strings.Contains(playlist, "#EXT-X-SERVER-CONTROL") {
+		t.Errorf("MediaPlaylist() without parts:\n%s", playlist)
+	}
+}
+
+func TestStrategySegmentTimes(t *testing.T) {
+	videoInfo := &VideoInfo{AvgFrameRate: "25/1"}
+
+	tests := []struct {
+		name   string
+		config TranscodeConfig
+		want   []float64
+	}{
+		{"by time", TranscodeConfig{SegmentTimes: []float64{0, 4, 10}}, []float64{0, 4, 10}},
+		{"by frames", TranscodeConfig{
+			SegmentTimes:    []float64{0, 10},
+			SegmentStrategy: SegmentByFrames,
+			SegmentFrames:   100,
+		}, []float64{0, 4, 8, 10}},
+		{"by size", TranscodeConfig{
+			SegmentTimes:    []float64{0, 10},
+			SegmentStrategy: SegmentBySize,
+			SegmentSize:     500000,
+			VideoProfile:    &VideoProfile{Bitrate: 1000},
+		}, []float64{0, 4, 8, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strategySegmentTimes(&tt.config, tt.config.SegmentTimes, videoInfo)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("strategySegmentTimes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSegmentTimes(t *testing.T) {
+	tests := []struct {
+		name         string
+		segmentTimes []float64
+		chunks       int
+		want         [][]float64
+	}{
+		{"single chunk", []float64{0, 4, 8}, 1, [][]float64{{0, 4, 8}}},
+		{"more chunks than segments", []float64{0, 4, 8}, 5, [][]float64{{0, 4}, {4, 8}}},
+		{"even split", []float64{0, 1, 2, 3, 4}, 2, [][]float64{{0, 1, 2}, {2, 3, 4}}},
+		{"uneven split", []float64{0, 1, 2, 3, 4, 5, 6, 7}, 3, [][]float64{{0, 1, 2}, {2, 3, 4}, {4, 5, 6, 7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitSegmentTimes(tt.segmentTimes, tt.chunks)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitSegmentTimes() = %v, want %v", got, tt.want)
+			}
+
+			// neighbouring windows share the boundary time
+			for i := 1; i < len(got); i++ {
+				if got[i-1][len(got[i-1])-1] != got[i][0] {
+					t.Errorf("window %d starts at %v, previous ends at %v", i, got[i][0], got[i-1][len(got[i-1])-1])
+				}
+			}
+		})
+	}
+}
+
+func TestMergeManifests(t *testing.T) {
+	first := &Manifest{
+		InputFilePath: "input.mp4",
+		SegmentOffset: 10,
+		Start:         40,
+		Duration:      8,
+		Segments:      []ManifestSegment{{Name: "seg-10.ts", Duration: 4}, {Name: "seg-11.ts", Duration: 4}},
+		Metrics:       &EncodeMetrics{Speed: 2},
+		Warnings:      []ManifestWarning{{Kind: "upscale"}},
+	}
+	second := &Manifest{
+		InputFilePath: "input.mp4",
+		SegmentOffset: 12,
+		Start:         48,
+		Duration:      6,
+		Segments:      []ManifestSegment{{Name: "seg-12.ts", Duration: 6}},
+		Metrics:       &EncodeMetrics{Speed: 3},
+		Warnings:      []ManifestWarning{{Kind: "segment_duration"}},
+	}
+
+	merged := mergeManifests([]*Manifest{first, second})
+
+	want := &Manifest{
+		InputFilePath: "input.mp4",
+		SegmentOffset: 10,
+		Start:         40,
+		Duration:      14,
+		Segments:      []ManifestSegment{{Name: "seg-10.ts", Duration: 4}, {Name: "seg-11.ts", Duration: 4}, {Name: "seg-12.ts", Duration: 6}},
+		Warnings:      []ManifestWarning{{Kind: "upscale"}, {Kind: "segment_duration"}},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergeManifests() = %+v, want %+v", merged, want)
+	}
+
+	// chunk manifests are left intact
+	if len(first.Segments) != 2 || first.Duration != 8 {
+		t.Errorf("mergeManifests() modified the first manifest: %+v", first)
+	}
+}
+
+func TestValidateRenditionName(t *testing.T) {
+	for _, name := range []string{"720p", "high-bitrate", "a.b"} {
+		if err := validateRenditionName(name); err != nil {
+			t.Errorf("validateRenditionName(%q) error = %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "a/b", "../720p", "/720p", "720p/", "a\\b"} {
+		if err := validateRenditionName(name); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("validateRenditionName(%q) error = %v, want %v", name, err, ErrInvalidConfig)
+		}
+	}
+}
+
+func TestDerivedLogFilePath(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"/logs/ffmpeg.log":   "/logs/ffmpeg-0.log",
+		"/logs/ffmpeg":       "/logs/ffmpeg-0",
+		"/logs.d/ffmpeg":     "/logs.d/ffmpeg-0",
+		"/logs/ffmpeg.1.txt": "/logs/ffmpeg.1-0.txt",
+	}
+
+	for logFilePath, want := range tests {
+		config := TranscodeConfig{LogFilePath: logFilePath}
+		if got := config.derivedLogFilePath("0"); got != want {
+			t.Errorf("derivedLogFilePath(%q) = %q, want %q", logFilePath, got, want)
+		}
+	}
+}
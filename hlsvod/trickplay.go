@@ -0,0 +1,89 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// GenerateTrickPlay extracts sprite sheets and writes WebVTT thumbnail track next to them,
+// cues map time ranges to sprite regions using #xywh fragments. Every cue covers one
+// interval and the last one ends at duration, that should be end time of the last segment,
+// so that previews align with the playlist. Returns path of the written track.
+func GenerateTrickPlay(ctx context.Context, ffmpegBinary string, inputPath string, duration float64, opts SpriteOptions) (string, error) {
+	if duration <= 0 {
+		return "", fmt.Errorf("%w: trick play duration must be positive", ErrInvalidThumbnailOptions)
+	}
+
+	sprites, err := ExtractSprites(ctx, ffmpegBinary, inputPath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sprites) == 0 {
+		return "", fmt.Errorf("no sprites were extracted")
+	}
+
+	// tile filter always outputs full grid, so that all sheets have the same size
+	tileWidth, tileHeight, err := spriteTileSize(sprites[0], opts.Columns, opts.Rows)
+	if err != nil {
+		return "", err
+	}
+
+	perSheet := opts.Columns * opts.Rows
+	count := int(math.Ceil(duration / opts.Interval))
+	if max := len(sprites) * perSheet; count > max {
+		count = max
+	}
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	for i := 0; i < count; i++ {
+		start := float64(i) * opts.Interval
+		end := math.Min(start+opts.Interval, duration)
+
+		tile := i % perSheet
+		x := (tile % opts.Columns) * tileWidth
+		y := (tile / opts.Columns) * tileHeight
+
+		fmt.Fprintf(&b, "\n%s --> %s\n", formatVTTTime(start), formatVTTTime(end))
+		fmt.Fprintf(&b, "%s#xywh=%d,%d,%d,%d\n", filepath.Base(sprites[i/perSheet]), x, y, tileWidth, tileHeight)
+	}
+
+	vttPath := path.Join(opts.OutputDirPath, opts.Prefix+".vtt")
+	if err := writeFileAtomic(vttPath, []byte(b.String())); err != nil {
+		return "", err
+	}
+
+	return vttPath, nil
+}
+
+// returns size of a single thumbnail in the sprite sheet
+func spriteTileSize(spritePath string, columns, rows int) (width, height int, err error) {
+	file, err := os.Open(spritePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read sprite size: %w", err)
+	}
+
+	return config.Width / columns, config.Height / rows, nil
+}
+
+// formats seconds as WebVTT timestamp, e.g. 01:02:03.456
+func formatVTTTime(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -13,6 +13,7 @@ type Config struct {
 	VideoProfile   *VideoProfile
 	VideoKeyframes bool
 	AudioProfile   *AudioProfile
+	Encryption     *Encryption
 
 	Cache    bool
 	CacheDir string // If not empty, cache will folder will be used instead of media path
@@ -21,6 +22,12 @@ type Config struct {
 	FFprobeBinary string
 }
 
+// Transcoder starts transcode jobs, it is implemented by Encoder
+// and by FakeTranscoder, that can be used in tests without ffmpeg.
+type Transcoder interface {
+	Start(ctx context.Context, config TranscodeConfig) (*Job, error)
+}
+
 type Manager interface {
 	Start() error
 	Stop()
@@ -0,0 +1,228 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// Uploader stores objects in object storage, it is implemented by adapters of storage
+// clients, e.g. S3 or GCS, so that this package does not depend on any of them.
+type Uploader interface {
+	// Upload stores the object under the key, replacing the existing one. Body is
+	// opened again for every attempt, so that it does not need to be seekable.
+	Upload(ctx context.Context, key string, body io.Reader, size int64, meta ObjectMetadata) error
+	Delete(ctx context.Context, key string) error
+}
+
+// ObjectMetadata are headers served with the object.
+type ObjectMetadata struct {
+	ContentType  string
+	CacheControl string
+}
+
+// UploadOptions configure pushing of finished segments to object storage.
+type UploadOptions struct {
+	Uploader  Uploader
+	KeyPrefix string // Prepended to segment names, e.g. videos/123/.
+
+	// Content type by file name, e.g. video/mp2t for .ts, derived from extension when nil.
+	ContentType func(name string) string
+	// Cache-Control of segments, that never change, and of the playlist, that is written
+	// by HLS muxer once the encode finishes.
+	CacheControl         string
+	PlaylistCacheControl string
+
+	Retries    int           // Additional attempts of failed upload.
+	RetryDelay time.Duration // Delay before the first retry, doubled for every next one, 1s when zero.
+
+	// Delete objects uploaded by the failed encode, so that incomplete output is not left behind.
+	CleanupOnFailure bool
+}
+
+const defaultUploadRetryDelay = time.Second
+
+func (options *UploadOptions) validate(config *TranscodeConfig) error {
+	if options.Uploader == nil {
+		return fmt.Errorf("%w: uploader must be set", ErrInvalidConfig)
+	}
+
+	if options.Retries < 0 || options.RetryDelay < 0 {
+		return fmt.Errorf("%w: upload retries and delay must not be negative", ErrInvalidConfig)
+	}
+
+	// segments are removed from the output path before they could be uploaded
+	if config.SegmentSink != nil {
+		return fmt.Errorf("%w: upload cannot be used with segment sink", ErrInvalidConfig)
+	}
+
+	// uploaded parts are removed, before they are assembled into the segment
+	if config.PartDuration > 0 {
+		return fmt.Errorf("%w: upload cannot be used with partial segments", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// returns content type of HLS and DASH files by their extension
+func segmentContentType(name string) string {
+	switch path.Ext(name) {
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// uploads the file with retries and removes the local copy once it is stored
+func (options *UploadOptions) upload(ctx context.Context, filePath string, cacheControl string) error {
+	name := path.Base(filePath)
+
+	contentType := segmentContentType
+	if options.ContentType != nil {
+		contentType = options.ContentType
+	}
+
+	meta := ObjectMetadata{
+		ContentType:  contentType(name),
+		CacheControl: cacheControl,
+	}
+
+	delay := options.RetryDelay
+	if delay == 0 {
+		delay = defaultUploadRetryDelay
+	}
+
+	var err error
+	for attempt := 0; attempt <= options.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+				delay *= 2
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err = options.uploadFile(ctx, filePath, name, meta); err == nil {
+			return os.Remove(filePath)
+		}
+	}
+
+	return fmt.Errorf("unable to upload %s: %w", name, err)
+}
+
+func (options *UploadOptions) uploadFile(ctx context.Context, filePath string, name string, meta ObjectMetadata) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return options.Uploader.Upload(ctx, options.KeyPrefix+name, file, stat.Size(), meta)
+}
+
+// StartUpload starts transcode, that uploads every finished segment to object storage and
+// removes its local copy, before its name is delivered on the channel, so that OutputDirPath
+// is only a scratch space. Playlist written by HLS muxer is uploaded once the encode succeeds.
+// Failed upload, after retries, stops the encode.
+func (e *Encoder) StartUpload(ctx context.Context, config TranscodeConfig, options UploadOptions) (*Job, error) {
+	e.applyDefaults(&config)
+
+	if err := options.validate(&config); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	exitHook := config.ExitHook
+	config.ExitHook = nil
+
+	innerConfig := config
+	innerConfig.segmentsRemoved = true
+
+	inner, err := e.Start(ctx, innerConfig)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	job := newJob(inner.total)
+	job.cancel = cancel
+
+	produced := make(chan string)
+	go forwardSegments(produced, job.segments)
+
+	go func() {
+		defer cancel()
+
+		uploaded := []string{}
+
+		var err error
+		for segmentName := range inner.Segments() {
+			// drain remaining segments, so that the inner job can finish
+			if err != nil {
+				continue
+			}
+
+			if err = options.upload(ctx, path.Join(config.OutputDirPath, segmentName), options.CacheControl); err != nil {
+				inner.Cancel()
+				continue
+			}
+
+			uploaded = append(uploaded, segmentName)
+			produced <- segmentName
+
+			// only media segments are counted
+			if !IsInitSegment(segmentName) && !IsPartialSegment(segmentName) {
+				job.segmentEncoded()
+			}
+		}
+		close(produced)
+
+		// upload error is the root cause of cancelled encode
+		if innerErr := inner.Wait(); err == nil {
+			err = innerErr
+		}
+
+		if err == nil && config.Muxer == MuxerHLS {
+			err = options.upload(ctx, config.playlistPath(), options.PlaylistCacheControl)
+		}
+
+		if err != nil && options.CleanupOnFailure {
+			// encode context may be cancelled already
+			cleanupCtx := context.Background()
+			for _, segmentName := range uploaded {
+				if deleteErr := options.Uploader.Delete(cleanupCtx, options.KeyPrefix+segmentName); deleteErr != nil {
+					e.logger.Warn().Err(deleteErr).Str("segment", segmentName).Msg("unable to delete uploaded segment")
+				}
+			}
+		}
+
+		if err == nil {
+			job.manifest = inner.Manifest()
+		}
+
+		if exitHook != nil {
+			exitHook(err)
+		}
+
+		job.finish(err)
+	}()
+
+	return job, nil
+}
@@ -1,8 +1,14 @@
 package hlsvod
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -54,20 +60,237 @@ func convertToSegments(rawTimeList []float64, duration time.Duration, segmentLen
 	return append(segmentStartTimes, durationSec)
 }
 
+type MediaPlaylistOptions struct {
+	TargetDuration float64
+	Encryption     *Encryption
+
+	// Wall-clock time of the first breakpoint, if set, every segment is
+	// tagged with #EXT-X-PROGRAM-DATE-TIME computed from breakpoints.
+	ProgramDateTime time.Time
+
+	// Low-latency HLS part duration, if set, parts given by PartTimes
+	// are listed using #EXT-X-PART before every segment.
+	PartDuration float64
+	PartName     func(index, part int) string
+
+	// Number of segments, that are already available. If set and lower than number of
+	// segments, playlist is still growing, so that it is not ended and the first part
+	// of the next segment is announced using #EXT-X-PRELOAD-HINT.
+	AvailableSegments int
+
+	// Ranges of segments within single combined file, see CombineSegments. If set, every
+	// segment is listed using #EXT-X-BYTERANGE, segment name should return the combined file.
+	ByteRanges []SegmentRange
+
+	// Indexes of segments, that start a discontinuity, e.g. because encoding parameters
+	// changed, they are preceded by #EXT-X-DISCONTINUITY, see TranscodeConfig.Discontinuity.
+	Discontinuities []int
+}
+
+const programDateTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// MediaPlaylist creates VOD playlist from segment breakpoints, segment
+// at index i spans from breakpoints[i] to breakpoints[i+1].
+func MediaPlaylist(breakpoints []float64, segmentName func(index int) string, opts MediaPlaylistOptions) string {
+	segments := len(breakpoints) - 1
+	ended := opts.AvailableSegments <= 0 || opts.AvailableSegments >= segments
+	if !ended {
+		segments = opts.AvailableSegments
+	}
+
+	playlistType := "VOD"
+	if !ended {
+		playlistType = "EVENT"
+	}
+
+	// partial segments are Low-Latency HLS, that requires version 9
+	version := 4
+	if opts.PartDuration > 0 {
+		version = 9
+	}
+
+	// playlist prefix
+	playlist := []string{
+		"#EXTM3U",
+		fmt.Sprintf("#EXT-X-VERSION:%d", version),
+		"#EXT-X-PLAYLIST-TYPE:" + playlistType,
+		"#EXT-X-MEDIA-SEQUENCE:0",
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%.2f", opts.TargetDuration),
+	}
+
+	var parts [][]float64
+	if opts.PartDuration > 0 {
+		parts = PartTimes(breakpoints, opts.PartDuration)
+
+		// part hold back is required with parts, at least three part targets
+		playlist = append(playlist,
+			fmt.Sprintf("#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=%.3f", opts.PartDuration*3),
+			fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f", opts.PartDuration),
+		)
+	}
+
+	if opts.Encryption != nil {
+		playlist = append(playlist, opts.Encryption.playlistTag())
+	}
+
+	discontinuities := map[int]bool{}
+	for _, index := range opts.Discontinuities {
+		discontinuities[index] = true
+	}
+
+	// playlist segments
+	for i := 1; i <= segments; i++ {
+		if discontinuities[i-1] {
+			playlist = append(playlist, "#EXT-X-DISCONTINUITY")
+		}
+
+		if !opts.ProgramDateTime.IsZero() {
+			offset := time.Duration((breakpoints[i-1] - breakpoints[0]) * float64(time.Second))
+			playlist = append(playlist,
+				"#EXT-X-PROGRAM-DATE-TIME:"+opts.ProgramDateTime.Add(offset).Format(programDateTimeFormat),
+			)
+		}
+
+		// only the first part starts with a keyframe
+		if parts != nil {
+			for j, times := 1, parts[i-1]; j < len(times); j++ {
+				part := fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=%q", times[j]-times[j-1], opts.PartName(i-1, j-1))
+				if j == 1 {
+					part += ",INDEPENDENT=YES"
+				}
+				playlist = append(playlist, part)
+			}
+		}
+
+		playlist = append(playlist,
+			fmt.Sprintf("#EXTINF:%.3f, no desc", breakpoints[i]-breakpoints[i-1]),
+		)
+
+		if i-1 < len(opts.ByteRanges) {
+			byteRange := opts.ByteRanges[i-1]
+			playlist = append(playlist, fmt.Sprintf("#EXT-X-BYTERANGE:%d@%d", byteRange.Length, byteRange.Start))
+		}
+
+		playlist = append(playlist, segmentName(i-1))
+	}
+
+	// playlist suffix
+	if ended {
+		playlist = append(playlist,
+			"#EXT-X-ENDLIST",
+		)
+	} else if parts != nil {
+		playlist = append(playlist,
+			fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=%q", opts.PartName(segments, 0)),
+		)
+	}
+
+	// join with newlines
+	return strings.Join(playlist, "\n") + "\n"
+}
+
+// ParsePlaylistSegmentTimes returns segment times of existing media playlist, e.g. of another
+// rendition, so that it can be encoded with the same segment boundaries. The first time is zero
+// and every #EXTINF duration is added to the previous time. Master playlists are rejected.
+func ParsePlaylistSegmentTimes(m3u8 io.Reader) ([]float64, error) {
+	scanner := bufio.NewScanner(m3u8)
+
+	segmentTimes := []float64{0}
+	header := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case !header:
+			if line != "#EXTM3U" {
+				return nil, fmt.Errorf("%w: missing #EXTM3U header", ErrInvalidPlaylist)
+			}
+			header = true
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			return nil, fmt.Errorf("%w: master playlist has no segments", ErrInvalidPlaylist)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<duration>,[<title>]
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			duration, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || duration <= 0 {
+				return nil, fmt.Errorf("%w: invalid segment duration %q", ErrInvalidPlaylist, value)
+			}
+
+			// rounded, so that summing does not accumulate float errors
+			segmentTime := segmentTimes[len(segmentTimes)-1] + duration
+			segmentTimes = append(segmentTimes, math.Round(segmentTime*1e6)/1e6)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !header {
+		return nil, fmt.Errorf("%w: playlist is empty", ErrInvalidPlaylist)
+	}
+
+	if len(segmentTimes) < 2 {
+		return nil, fmt.Errorf("%w: playlist has no segments", ErrInvalidPlaylist)
+	}
+
+	return segmentTimes, nil
+}
+
+// AudioRendition is audio-only media playlist, that is shared by all video renditions.
+type AudioRendition struct {
+	Name      string // Human readable name, e.g. English.
+	Language  string // Optional RFC 5646 tag, e.g. en.
+	URI       string // Media playlist of the rendition.
+	Bandwidth int    // Peak bitrate in bits per second, added to bandwidth of video renditions.
+	Default   bool   // Played unless user selects other rendition.
+}
+
+// audio group referenced by video renditions
+const audioGroupID = "audio"
+
 func StreamsPlaylist(profiles map[string]VideoProfile, segmentNameFmt string) string {
+	return StreamsPlaylistWithAudio(profiles, segmentNameFmt, nil)
+}
+
+// StreamsPlaylistWithAudio creates master playlist, where video renditions without audio
+// reference audio renditions using #EXT-X-MEDIA group, see StreamsVideoOnly and StreamsAudioOnly.
+// Renditions must be encoded using the same segment times, so that they stay in sync.
+func StreamsPlaylistWithAudio(profiles map[string]VideoProfile, segmentNameFmt string, audio []AudioRendition) string {
 	layers := []struct {
 		Bitrate int
 		Entries []string
 	}{}
 
+	// variant bandwidth must cover the highest audio rendition
+	audioBandwidth := 0
+	for _, rendition := range audio {
+		if rendition.Bandwidth > audioBandwidth {
+			audioBandwidth = rendition.Bandwidth
+		}
+	}
+
 	for name, profile := range profiles {
+		width, height := profile.Width, profile.Height
+		if profile.Resolution != 0 {
+			width, height = profile.Resolution.Dimensions()
+		}
+
+		// BANDWIDTH is in bits per second, profile bitrate in kbit/s
+		streamInf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=%s", profile.Bitrate*1000+audioBandwidth, width, height, name)
+		if len(audio) > 0 {
+			streamInf += fmt.Sprintf(",AUDIO=%q", audioGroupID)
+		}
+
 		layers = append(layers, struct {
 			Bitrate int
 			Entries []string
 		}{
 			profile.Bitrate,
 			[]string{
-				fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=%s", profile.Bitrate, profile.Width, profile.Height, name),
+				streamInf,
 				fmt.Sprintf(segmentNameFmt, name),
 			},
 		})
@@ -81,6 +304,20 @@ func StreamsPlaylist(profiles map[string]VideoProfile, segmentNameFmt string) st
 	// playlist prefix
 	playlist := []string{"#EXTM3U"}
 
+	// audio renditions
+	for _, rendition := range audio {
+		media := fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=%q,NAME=%q", audioGroupID, rendition.Name)
+		if rendition.Language != "" {
+			media += fmt.Sprintf(",LANGUAGE=%q", rendition.Language)
+		}
+		if rendition.Default {
+			media += ",DEFAULT=YES"
+		}
+		media += fmt.Sprintf(",AUTOSELECT=YES,URI=%q", rendition.URI)
+
+		playlist = append(playlist, media)
+	}
+
 	// playlist segments
 	for _, profile := range layers {
 		playlist = append(playlist, profile.Entries...)
@@ -89,3 +326,24 @@ func StreamsPlaylist(profiles map[string]VideoProfile, segmentNameFmt string) st
 	// join with newlines
 	return strings.Join(playlist, "\n") + "\n"
 }
+
+// writes to temporary file first, so that file is replaced atomically
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
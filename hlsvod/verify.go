@@ -0,0 +1,196 @@
+package hlsvod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Probes durations of produced segments and reports those, that deviate from requested segment
+// times by more than the tolerance, returns number of deviating segments. Encrypted segments
+// cannot be probed and are not verified.
+func (e *Encoder) verifySegmentDurations(ctx context.Context, config *TranscodeConfig, segments []string) int {
+	deviating := 0
+
+	for i, segmentName := range segments {
+		if i+1 >= len(config.SegmentTimes) {
+			break
+		}
+
+		expected := config.SegmentTimes[i+1] - config.SegmentTimes[i]
+
+		duration, err := probeSegmentDuration(ctx, e.ffprobeBinary, path.Join(config.OutputDirPath, segmentName))
+		if err != nil {
+			config.warn(Warning{
+				Kind:    WarningProbeFailed,
+				Message: "could not probe segment duration",
+				Segment: segmentName,
+				Err:     err,
+			})
+			continue
+		}
+
+		if math.Abs(duration-expected) > config.SegmentDurationTolerance {
+			deviating++
+			config.warn(Warning{
+				Kind:    WarningSegmentDuration,
+				Message: fmt.Sprintf("segment duration %.3fs deviates from requested %.3fs", duration, expected),
+				Segment: segmentName,
+			})
+		}
+	}
+
+	return deviating
+}
+
+// how far can the first frame of a segment be from its requested time, the segment muxer
+// splits at keyframes up to segmentTimeDelta before the split time
+const keyframeAlignmentTolerance = segmentTimeDelta
+
+// Probes the first video frame of every produced segment and reports those, that do not start
+// with a keyframe at approximately the requested time, returns names of misaligned segments.
+// MPEG-TS timestamps are shifted by the muxer, so that times are compared relative to the
+// first segment.
+func (e *Encoder) verifySegmentKeyframes(ctx context.Context, config *TranscodeConfig, segments []string) map[string]bool {
+	misaligned := map[string]bool{}
+
+	var firstPTS float64
+	for i, segmentName := range segments {
+		if i+1 >= len(config.SegmentTimes) {
+			break
+		}
+
+		ptsTime, keyframe, err := probeFirstFrame(ctx, e.ffprobeBinary, path.Join(config.OutputDirPath, segmentName))
+		if err != nil {
+			config.warn(Warning{
+				Kind:    WarningProbeFailed,
+				Message: "could not probe first frame of segment",
+				Segment: segmentName,
+				Err:     err,
+			})
+
+			// relative times cannot be verified without the first segment
+			if i == 0 {
+				return misaligned
+			}
+			continue
+		}
+
+		if i == 0 {
+			firstPTS = ptsTime
+		}
+
+		expected := config.SegmentTimes[i] - config.SegmentTimes[0]
+		offset := ptsTime - firstPTS - expected
+
+		message := ""
+		if !keyframe {
+			message = "segment does not start with keyframe"
+		} else if math.Abs(offset) > keyframeAlignmentTolerance {
+			message = fmt.Sprintf("segment starts %+.3fs from requested time", offset)
+		}
+
+		if message != "" {
+			misaligned[segmentName] = true
+			config.warn(Warning{
+				Kind:    WarningKeyframeMisaligned,
+				Message: message,
+				Segment: segmentName,
+			})
+		}
+	}
+
+	return misaligned
+}
+
+// returns presentation time of the first video frame and whether it is a keyframe
+func probeFirstFrame(ctx context.Context, ffprobeBinary string, segmentPath string) (float64, bool, error) {
+	output, stderr, err := runFFprobe(ctx, ffprobeBinary, nil,
+		"-v", "error",
+		"-select_streams", "V:0",
+		"-read_intervals", "%+#1", // Only the first frame.
+		"-show_entries", "frame=pts_time,key_frame",
+		"-of", "json",
+		segmentPath,
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to run ffprobe: %w (%s)", err, strings.TrimSpace(stderr))
+	}
+
+	out := struct {
+		Frames []struct {
+			PtsTime  string `json:"pts_time"`
+			KeyFrame int    `json:"key_frame"`
+		} `json:"frames"`
+	}{}
+
+	if err := json.Unmarshal(output, &out); err != nil {
+		return 0, false, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	if len(out.Frames) == 0 {
+		return 0, false, ErrNoVideoStream
+	}
+
+	ptsTime, err := strconv.ParseFloat(out.Frames[0].PtsTime, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to parse frame time: %w", err)
+	}
+
+	return ptsTime, out.Frames[0].KeyFrame == 1, nil
+}
+
+// ReencodeSegments encodes again the given segments of a finished encode, e.g. those with
+// misaligned keyframes, see ManifestSegment.KeyframeMisaligned. Every segment is encoded
+// separately using the same config, so that it starts with a keyframe, and replaces the
+// existing segment, video is never copied. Segments are numbered from SegmentOffset.
+func (e *Encoder) ReencodeSegments(ctx context.Context, config TranscodeConfig, sequences []int) error {
+	e.applyDefaults(&config)
+
+	if config.VideoProfile != nil {
+		profile := *config.VideoProfile
+		profile.CopyCompatible = false
+		config.VideoProfile = &profile
+	}
+
+	segmentTimes := config.SegmentTimes
+	for _, sequence := range sequences {
+		i := sequence - config.SegmentOffset
+		if i < 0 || i+1 >= len(segmentTimes) {
+			return fmt.Errorf("%w: segment %d is out of segment times", ErrInvalidConfig, sequence)
+		}
+
+		segmentConfig := config
+		segmentConfig.SegmentTimes = segmentTimes[i : i+2]
+		segmentConfig.SegmentOffset = sequence
+		// existing segment is replaced, it must be neither skipped nor rejected
+		segmentConfig.Resume = false
+		segmentConfig.Overwrite = OverwriteAlways
+		segmentConfig.Append = nil
+		segmentConfig.ManifestPath = ""
+		// HLS muxer would replace the playlist of the whole encode, segment names are the same
+		segmentConfig.Muxer = MuxerSegment
+		segmentConfig.PlaylistPath = ""
+		segmentConfig.LogFilePath = config.derivedLogFilePath(fmt.Sprintf("segment%d", sequence))
+		segmentConfig.VerifyKeyframes = false
+
+		job, err := e.Start(ctx, segmentConfig)
+		if err != nil {
+			return err
+		}
+
+		// names are delivered on the channel, that must be drained
+		for range job.Segments() {
+		}
+
+		if err := job.Wait(); err != nil {
+			return fmt.Errorf("unable to reencode segment %d: %w", sequence, err)
+		}
+	}
+
+	return nil
+}
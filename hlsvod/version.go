@@ -0,0 +1,105 @@
+package hlsvod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// VersionInfo describes ffmpeg build, that produced the output.
+type VersionInfo struct {
+	Version       string            `json:"version"`            // e.g. 6.0 or N-111111-g0123456789
+	Compiler      string            `json:"compiler,omitempty"` // e.g. gcc 12.2.0
+	Configuration []string          `json:"configuration,omitempty"`
+	Libraries     map[string]string `json:"libraries,omitempty"` // Runtime versions, e.g. libavcodec: 60.3.100
+}
+
+// HasFlag returns whether ffmpeg was configured with given flag, e.g. --enable-libx264.
+func (info *VersionInfo) HasFlag(flag string) bool {
+	for _, f := range info.Configuration {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses output of ffmpeg -version, e.g.
+//
+//	ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers
+//	built with gcc 12.2.0 (Debian 12.2.0-14)
+//	configuration: --prefix=/usr --enable-gpl --enable-libx264
+//	libavutil      58.  2.100 / 58.  2.100
+//	libavcodec     60.  3.100 / 60.  3.100
+func parseVersion(output string) (*VersionInfo, error) {
+	info := &VersionInfo{Libraries: map[string]string{}}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "ffmpeg version "):
+			if fields := strings.Fields(line); len(fields) >= 3 {
+				info.Version = fields[2]
+			}
+		case strings.HasPrefix(line, "built with "):
+			info.Compiler = strings.TrimPrefix(line, "built with ")
+		case strings.HasPrefix(line, "configuration:"):
+			info.Configuration = strings.Fields(strings.TrimPrefix(line, "configuration:"))
+		case strings.HasPrefix(line, "lib"):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+
+			// compile time / runtime version, the latter is what is actually used
+			versions := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, fields[0])), "/", 2)
+			version := versions[len(versions)-1]
+			info.Libraries[fields[0]] = strings.Join(strings.Fields(version), "")
+		}
+	}
+
+	if info.Version == "" {
+		return nil, fmt.Errorf("unable to parse ffmpeg version")
+	}
+
+	return info, nil
+}
+
+var versionCache = struct {
+	sync.Mutex
+	m map[string]*VersionInfo
+}{m: map[string]*VersionInfo{}}
+
+// Version returns version and build configuration of ffmpeg binary, it is cached per binary
+// path since it is invariant.
+func Version(ctx context.Context, ffmpegBinary string) (*VersionInfo, error) {
+	versionCache.Lock()
+	defer versionCache.Unlock()
+
+	if info, ok := versionCache.m[ffmpegBinary]; ok {
+		return info, nil
+	}
+
+	var stdout bytes.Buffer
+	process := &runner{
+		binary:       ffmpegBinary,
+		args:         []string{"-version"},
+		stdoutMode:   stdoutData,
+		stdoutWriter: &stdout,
+	}
+
+	if stderr, err := process.run(ctx); err != nil {
+		return nil, fmt.Errorf("unable to run ffmpeg -version: %w: %s", err, strings.TrimSpace(stderr))
+	}
+
+	info, err := parseVersion(stdout.String())
+	if err != nil {
+		return nil, err
+	}
+
+	versionCache.m[ffmpegBinary] = info
+	return info, nil
+}
@@ -0,0 +1,171 @@
+package hlsvod
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// VMAFTarget describes perceptual quality, that CRF of the encode is searched for. Every step
+// of the search encodes a sample and compares it to the source using libvmaf, that is not part
+// of all ffmpeg builds (--enable-libvmaf). VMAF is computed on CPU at a few frames per second
+// for HD content, so that the search adds roughly 5-20x the sample duration to the encode time.
+type VMAFTarget struct {
+	Score          float64 // Required mean VMAF score, e.g. 93.
+	SampleDuration float64 // Seconds encoded by every search step, 10 when zero.
+	MinCRF         int     // Search range, 18 and 35 when zero, lower CRF means higher quality.
+	MaxCRF         int
+	Model          string // libvmaf model version, e.g. vmaf_4k_v0.6.1, default model when empty.
+}
+
+const (
+	defaultVMAFSample = 10
+	defaultVMAFMinCRF = 18
+	defaultVMAFMaxCRF = 35
+)
+
+func (target *VMAFTarget) validate(config *TranscodeConfig) error {
+	if target.Score <= 0 || target.Score > 100 {
+		return fmt.Errorf("%w: VMAF target must be between 0 and 100", ErrInvalidConfig)
+	}
+
+	if target.SampleDuration < 0 {
+		return fmt.Errorf("%w: VMAF sample duration must not be negative", ErrInvalidConfig)
+	}
+
+	if target.MinCRF < 0 || target.MaxCRF > 51 || (target.MaxCRF > 0 && target.MinCRF > target.MaxCRF) {
+		return fmt.Errorf("%w: VMAF CRF range must be within 1 and 51", ErrInvalidConfig)
+	}
+
+	if config.VideoProfile == nil {
+		return fmt.Errorf("%w: VMAF search requires video profile", ErrInvalidConfig)
+	}
+
+	if len(config.SegmentTimes) < 2 {
+		return ErrTooFewSegmentTimes
+	}
+
+	// stream can be read only once, image has no source to compare to
+	if config.InputReader != nil || config.ImageInput != nil {
+		return fmt.Errorf("%w: VMAF search can be used neither with streamed nor image input", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// SearchVMAFCRF binary searches for the highest CRF, i.e. the smallest output, whose sample
+// reaches the target VMAF score. Sample is taken from the middle of the encode, since starts
+// are often black or static intros, and encoded using the profile without audio. MinCRF is
+// returned if no CRF in the range reaches the target. The CRF is meant to be set as
+// VideoProfile.CRF of the full encode. Returns ErrFilterNotFound, if ffmpeg lacks libvmaf.
+func (e *Encoder) SearchVMAFCRF(ctx context.Context, config TranscodeConfig, target VMAFTarget) (int, error) {
+	e.applyDefaults(&config)
+
+	if err := target.validate(&config); err != nil {
+		return 0, err
+	}
+
+	capabilities, err := cachedCapabilities(ctx, e.ffmpegBinary)
+	if err != nil {
+		return 0, err
+	}
+	if !capabilities.HasFilter("libvmaf") {
+		return 0, fmt.Errorf("%w: ffmpeg is not compiled with libvmaf filter", ErrFilterNotFound)
+	}
+
+	sampleDuration := target.SampleDuration
+	if sampleDuration == 0 {
+		sampleDuration = defaultVMAFSample
+	}
+
+	minCRF, maxCRF := target.MinCRF, target.MaxCRF
+	if minCRF == 0 {
+		minCRF = defaultVMAFMinCRF
+	}
+	if maxCRF == 0 {
+		maxCRF = defaultVMAFMaxCRF
+	}
+
+	start := config.SegmentTimes[0]
+	end := config.SegmentTimes[len(config.SegmentTimes)-1]
+	sampleStart := math.Max(start, (start+end-sampleDuration)/2)
+	sampleEnd := math.Min(end, sampleStart+sampleDuration)
+
+	outputDir, err := os.MkdirTemp(config.TempDir, "hlsvod-vmaf-")
+	if err != nil {
+		return 0, fmt.Errorf("unable to create VMAF directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	sampleConfig := config
+	sampleConfig.AudioProfile = nil
+
+	best := minCRF
+	for low, high := minCRF, maxCRF; low <= high; {
+		crf := (low + high) / 2
+
+		profile := *config.VideoProfile
+		profile.CRF = crf
+		profile.CopyCompatible = false
+
+		_, samplePath, err := e.encodeSample(ctx, &sampleConfig, &profile, sampleStart, sampleEnd, outputDir)
+		if err != nil {
+			return 0, fmt.Errorf("VMAF sample encode failed: %w", err)
+		}
+
+		score, err := e.measureVMAF(ctx, &config, samplePath, config.TrimStart+sampleStart, sampleEnd-sampleStart, target.Model)
+		if err != nil {
+			return 0, err
+		}
+
+		e.logger.Debug().Int("crf", crf).Float64("vmaf", score).Msg("measured VMAF of sample")
+
+		if score >= target.Score {
+			best = crf
+			low = crf + 1
+		} else {
+			high = crf - 1
+		}
+	}
+
+	return best, nil
+}
+
+// e.g. [Parsed_libvmaf_2 @ 0x55d1c0a2c0c0] VMAF score: 93.482114
+var vmafScoreRegexp = regexp.MustCompile(`VMAF score: ([0-9.]+)`)
+
+// compares encoded sample to the source range, distorted video is scaled to the source size
+func (e *Encoder) measureVMAF(ctx context.Context, config *TranscodeConfig, samplePath string, sourceStart, duration float64, model string) (float64, error) {
+	vmaf := "libvmaf"
+	if model != "" {
+		vmaf += "=model=version=" + model
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "info", "-i", samplePath}
+	args = append(args, config.InputOptions.args()...)
+	args = append(args,
+		"-ss", fmt.Sprintf("%.6f", sourceStart),
+		"-t", fmt.Sprintf("%.6f", duration),
+		"-i", config.InputFilePath,
+		"-lavfi", "[0:v:0][1:V:0]scale2ref=flags=bicubic[dist][ref];"+
+			"[dist]setpts=PTS-STARTPTS[d];[ref]setpts=PTS-STARTPTS[r];"+
+			"[d][r]"+vmaf,
+		"-f", "null", "-",
+	)
+
+	process := &runner{binary: e.ffmpegBinary, args: args}
+	stderr, err := process.run(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to measure VMAF: %w", err)
+	}
+
+	match := vmafScoreRegexp.FindStringSubmatch(stderr)
+	if match == nil {
+		return 0, fmt.Errorf("VMAF score was not reported")
+	}
+
+	return strconv.ParseFloat(match[1], 64)
+}
@@ -0,0 +1,83 @@
+package hlsvod
+
+import "fmt"
+
+type WarningKind int
+
+const (
+	// ffmpeg capabilities could not be checked, missing encoders are detected only once ffmpeg fails.
+	WarningCapabilitiesUnknown WarningKind = iota
+	// Video format could not be probed, default profile is used.
+	WarningProbeFailed
+	// Source has variable frame rate, segment durations may drift.
+	WarningVariableFrameRate
+	// Output is larger than the source, since upscaling was allowed.
+	WarningUpscale
+	// Input has no audio stream, audio is skipped or replaced by silence.
+	WarningMissingAudio
+	// Produced segment duration deviates from requested segment times.
+	WarningSegmentDuration
+	// Encode was too slow, remaining segments are encoded using lower fallback profile.
+	WarningSpeedFallback
+	// Output bitrate exceeded target, the encode is repeated with capped bitrate.
+	WarningBitrateExceeded
+	// Produced segment does not start with a keyframe at the requested time.
+	WarningKeyframeMisaligned
+	// Produced segment could not be decoded, it is encoded again.
+	WarningSegmentCorrupt
+)
+
+func (kind WarningKind) String() string {
+	switch kind {
+	case WarningCapabilitiesUnknown:
+		return "capabilities unknown"
+	case WarningProbeFailed:
+		return "probe failed"
+	case WarningVariableFrameRate:
+		return "variable frame rate"
+	case WarningUpscale:
+		return "upscale"
+	case WarningMissingAudio:
+		return "missing audio"
+	case WarningSegmentDuration:
+		return "segment duration"
+	case WarningSpeedFallback:
+		return "speed fallback"
+	case WarningBitrateExceeded:
+		return "bitrate exceeded"
+	case WarningKeyframeMisaligned:
+		return "keyframe misaligned"
+	case WarningSegmentCorrupt:
+		return "segment corrupt"
+	default:
+		return fmt.Sprintf("warning %d", int(kind))
+	}
+}
+
+// Warning is a non-fatal issue, encode continues but its quality may be degraded.
+type Warning struct {
+	Kind    WarningKind
+	Message string
+	Segment string // Segment name, for segment related warnings.
+	Err     error  // Underlying error, if any.
+}
+
+func (w Warning) String() string {
+	str := w.Kind.String()
+	if w.Segment != "" {
+		str += ": " + w.Segment
+	}
+
+	str += ": " + w.Message
+	if w.Err != nil {
+		str += ": " + w.Err.Error()
+	}
+
+	return str
+}
+
+func (config *TranscodeConfig) warn(warning Warning) {
+	if config.WarningHook != nil {
+		config.WarningHook(warning)
+	}
+}
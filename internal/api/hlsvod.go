@@ -150,7 +150,13 @@ func (a *ApiManagerCtx) HlsVod(r chi.Router) {
 				},
 				VideoKeyframes: a.config.Vod.VideoKeyframes,
 				AudioProfile: &hlsvod.AudioProfile{
-					Bitrate: a.config.Vod.AudioProfile.Bitrate,
+					Bitrate:    a.config.Vod.AudioProfile.Bitrate,
+					SampleRate: a.config.Vod.AudioProfile.SampleRate,
+					Channels:   a.config.Vod.AudioProfile.Channels,
+					Encoder:    a.config.Vod.AudioProfile.Encoder,
+					Profile:    hlsvod.AACProfile(a.config.Vod.AudioProfile.Profile),
+
+					CopyCompatible: a.config.Vod.AudioProfile.CopyCompatible,
 				},
 
 				Cache:    a.config.Vod.Cache,
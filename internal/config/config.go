@@ -46,11 +46,17 @@ func (s *Root) Set() {
 type VideoProfile struct {
 	Width   int `mapstructure:"width"`
 	Height  int `mapstructure:"height"`
-	Bitrate int `mapstructure:"bitrate"` // in kilobytes
+	Bitrate int `mapstructure:"bitrate"` // in kbit/s
 }
 
 type AudioProfile struct {
-	Bitrate int `mapstructure:"bitrate"` // in kilobytes
+	Bitrate    int    `mapstructure:"bitrate"`     // in kbit/s
+	SampleRate int    `mapstructure:"sample-rate"` // in Hz
+	Channels   int    `mapstructure:"channels"`
+	Encoder    string `mapstructure:"encoder"` // aac or libfdk_aac
+	Profile    string `mapstructure:"profile"` // empty (LC), aac_he or aac_he_v2
+
+	CopyCompatible bool `mapstructure:"copy-compatible"`
 }
 
 type VOD struct {
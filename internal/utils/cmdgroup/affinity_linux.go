@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package cmdgroup
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// size of the kernel cpu_set_t, enough for 1024 CPUs
+const cpuSetWords = 1024 / 64
+
+type cpuSet [cpuSetWords]uint64
+
+// pid 0 is the calling thread
+func schedAffinity(trap uintptr, set *cpuSet) error {
+	_, _, errno := syscall.RawSyscall(trap, 0, uintptr(len(set)*8), uintptr(unsafe.Pointer(&set[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func platformPinThread(cpus []int) (func(), error) {
+	var mask cpuSet
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= cpuSetWords*64 {
+			return nil, fmt.Errorf("cpu %d out of range", cpu)
+		}
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+
+	runtime.LockOSThread()
+
+	var previous cpuSet
+	if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &previous); err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+
+	if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &mask); err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+
+	return func() {
+		// pinned thread must not run other goroutines
+		if schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &previous) == nil {
+			runtime.UnlockOSThread()
+		}
+	}, nil
+}
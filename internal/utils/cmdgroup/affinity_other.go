@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package cmdgroup
+
+func platformPinThread(cpus []int) (func(), error) {
+	return func() {}, nil
+}
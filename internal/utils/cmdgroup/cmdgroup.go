@@ -13,3 +13,20 @@ func Configure(cmd *exec.Cmd) {
 func Kill(cmd *exec.Cmd) error {
 	return platformKill(cmd)
 }
+
+// SetNice lowers (or raises) scheduling priority of the started command, children
+// spawned afterwards inherit it. Call this after cmd.Start(). Supported only on Unix.
+func SetNice(cmd *exec.Cmd, nice int) error {
+	return platformSetNice(cmd, nice)
+}
+
+// PinThread pins the calling goroutine to its OS thread and restricts the thread to given
+// CPUs, so that command started from it inherits the mask, together with all threads and
+// children it spawns. Go cannot run code between fork and exec, so that this is the only way
+// to pin the command before it starts. Returned restore must be called once the command is
+// started, it restores the mask and unlocks the thread. If the mask cannot be restored, the
+// thread stays locked, so that it is terminated with the goroutine. Supported only on Linux,
+// it is a no-op elsewhere.
+func PinThread(cpus []int) (restore func(), err error) {
+	return platformPinThread(cpus)
+}
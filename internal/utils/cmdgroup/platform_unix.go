@@ -28,3 +28,11 @@ func platformKill(cmd *exec.Cmd) error {
 
 	return syscall.Kill(-pgid, syscall.SIGKILL)
 }
+
+func platformSetNice(cmd *exec.Cmd, nice int) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+
+	return syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, nice)
+}
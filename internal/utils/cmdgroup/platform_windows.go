@@ -4,6 +4,7 @@
 package cmdgroup
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 	"strconv"
@@ -25,3 +26,7 @@ func platformKill(cmd *exec.Cmd) error {
 	kill.Stderr = os.Stderr
 	return kill.Run()
 }
+
+func platformSetNice(cmd *exec.Cmd, nice int) error {
+	return errors.New("nice is not supported on windows")
+}
@@ -0,0 +1,11 @@
+package diskspace
+
+import "errors"
+
+var ErrUnsupported = errors.New("free space check is not supported on this platform")
+
+// Available returns number of bytes, that unprivileged user can write
+// to the filesystem containing given path.
+func Available(path string) (uint64, error) {
+	return platformAvailable(path)
+}
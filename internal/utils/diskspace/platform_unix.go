@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package diskspace
+
+import "syscall"
+
+func platformAvailable(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	// field types differ between platforms
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+// +build windows
+
+package diskspace
+
+func platformAvailable(path string) (uint64, error) {
+	return 0, ErrUnsupported
+}